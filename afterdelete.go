@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// AfterDeleteCommand is a shell command run through sh after files are deleted, to hook the deletion
+// into other automation such as updating a database or sending a notification
+type AfterDeleteCommand struct {
+	Command string
+	// PerBatch runs the command once per deletion batch with the deleted paths on stdin, one per line,
+	// instead of once per file with the path as its argument
+	PerBatch bool
+}
+
+// Run runs the command for the deleted files. In per-file mode it runs for every file even if some runs fail,
+// and returns all failures joined; its output goes to this process's stdout and stderr.
+func (c AfterDeleteCommand) Run(files []FileInfo) error {
+	if c.PerBatch {
+		var paths strings.Builder
+		for _, file := range files {
+			paths.WriteString(file.Path + "\n")
+		}
+		cmd := c.command()
+		cmd.Stdin = strings.NewReader(paths.String())
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("after-delete command for %d files: %w", len(files), err)
+		}
+		return nil
+	}
+
+	var errs []error
+	for _, file := range files {
+		// "$@" appends the path to the command as a properly quoted argument
+		cmd := c.command(file.Path)
+		if err := cmd.Run(); err != nil {
+			errs = append(errs, fmt.Errorf("after-delete command for %s: %w", file.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c AfterDeleteCommand) command(args ...string) *exec.Cmd {
+	script := c.Command
+	if !c.PerBatch {
+		script += ` "$@"`
+	}
+	cmd := exec.Command("sh", append([]string{"-c", script, "sh"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
@@ -0,0 +1,84 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAfterDeleteCommand(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "a"},
+		{"b c.txt", "b"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	files := []FileInfo{{Path: filepath.Join(testDir, "a.txt")}, {Path: filepath.Join(testDir, "b c.txt")}}
+	logPath := filepath.Join(testDir, "log")
+
+	for _, perBatch := range []bool{false, true} {
+		os.Remove(logPath)
+		command := "cat >> '" + logPath + "'"
+		if !perBatch {
+			command = "echo >> '" + logPath + "'"
+		}
+		hook := AfterDeleteCommand{Command: command, PerBatch: perBatch}
+		if err := hook.Run(files); err != nil {
+			t.Fatalf("Unexpected error with perBatch %v: %v", perBatch, err)
+		}
+		logged, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("Failed to read the hook log: %v", err)
+		}
+		want := files[0].Path + "\n" + files[1].Path + "\n"
+		if string(logged) != want {
+			t.Errorf("Unexpected paths passed with perBatch %v: got %q, want %q", perBatch, logged, want)
+		}
+	}
+
+	hook := AfterDeleteCommand{Command: "test '" + files[0].Path + "' ="}
+	err = hook.Run(files)
+	if err == nil || strings.Contains(err.Error(), files[0].Path) || !strings.Contains(err.Error(), files[1].Path) {
+		t.Errorf("Unexpected error for a failing hook: %v", err)
+	}
+}
+
+func TestConfirmAndDeleteAfterBatch(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "a"},
+		{"b.txt", "b"},
+		{"c.txt", "c"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	var files []FileInfo
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		files = append(files, FileInfo{Path: filepath.Join(testDir, name)})
+	}
+
+	var batches int
+	_, err = ConfirmAndDelete(files, DeleteOptions{
+		Confirm:   AlwaysConfirm,
+		BatchSize: 2,
+		AfterBatch: func(batch []FileInfo) error {
+			batches++
+			return AfterDeleteCommand{Command: "false"}.Run(batch)
+		},
+	})
+	if err == nil {
+		t.Fatalf("Expected the failing hook to stop the deletion")
+	}
+	if batches != 1 {
+		t.Errorf("Unexpected number of batches: got %d, want %d", batches, 1)
+	}
+	if _, err := os.Stat(files[2].Path); err != nil {
+		t.Errorf("File %s of the second batch was deleted after the hook failed: %v", files[2].Path, err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// audioExtensions are the file extensions FindSimilarAudio fingerprints
+var audioExtensions = map[string]bool{
+	".mp3": true, ".flac": true, ".ogg": true, ".oga": true, ".opus": true, ".m4a": true, ".aac": true,
+	".wav": true, ".wma": true, ".aiff": true, ".aif": true, ".ape": true, ".wv": true,
+}
+
+// IsAudioFile reports whether path has an audio file extension
+func IsAudioFile(path string) bool {
+	return audioExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// AudioFingerprint is an acoustic fingerprint: one 32-bit sub-fingerprint per ~0.12 s of audio, as computed by
+// Chromaprint. Recordings of the same track in different formats or bitrates have nearly identical fingerprints.
+type AudioFingerprint struct {
+	Duration    float64  `json:"duration"`
+	Fingerprint []uint32 `json:"fingerprint"`
+}
+
+// Fingerprinter computes the fingerprint of an audio file
+type Fingerprinter func(path string) (AudioFingerprint, error)
+
+// FpcalcFingerprinter fingerprints with Chromaprint's fpcalc command line tool, found at command
+// (usually just "fpcalc"), which decodes every common audio format
+func FpcalcFingerprinter(command string) Fingerprinter {
+	return func(path string) (AudioFingerprint, error) {
+		output, err := exec.Command(command, "-raw", "-json", path).Output()
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+				return AudioFingerprint{}, fmt.Errorf("%s: %w: %s", command, err, strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return AudioFingerprint{}, fmt.Errorf("%s: %w", command, err)
+		}
+		return parseFpcalcOutput(output)
+	}
+}
+
+// parseFpcalcOutput parses the output of fpcalc -raw -json
+func parseFpcalcOutput(output []byte) (AudioFingerprint, error) {
+	var fingerprint AudioFingerprint
+	if err := json.Unmarshal(output, &fingerprint); err != nil {
+		return AudioFingerprint{}, fmt.Errorf("parsing fpcalc output: %w", err)
+	}
+	if len(fingerprint.Fingerprint) == 0 {
+		return AudioFingerprint{}, fmt.Errorf("fpcalc returned an empty fingerprint")
+	}
+	return fingerprint, nil
+}
+
+const (
+	// fingerprintMaxOffset is how far, in sub-fingerprints, two fingerprints are shifted against each other to
+	// make up for leading silence or encoder delay; about 10 seconds
+	fingerprintMaxOffset = 80
+	// fingerprintMinOverlap is the fewest sub-fingerprints that must overlap for a comparison to count
+	fingerprintMinOverlap = 40
+	// audioDurationSlack is the largest difference in seconds between the durations of two recordings of a track
+	// that FindSimilarAudio compares, for encoder padding and trimmed silence. It is also the width of the buckets
+	// tracks are sorted into, so a track is only compared with those of its own and the next bucket.
+	audioDurationSlack = 2.0
+)
+
+// FingerprintSimilarity returns the fraction of equal bits of two fingerprints at their best alignment, from
+// about 0.5 for unrelated audio to 1 for the same recording
+func FingerprintSimilarity(a, b []uint32) float64 {
+	best := 0.0
+	for offset := -fingerprintMaxOffset; offset <= fingerprintMaxOffset; offset++ {
+		var differing, overlap int
+		for i := range a {
+			j := i + offset
+			if j < 0 || j >= len(b) {
+				continue
+			}
+			differing += bits.OnesCount32(a[i] ^ b[j])
+			overlap++
+		}
+		if overlap < fingerprintMinOverlap {
+			continue
+		}
+		if similarity := 1 - float64(differing)/float64(32*overlap); similarity > best {
+			best = similarity
+		}
+	}
+	return best
+}
+
+// AudioGroup is a set of audio files that probably hold the same recording
+type AudioGroup struct {
+	Files     []FileInfo
+	Durations []float64
+	// Similarity is the lowest similarity of the pairs that joined the group
+	Similarity float64
+}
+
+// FindSimilarAudio fingerprints the audio files among refFiles and targetFiles, workers at a time, and groups
+// those whose fingerprints are at least threshold similar (see FingerprintSimilarity) and whose durations are
+// within audioDurationSlack of each other. Only pairs of a reference and a target file are compared, or pairs of
+// target files in selfMode, which ignores refFiles. Files that cannot be fingerprinted are passed to onError, if not nil, and left
+// out. The result is heuristic; groups are ordered by their first path.
+func FindSimilarAudio(refFiles, targetFiles []FileInfo, selfMode bool, fingerprint Fingerprinter, threshold float64, workers int, onError func(path string, err error)) []AudioGroup {
+	type track struct {
+		file        FileInfo
+		ref         bool
+		fingerprint AudioFingerprint
+		err         error
+	}
+	if selfMode {
+		refFiles = nil
+	}
+	var candidates []track
+	for _, file := range refFiles {
+		if IsAudioFile(file.Path) {
+			candidates = append(candidates, track{file: file, ref: true})
+		}
+	}
+	for _, file := range targetFiles {
+		if IsAudioFile(file.Path) {
+			candidates = append(candidates, track{file: file})
+		}
+	}
+
+	// fpcalc decodes the whole file, so fingerprint in parallel like hashing
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				candidates[i].fingerprint, candidates[i].err = fingerprint(candidates[i].file.Path)
+			}
+		}()
+	}
+	for i := range candidates {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var tracks []track
+	for _, candidate := range candidates {
+		if candidate.err != nil {
+			if onError != nil {
+				onError(candidate.file.Path, candidate.err)
+			}
+			continue
+		}
+		tracks = append(tracks, candidate)
+	}
+	buckets := make(map[int][]int) // map[duration / audioDurationSlack]track indexes
+	for i, t := range tracks {
+		bucket := int(t.fingerprint.Duration / audioDurationSlack)
+		buckets[bucket] = append(buckets[bucket], i)
+	}
+
+	// union-find over the similar pairs
+	parent := make([]int, len(tracks))
+	similarity := make([]float64, len(tracks))
+	for i := range parent {
+		parent[i] = i
+		similarity[i] = 1
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	compare := func(i, j int) {
+		if !selfMode && tracks[i].ref == tracks[j].ref {
+			return
+		}
+		if math.Abs(tracks[i].fingerprint.Duration-tracks[j].fingerprint.Duration) > audioDurationSlack {
+			return
+		}
+		s := FingerprintSimilarity(tracks[i].fingerprint.Fingerprint, tracks[j].fingerprint.Fingerprint)
+		if s < threshold {
+			return
+		}
+		rootI, rootJ := find(i), find(j)
+		if rootI != rootJ {
+			parent[rootJ] = rootI
+			similarity[rootI] = math.Min(similarity[rootI], similarity[rootJ])
+		}
+		similarity[rootI] = math.Min(similarity[rootI], s)
+	}
+	for bucket, members := range buckets {
+		for a, i := range members {
+			for _, j := range members[a+1:] {
+				compare(i, j)
+			}
+			for _, j := range buckets[bucket+1] {
+				compare(i, j)
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range tracks {
+		members[find(i)] = append(members[find(i)], i)
+	}
+	var groups []AudioGroup
+	for root, indexes := range members {
+		if len(indexes) < 2 {
+			continue
+		}
+		sort.Slice(indexes, func(a, b int) bool { return tracks[indexes[a]].file.Path < tracks[indexes[b]].file.Path })
+		group := AudioGroup{Similarity: similarity[root]}
+		for _, i := range indexes {
+			group.Files = append(group.Files, tracks[i].file)
+			group.Durations = append(group.Durations, tracks[i].fingerprint.Duration)
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Files[0].Path < groups[j].Files[0].Path })
+	return groups
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestIsAudioFile(t *testing.T) {
+	for path, want := range map[string]bool{
+		"music/song.mp3":  true,
+		"music/SONG.FLAC": true,
+		"music/take.m4a":  true,
+		"music/cover.jpg": false,
+		"music/notes":     false,
+	} {
+		if got := IsAudioFile(path); got != want {
+			t.Errorf("Unexpected IsAudioFile(%q): got %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestParseFpcalcOutput(t *testing.T) {
+	fingerprint, err := parseFpcalcOutput([]byte(`{"duration": 181.46, "fingerprint": [1, 2, 4294967295]}`))
+	if err != nil {
+		t.Fatalf("Error parsing fpcalc output: %v", err)
+	}
+	if fingerprint.Duration != 181.46 || len(fingerprint.Fingerprint) != 3 || fingerprint.Fingerprint[2] != 4294967295 {
+		t.Errorf("Unexpected fingerprint: %+v", fingerprint)
+	}
+
+	if _, err := parseFpcalcOutput([]byte(`{"duration": 1.5, "fingerprint": []}`)); err == nil {
+		t.Error("Expected an error for an empty fingerprint")
+	}
+	if _, err := parseFpcalcOutput([]byte("ERROR: could not open the file")); err == nil {
+		t.Error("Expected an error for output that is not JSON")
+	}
+}
+
+// randomFingerprint returns n pseudo-random sub-fingerprints, reproducible for a seed
+func randomFingerprint(seed int64, n int) []uint32 {
+	r := rand.New(rand.NewSource(seed))
+	fingerprint := make([]uint32, n)
+	for i := range fingerprint {
+		fingerprint[i] = r.Uint32()
+	}
+	return fingerprint
+}
+
+// noisyCopy flips one bit in every sub-fingerprint, like a re-encode at another bitrate
+func noisyCopy(fingerprint []uint32) []uint32 {
+	noisy := make([]uint32, len(fingerprint))
+	for i, sub := range fingerprint {
+		noisy[i] = sub ^ 1<<(i%32)
+	}
+	return noisy
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	original := randomFingerprint(1, 200)
+
+	if got := FingerprintSimilarity(original, original); got != 1 {
+		t.Errorf("Unexpected similarity of identical fingerprints: got %v, want 1", got)
+	}
+	// leading silence shifts the whole fingerprint
+	shifted := append(randomFingerprint(2, 10), original...)
+	if got := FingerprintSimilarity(original, shifted); got != 1 {
+		t.Errorf("Unexpected similarity of shifted fingerprints: got %v, want 1", got)
+	}
+	if got := FingerprintSimilarity(original, noisyCopy(original)); got < 0.96 || got > 0.97 {
+		t.Errorf("Unexpected similarity of a noisy copy: got %v, want 31/32", got)
+	}
+	if got := FingerprintSimilarity(original, randomFingerprint(3, 200)); got > 0.6 {
+		t.Errorf("Unexpected similarity of unrelated fingerprints: got %v, want about 0.5", got)
+	}
+	if got := FingerprintSimilarity(original[:10], original[:10]); got != 0 {
+		t.Errorf("Unexpected similarity below the minimum overlap: got %v, want 0", got)
+	}
+}
+
+func TestFindSimilarAudio(t *testing.T) {
+	song := randomFingerprint(10, 300)
+	fingerprints := map[string]AudioFingerprint{
+		"a/song.flac":    {Duration: 180, Fingerprint: song},
+		"b/song.mp3":     {Duration: 180.5, Fingerprint: noisyCopy(song)},
+		"c/song.ogg":     {Duration: 179, Fingerprint: append(randomFingerprint(11, 5), song...)},
+		"d/other.mp3":    {Duration: 181, Fingerprint: randomFingerprint(12, 300)},
+		"e/extended.mp3": {Duration: 240, Fingerprint: song}, // same audio, but far longer
+	}
+	fingerprinter := func(path string) (AudioFingerprint, error) {
+		fingerprint, ok := fingerprints[path]
+		if !ok {
+			return AudioFingerprint{}, errors.New("cannot decode")
+		}
+		return fingerprint, nil
+	}
+	files := []FileInfo{
+		{Path: "a/song.flac"}, {Path: "b/song.mp3"}, {Path: "c/song.ogg"}, {Path: "d/other.mp3"},
+		{Path: "e/extended.mp3"}, {Path: "f/broken.wav"}, {Path: "g/cover.jpg"},
+	}
+
+	var failed []string
+	groups := FindSimilarAudio(nil, files, true, fingerprinter, 0.85, 4, func(path string, err error) {
+		failed = append(failed, path)
+	})
+
+	if len(failed) != 1 || failed[0] != "f/broken.wav" {
+		t.Errorf("Unexpected fingerprint failures: got %v, want [f/broken.wav]", failed)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("Unexpected number of groups: got %d, want 1", len(groups))
+	}
+	group := groups[0]
+	want := []string{"a/song.flac", "b/song.mp3", "c/song.ogg"}
+	if len(group.Files) != len(want) {
+		t.Fatalf("Unexpected group size: got %d, want %d", len(group.Files), len(want))
+	}
+	for i, path := range want {
+		if group.Files[i].Path != path {
+			t.Errorf("Unexpected file %d: got %s, want %s", i, group.Files[i].Path, path)
+		}
+		if group.Durations[i] != fingerprints[path].Duration {
+			t.Errorf("Unexpected duration of %s: got %v, want %v", path, group.Durations[i], fingerprints[path].Duration)
+		}
+	}
+	if group.Similarity < 0.96 || group.Similarity >= 1 {
+		t.Errorf("Unexpected group similarity: got %v, want 31/32", group.Similarity)
+	}
+}
+
+func TestFindSimilarAudioAcrossSides(t *testing.T) {
+	song, other := randomFingerprint(20, 300), randomFingerprint(21, 300)
+	fingerprints := map[string]AudioFingerprint{
+		"ref/song.flac":     {Duration: 200, Fingerprint: song},
+		"ref/other.flac":    {Duration: 200, Fingerprint: other},
+		"ref/other.mp3":     {Duration: 200, Fingerprint: noisyCopy(other)},
+		"target/song.mp3":   {Duration: 201.5, Fingerprint: noisyCopy(song)},
+		"target/song.ogg":   {Duration: 199, Fingerprint: song},
+		"target/edited.mp3": {Duration: 210, Fingerprint: song}, // beyond the slack
+	}
+	fingerprinter := func(path string) (AudioFingerprint, error) { return fingerprints[path], nil }
+	ref := []FileInfo{{Path: "ref/song.flac"}, {Path: "ref/other.flac"}, {Path: "ref/other.mp3"}}
+	target := []FileInfo{{Path: "target/song.mp3"}, {Path: "target/song.ogg"}, {Path: "target/edited.mp3"}}
+
+	// the two recordings of other are both in the reference, and the target copies of song only match through it
+	groups := FindSimilarAudio(ref, target, false, fingerprinter, 0.85, 2, nil)
+	if len(groups) != 1 {
+		t.Fatalf("Unexpected number of groups: got %d, want 1: %+v", len(groups), groups)
+	}
+	var paths []string
+	for _, file := range groups[0].Files {
+		paths = append(paths, file.Path)
+	}
+	if want := []string{"ref/song.flac", "target/song.mp3", "target/song.ogg"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Unexpected group: got %v, want %v", paths, want)
+	}
+
+	// an empty reference, whose walk leaves Files nil, matches nothing rather than the target against itself
+	if groups := FindSimilarAudio(nil, target, false, fingerprinter, 0.85, 2, nil); len(groups) != 0 {
+		t.Errorf("Unexpected groups against an empty reference: %+v", groups)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// MaxFooterSize caps the footer a body hash holds back until it knows where the file ends: SkipTailBytes, and
+// the last SkipTailLines lines. Without it, a file with no line breaks would be held in memory as one long line.
+const MaxFooterSize = 1 << 20
+
+// ErrFooterTooLong is returned by CalculateHashes when the last SkipTailLines lines do not fit in MaxFooterSize.
+// Hash is still set, but BodyHash is left empty, so the file matches no other by body.
+var ErrFooterTooLong = errors.New("lines too long to hold back as a footer, -skipTailLines is meant for text files")
+
+// BodyRange describes the header and footer to leave out when hashing the "content body" of a file,
+// e.g. to ignore a timestamp embedded in the first lines of otherwise identical reports
+type BodyRange struct {
+	SkipHeadBytes int64 `yaml:"skipHeadBytes,omitempty"`
+	SkipHeadLines int   `yaml:"skipHeadLines,omitempty"`
+	SkipTailBytes int64 `yaml:"skipTailBytes,omitempty"`
+	// SkipTailLines buffers whole lines, so it is meant for text files
+	SkipTailLines int `yaml:"skipTailLines,omitempty"`
+}
+
+// IsZero reports whether the range skips nothing
+func (r BodyRange) IsZero() bool {
+	return r == BodyRange{}
+}
+
+// String describes the range by the flags that set it, e.g. "-skipHeadLines 2 -skipTailBytes 16"
+func (r BodyRange) String() string {
+	var parts []string
+	for _, part := range []struct {
+		flag string
+		n    int64
+	}{{"-skipHeadBytes", r.SkipHeadBytes}, {"-skipHeadLines", int64(r.SkipHeadLines)}, {"-skipTailBytes", r.SkipTailBytes}, {"-skipTailLines", int64(r.SkipTailLines)}} {
+		if part.n != 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", part.flag, part.n))
+		}
+	}
+	if len(parts) == 0 {
+		return "nothing"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Validate checks that the range can be applied
+func (r BodyRange) Validate() error {
+	if r.SkipHeadBytes < 0 || r.SkipHeadLines < 0 || r.SkipTailBytes < 0 || r.SkipTailLines < 0 {
+		return errors.New("header and footer sizes must not be negative")
+	}
+	if r.SkipTailBytes > 0 && r.SkipTailLines > 0 {
+		return errors.New("skip either footer bytes or footer lines, not both")
+	}
+	if r.SkipTailBytes > MaxFooterSize {
+		return fmt.Errorf("the footer must not be larger than %d bytes", MaxFooterSize)
+	}
+	return nil
+}
+
+// HashReader returns the hex SHA-256 digest of everything read from r
+func HashReader(r io.Reader) (string, error) {
+	return HashReaderWith(r, DefaultHashAlgo)
+}
+
+// HashReaderWith returns the hex digest of everything read from r using the named algorithm
+func HashReaderWith(r io.Reader, algo string) (string, error) {
+	hasher, err := newHasher(algo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// CalculateHashes sets both the full-file Hash and the BodyHash for body, reading the file only once.
+// Both use the algorithm named by HashAlgo.
+func (f *FileInfo) CalculateHashes(body BodyRange) error {
+	file, err := os.Open(longPath(f.Path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return f.hashesFrom(file, body)
+}
+
+// hashesFrom sets Hash and BodyHash from the content read from r, as CalculateHashes does
+func (f *FileInfo) hashesFrom(r io.Reader, body BodyRange) error {
+	fullHasher, err := newHasher(f.HashAlgo)
+	if err != nil {
+		return err
+	}
+	bodyHash, bodyErr := HashReaderWith(NewBodyReader(io.TeeReader(r, fullHasher), body), f.HashAlgo)
+	if bodyErr != nil && !errors.Is(bodyErr, ErrFooterTooLong) {
+		return bodyErr
+	}
+	// the body reader may stop short of EOF while skipping, so make sure the full hash sees everything
+	if _, err := io.Copy(fullHasher, r); err != nil {
+		return err
+	}
+	f.Hash = fmt.Sprintf("%x", fullHasher.Sum(nil))
+	f.BodyHash = bodyHash
+	return bodyErr
+}
+
+// NewBodyReader wraps r so that reads leave out the header and footer described by body
+func NewBodyReader(r io.Reader, body BodyRange) io.Reader {
+	return &bodyReader{src: bufio.NewReader(r), body: body}
+}
+
+type bodyReader struct {
+	src      *bufio.Reader
+	body     BodyRange
+	headDone bool
+	eof      bool
+	out      bytes.Buffer
+	// held back until we know they are not part of the footer, at most MaxFooterSize bytes of lines
+	heldLines [][]byte
+	heldSize  int
+	heldBytes []byte
+	chunk     []byte
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	for b.out.Len() == 0 && !b.eof {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	if b.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.out.Read(p)
+}
+
+func (b *bodyReader) fill() error {
+	if !b.headDone {
+		b.headDone = true
+		if _, err := io.CopyN(io.Discard, b.src, b.body.SkipHeadBytes); err == io.EOF {
+			b.eof = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for i := 0; i < b.body.SkipHeadLines; i++ {
+			if err := b.skipLine(); err == io.EOF {
+				b.eof = true
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	if b.body.SkipTailLines > 0 {
+		line, err := b.readHeldLine()
+		if len(line) > 0 {
+			b.heldLines = append(b.heldLines, line)
+			b.heldSize += len(line)
+		}
+		if err == io.EOF {
+			// a final line without newline still counts as a line
+			for len(b.heldLines) > b.body.SkipTailLines {
+				b.releaseLine()
+			}
+			b.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// keep the last SkipTailLines complete lines, any of which may turn out to be the footer
+		if len(b.heldLines) > b.body.SkipTailLines {
+			b.releaseLine()
+		}
+		return nil
+	}
+
+	if b.chunk == nil {
+		// reading at least as much as is held back keeps the shifting below linear in the file size
+		size := int64(32 * 1024)
+		if b.body.SkipTailBytes > size {
+			size = b.body.SkipTailBytes
+		}
+		b.chunk = make([]byte, size)
+	}
+	n, err := b.src.Read(b.chunk)
+	b.heldBytes = append(b.heldBytes, b.chunk[:n]...)
+	if excess := int64(len(b.heldBytes)) - b.body.SkipTailBytes; excess > 0 {
+		b.out.Write(b.heldBytes[:excess])
+		b.heldBytes = append(b.heldBytes[:0], b.heldBytes[excess:]...)
+	}
+	if err == io.EOF {
+		b.eof = true
+		return nil
+	}
+	return err
+}
+
+// skipLine discards the rest of the current line without holding it in memory
+func (b *bodyReader) skipLine() error {
+	for {
+		if _, err := b.src.ReadSlice('\n'); err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// readHeldLine reads the next line to hold back, failing with ErrFooterTooLong rather than holding more than
+// MaxFooterSize bytes
+func (b *bodyReader) readHeldLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := b.src.ReadSlice('\n')
+		if b.heldSize+len(line)+len(chunk) > MaxFooterSize {
+			return nil, fmt.Errorf("the last %d lines are over %d bytes: %w", b.body.SkipTailLines, MaxFooterSize, ErrFooterTooLong)
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// releaseLine passes the oldest held line on to the body
+func (b *bodyReader) releaseLine() {
+	b.out.Write(b.heldLines[0])
+	b.heldSize -= len(b.heldLines[0])
+	b.heldLines = b.heldLines[1:]
+}
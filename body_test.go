@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestBodyReader(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		body  BodyRange
+		want  string
+	}{
+		{"nothing skipped", "abcdef", BodyRange{}, "abcdef"},
+		{"head and tail bytes", "abcdef", BodyRange{SkipHeadBytes: 2, SkipTailBytes: 1}, "cde"},
+		{"tail bytes beyond input", "abc", BodyRange{SkipTailBytes: 10}, ""},
+		{"head lines", "generated 2024-01-01\nbody 1\nbody 2\n", BodyRange{SkipHeadLines: 1}, "body 1\nbody 2\n"},
+		{"tail lines", "body 1\nbody 2\nfooter 1\nfooter 2\n", BodyRange{SkipTailLines: 2}, "body 1\nbody 2\n"},
+		{"tail line without newline", "body 1\nbody 2\nfooter", BodyRange{SkipTailLines: 1}, "body 1\nbody 2\n"},
+		{"head lines beyond input", "only\n", BodyRange{SkipHeadLines: 3}, ""},
+	} {
+		got, err := io.ReadAll(NewBodyReader(strings.NewReader(tc.input), tc.body))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCompareFilesBodyHash(t *testing.T) {
+	refDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"report.txt", "exported 2024-01-01 10:00\nrevenue 100\n"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+	targetDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"report.txt", "exported 2024-02-03 17:45\nrevenue 100\n"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(targetDir)
+
+	walkOpts := WalkOptions{HashWorkers: 1, Body: BodyRange{SkipHeadLines: 1}}
+	refDirInfo, err := WalkDirectoryWithOptions(refDir, walkOpts)
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	targetDirInfo, err := WalkDirectoryWithOptions(targetDir, walkOpts)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+
+	plain := FileInfo{Path: refDirInfo.Files[0].Path}
+	if err := plain.CalculateHash(); err != nil || plain.Hash != refDirInfo.Files[0].Hash {
+		t.Errorf("Full-file hash differs from CalculateHash: %s != %s (%v)", refDirInfo.Files[0].Hash, plain.Hash, err)
+	}
+	if refDirInfo.Files[0].Hash == targetDirInfo.Files[0].Hash {
+		t.Errorf("Full-file hashes should differ")
+	}
+	if duplicates := CompareFiles(refDirInfo, targetDirInfo, true); len(duplicates) != 0 {
+		t.Errorf("Full-file comparison matched differing files: %v", duplicates)
+	}
+	duplicates := CompareFilesWithOptions(refDirInfo, targetDirInfo, CompareOptions{ExactPathMatch: true, UseBodyHash: true})
+	if len(duplicates) != 1 || duplicates[0].Path != filepath.Join(targetDir, "report.txt") {
+		t.Errorf("Unexpected body hash duplicates: %v", duplicates)
+	}
+}
+
+// createSparseFile creates a file of size zero bytes in dir without writing them, where the file system allows
+func createSparseFile(t *testing.T, dir, name string, size int64) string {
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		t.Fatalf("Failed to extend %s: %v", path, err)
+	}
+	return path
+}
+
+// allocatedBy returns how many bytes fn allocates
+func allocatedBy(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestBodyHashLargeFileBoundedMemory(t *testing.T) {
+	testDir, err := createTestFiles(nil)
+	if err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	// no line breaks at all, the worst case for skipping lines
+	const size = 256 << 20
+	path := createSparseFile(t, testDir, "large.bin", size)
+	plain := FileInfo{Path: path, HashAlgo: "xxhash"}
+	if err := plain.CalculateHash(); err != nil {
+		t.Fatalf("Error hashing %s: %v", path, err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		body    BodyRange
+		wantErr bool
+	}{
+		{"head lines", BodyRange{SkipHeadLines: 1}, false},
+		{"tail bytes", BodyRange{SkipTailBytes: 1000}, false},
+		{"tail lines", BodyRange{SkipTailLines: 1}, true},
+	} {
+		file := FileInfo{Path: path, HashAlgo: "xxhash"}
+		var err error
+		allocated := allocatedBy(func() { err = file.CalculateHashes(tc.body) })
+		if allocated > 8*MaxFooterSize {
+			t.Errorf("%s: allocated %d bytes hashing a %d byte file", tc.name, allocated, size)
+		}
+		if (err != nil) != tc.wantErr || (err != nil && !errors.Is(err, ErrFooterTooLong)) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if err != nil && file.BodyHash != "" {
+			t.Errorf("%s: expected no body hash when the footer is too long, got %s", tc.name, file.BodyHash)
+		}
+		if file.Hash != plain.Hash {
+			t.Errorf("%s: full hash differs from CalculateHash: %s != %s", tc.name, file.Hash, plain.Hash)
+		}
+	}
+
+	if err := (BodyRange{SkipTailBytes: MaxFooterSize + 1}).Validate(); err == nil {
+		t.Errorf("Expected an error for a footer above %d bytes", MaxFooterSize)
+	}
+}
+
+func TestWalkBodyHashLinesTooLong(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"notes.txt", "header\nbody\nfooter\n"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	// a binary file with no line breaks must not fail the walk
+	createSparseFile(t, testDir, "zeros.bin", 3<<20)
+
+	dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{Body: BodyRange{SkipTailLines: 1}})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if len(dirInfo.Files) != 2 {
+		t.Fatalf("Unexpected number of files: got %d, want 2", len(dirInfo.Files))
+	}
+	for _, file := range dirInfo.Files {
+		if file.Hash == "" {
+			t.Errorf("Expected a full hash for %s", file.Path)
+		}
+		if tooLong := filepath.Base(file.Path) == "zeros.bin"; tooLong != (file.BodyHash == "") {
+			t.Errorf("Unexpected body hash for %s: %q", file.Path, file.BodyHash)
+		}
+	}
+}
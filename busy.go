@@ -0,0 +1,13 @@
+package main
+
+import "errors"
+
+// ErrFileBusy is returned for files that another process holds open or locked, see WalkOptions.SkipBusy
+var ErrFileBusy = errors.New("file is in use by another process")
+
+// FileBusy reports whether another process holds the file at path in a way that would make reading or deleting
+// it fail or race. The check is best effort: on Unix it only sees processes holding a flock on the file, on
+// Windows those that opened it without sharing it, and elsewhere it never reports a file busy.
+func FileBusy(path string) (bool, error) {
+	return fileBusy(longPath(path))
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package main
+
+// fileBusy cannot tell on this platform
+func fileBusy(path string) (bool, error) {
+	return false, nil
+}
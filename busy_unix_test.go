@@ -0,0 +1,85 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// holdLock takes an exclusive flock on path through its own open file, like another process would
+func holdLock(t *testing.T, path string) *os.File {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		t.Fatalf("Failed to lock %s: %v", path, err)
+	}
+	return file
+}
+
+func TestFileBusy(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{{"a.txt", "a"}})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	path := filepath.Join(testDir, "a.txt")
+
+	if busy, err := FileBusy(path); busy || err != nil {
+		t.Errorf("Unexpected result for an idle file: %v, %v", busy, err)
+	}
+	locked := holdLock(t, path)
+	if busy, err := FileBusy(path); !busy || err != nil {
+		t.Errorf("Unexpected result for a locked file: %v, %v", busy, err)
+	}
+	locked.Close()
+	if busy, err := FileBusy(path); busy || err != nil {
+		t.Errorf("Unexpected result once the lock is released: %v, %v", busy, err)
+	}
+	if _, err := FileBusy(filepath.Join(testDir, "missing.txt")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestWalkSkipsBusyFiles(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"idle.txt", "idle"},
+		{"sub/busy.txt", "busy"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	busyPath := filepath.Join(testDir, "sub", "busy.txt")
+	locked := holdLock(t, busyPath)
+	defer locked.Close()
+
+	var summary Summary
+	dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{
+		SkipBusy: true,
+		Hooks:    &Hooks{OnComplete: func(s Summary) { summary = s }},
+	})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if len(dirInfo.Files) != 1 || filepath.Base(dirInfo.Files[0].Path) != "idle.txt" {
+		t.Errorf("Unexpected files: %v", dirInfo.Files)
+	}
+	if len(summary.Busy) != 1 || summary.Busy[0] != busyPath {
+		t.Errorf("Unexpected busy files: got %v, want [%s]", summary.Busy, busyPath)
+	}
+
+	// without SkipBusy an advisory lock does not keep the file from being read
+	dirInfo, err = WalkDirectoryWithOptions(testDir, WalkOptions{})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if len(dirInfo.Files) != 2 {
+		t.Errorf("Unexpected number of files without SkipBusy: got %d, want 2", len(dirInfo.Files))
+	}
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// fileBusy opens the file without sharing it, which fails while any other process has it open
+func fileBusy(path string) (bool, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return false, err
+	}
+	handle, err := windows.CreateFile(name, windows.GENERIC_READ, 0, nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == windows.ERROR_SHARING_VIOLATION || err == windows.ERROR_LOCK_VIOLATION {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, windows.CloseHandle(handle)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// DuplicateCluster is one group of identical files and the file kept of it, the structured counterpart of the
+// per-file deletion plan
+type DuplicateCluster struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	// Keeper is the reference file in ref/target comparisons, or the file kept by the keeper policy in self mode
+	Keeper string `json:"keeper"`
+	// Members are all paths of the cluster, the keeper first and then its duplicates
+	Members []string `json:"members"`
+}
+
+// ClustersFromDuplicates groups duplicates by the file they duplicate, ordered by keeper path.
+// The hash is the body hash for duplicates found by body.
+func ClustersFromDuplicates(duplicates []Duplicate) []DuplicateCluster {
+	byKeeper := make(map[string]*DuplicateCluster)
+	for _, duplicate := range duplicates {
+		cluster, ok := byKeeper[duplicate.RefPath]
+		if !ok {
+			hash := duplicate.File.Hash
+			if duplicate.File.BodyHash != "" {
+				hash = duplicate.File.BodyHash
+			}
+			cluster = &DuplicateCluster{Hash: hash, Size: duplicate.File.Size, Keeper: duplicate.RefPath, Members: []string{duplicate.RefPath}}
+			byKeeper[duplicate.RefPath] = cluster
+		}
+		cluster.Members = append(cluster.Members, duplicate.File.Path)
+	}
+
+	clusters := make([]DuplicateCluster, 0, len(byKeeper))
+	for _, cluster := range byKeeper {
+		clusters = append(clusters, *cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Keeper < clusters[j].Keeper })
+	return clusters
+}
+
+// WriteClusters writes clusters to w as an indented JSON array
+func WriteClusters(w io.Writer, clusters []DuplicateCluster) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(clusters)
+}
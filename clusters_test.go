@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestClustersFromDuplicates(t *testing.T) {
+	duplicates := []Duplicate{
+		{File: FileInfo{Path: "/t/b1", Hash: "bb", Size: 2}, RefPath: "/r/b"},
+		{File: FileInfo{Path: "/t/a1", Hash: "aa", Size: 1}, RefPath: "/r/a"},
+		{File: FileInfo{Path: "/t/b2", Hash: "bb", Size: 2}, RefPath: "/r/b"},
+		{File: FileInfo{Path: "/t/c1", Hash: "cc", BodyHash: "cb", Size: 3}, RefPath: "/r/c"},
+	}
+	want := []DuplicateCluster{
+		{Hash: "aa", Size: 1, Keeper: "/r/a", Members: []string{"/r/a", "/t/a1"}},
+		{Hash: "bb", Size: 2, Keeper: "/r/b", Members: []string{"/r/b", "/t/b1", "/t/b2"}},
+		{Hash: "cb", Size: 3, Keeper: "/r/c", Members: []string{"/r/c", "/t/c1"}},
+	}
+	clusters := ClustersFromDuplicates(duplicates)
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("Unexpected clusters: got %+v, want %+v", clusters, want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteClusters(&buf, clusters); err != nil {
+		t.Fatalf("Failed to write clusters: %v", err)
+	}
+	var decoded []DuplicateCluster
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded, want) {
+		t.Errorf("Unexpected clusters after a round trip: got %+v (%v)", decoded, err)
+	}
+
+	buf.Reset()
+	if err := WriteClusters(&buf, ClustersFromDuplicates(nil)); err != nil || buf.String() != "[]\n" {
+		t.Errorf("Unexpected output without duplicates: %q (%v)", buf.String(), err)
+	}
+}
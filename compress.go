@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressLevel is the zstd level used for .zst manifests unless configured otherwise
+const DefaultCompressLevel = 3
+
+// zstdExt marks manifest and index paths that are zstd compressed
+const zstdExt = ".zst"
+
+// isCompressed reports whether path names a zstd compressed manifest or index
+func isCompressed(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), zstdExt)
+}
+
+// validateCompressLevel checks a zstd level
+func validateCompressLevel(level int) error {
+	if level < 1 || level > 22 {
+		return fmt.Errorf("zstd level must be between 1 and 22, got %d", level)
+	}
+	return nil
+}
+
+// openManifest opens a manifest or index for reading, decompressing it on the fly if path ends in .zst
+func openManifest(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isCompressed(path) {
+		return file, nil
+	}
+	decoder, err := zstd.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &zstdReadCloser{decoder: decoder, file: file}, nil
+}
+
+type zstdReadCloser struct {
+	decoder *zstd.Decoder
+	file    *os.File
+}
+
+func (r *zstdReadCloser) Read(p []byte) (int, error) {
+	return r.decoder.Read(p)
+}
+
+func (r *zstdReadCloser) Close() error {
+	r.decoder.Close()
+	return r.file.Close()
+}
+
+// createManifest creates a manifest or index for writing, compressing it at level on the fly if path ends in .zst.
+// Close must be called, and its error checked, to flush the output.
+func createManifest(path string, level int) (io.WriteCloser, error) {
+	if err := validateCompressLevel(level); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	w := &manifestWriter{file: file, buffered: buffered, out: buffered}
+	if isCompressed(path) {
+		encoder, err := zstd.NewWriter(buffered, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		w.encoder, w.out = encoder, encoder
+	}
+	return w, nil
+}
+
+// manifestWriter writes through an optional zstd encoder and a buffer into a file
+type manifestWriter struct {
+	file     *os.File
+	buffered *bufio.Writer
+	encoder  *zstd.Encoder
+	out      io.Writer
+}
+
+func (w *manifestWriter) Write(p []byte) (int, error) {
+	return w.out.Write(p)
+}
+
+func (w *manifestWriter) Close() error {
+	if w.encoder != nil {
+		if err := w.encoder.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	if err := w.buffered.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompressedManifestRoundTrip(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "compressdir")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	dirInfo := &DirectoryInfo{BaseDir: "/ref: odd", Files: []FileInfo{
+		{Path: "/ref: odd/a.txt", Hash: "abc", Size: 3, ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Path: "/ref: odd/b.txt", Hash: "def", HashAlgo: "xxhash"},
+	}}
+	zstdMagic := []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	for _, name := range []string{"ref.yml", "ref.yml.zst"} {
+		path := filepath.Join(testDir, name)
+		if err := writeDirectoryInfoToYAML(dirInfo, path, DefaultCompressLevel); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Error reading %s: %v", name, err)
+		}
+		if compressed := bytes.HasPrefix(data, zstdMagic); compressed != isCompressed(path) {
+			t.Errorf("Unexpected compression of %s: got %v", name, compressed)
+		}
+
+		loaded, err := readDirectoryInfoFromYAML(path)
+		if err != nil {
+			t.Fatalf("Error loading %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(loaded, dirInfo) {
+			t.Errorf("Unexpected %s contents: got %+v, want %+v", name, loaded, dirInfo)
+		}
+	}
+
+	indexPath := filepath.Join(testDir, "ref.idx.zst")
+	if err := WriteIndexWithLevel(indexPath, dirInfo, 19); err != nil {
+		t.Fatalf("Error writing compressed index: %v", err)
+	}
+	loaded, err := ReadIndex(indexPath)
+	if err != nil {
+		t.Fatalf("Error reading compressed index: %v", err)
+	}
+	if len(loaded.Files) != len(dirInfo.Files) || loaded.Files[1].HashAlgo != "xxhash" {
+		t.Errorf("Unexpected compressed index contents: %+v", loaded)
+	}
+
+	if err := WriteIndexWithLevel(indexPath, dirInfo, 23); err == nil {
+		t.Errorf("Expected an error for zstd level 23")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// ApplyConfig sets the flags of fs from a YAML file, or a TOML file if path ends in .toml. Keys are flag names
+// without the dash, values are scalars, or lists for repeatable flags such as keepPattern. Flags already set on
+// the command line are left alone, so they override the file. Unknown keys are an error.
+func ApplyConfig(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{})
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f := fs.Lookup(key)
+		if f == nil || key == "config" {
+			return fmt.Errorf("%s: unknown option %q", path, key)
+		}
+		if onCommandLine[key] {
+			continue
+		}
+		if err := setConfigValue(f, values[key]); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// setConfigValue sets f to a decoded config value, once per element for a list
+func setConfigValue(f *flag.Flag, value interface{}) error {
+	var elements []interface{}
+	switch value := value.(type) {
+	case []interface{}:
+		if _, repeatable := f.Value.(*stringList); !repeatable {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		elements = value
+	case map[interface{}]interface{}, map[string]interface{}:
+		return fmt.Errorf("expected a value, got a mapping")
+	default:
+		elements = []interface{}{value}
+	}
+	for _, element := range elements {
+		// a key without a value decodes to nil, which would otherwise be set as "<nil>"
+		if element == nil {
+			return fmt.Errorf("expected a value, got none")
+		}
+		if err := f.Value.Set(fmt.Sprint(element)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
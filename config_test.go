@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newConfigTestFlags() (*flag.FlagSet, *options) {
+	opts := &options{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&opts.TargetDir, "targetDir", "", "")
+	fs.IntVar(&opts.HashWorkers, "hashWorkers", 1, "")
+	fs.BoolVar(&opts.DeleteFiles, "deleteFiles", false, "")
+	fs.DurationVar(&opts.DeletePause, "deletePause", 0, "")
+	fs.Float64Var(&opts.SampleRate, "sampleRate", 1, "")
+	fs.Var(&opts.KeepPatterns, "keepPattern", "")
+	return fs, opts
+}
+
+func TestApplyConfig(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"config.yml", "targetDir: /data\nhashWorkers: 4\ndeleteFiles: true\ndeletePause: 500ms\nsampleRate: 2.5\nkeepPattern: [\"*/keep/*\", \"*.orig\"]\n"},
+		{"config.toml", "targetDir = \"/data\"\nhashWorkers = 4\ndeleteFiles = true\ndeletePause = \"500ms\"\nsampleRate = 2.5\nkeepPattern = [\"*/keep/*\", \"*.orig\"]\n"},
+		{"unknown.yml", "targetDir: /data\ntargetDri: /oops\n"},
+		{"list.yml", "targetDir: [a, b]\n"},
+		{"null.yml", "hashWorkers: 4\ntargetDir:\n"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	for _, name := range []string{"config.yml", "config.toml"} {
+		fs, opts := newConfigTestFlags()
+		if err := fs.Parse([]string{"-hashWorkers", "8"}); err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		if err := ApplyConfig(fs, filepath.Join(testDir, name)); err != nil {
+			t.Fatalf("Unexpected error applying %s: %v", name, err)
+		}
+		if opts.TargetDir != "/data" || !opts.DeleteFiles || opts.DeletePause != 500*time.Millisecond || opts.SampleRate != 2.5 {
+			t.Errorf("Unexpected options from %s: %+v", name, opts)
+		}
+		if opts.HashWorkers != 8 {
+			t.Errorf("Unexpected hashWorkers from %s, the command line should win: got %d, want %d", name, opts.HashWorkers, 8)
+		}
+		if want := (stringList{"*/keep/*", "*.orig"}); !reflect.DeepEqual(opts.KeepPatterns, want) {
+			t.Errorf("Unexpected keepPattern from %s: got %v, want %v", name, opts.KeepPatterns, want)
+		}
+	}
+
+	fs, _ := newConfigTestFlags()
+	if err := ApplyConfig(fs, filepath.Join(testDir, "unknown.yml")); err == nil || !strings.Contains(err.Error(), "targetDri") {
+		t.Errorf("Unexpected error for an unknown key: %v", err)
+	}
+	fs, _ = newConfigTestFlags()
+	if err := ApplyConfig(fs, filepath.Join(testDir, "list.yml")); err == nil {
+		t.Errorf("Expected an error for a list given to a single-valued option")
+	}
+	fs, opts := newConfigTestFlags()
+	if err := ApplyConfig(fs, filepath.Join(testDir, "null.yml")); err == nil || !strings.Contains(err.Error(), "targetDir") {
+		t.Errorf("Unexpected error for a key without a value: %v", err)
+	}
+	if opts.TargetDir == "<nil>" {
+		t.Errorf("A key without a value was set as %q", opts.TargetDir)
+	}
+}
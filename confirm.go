@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Confirmer approves or refuses deleting files before anything is removed, e.g. by asking the user,
+// showing a dialog or checking a policy. A non-nil error aborts the deletion like a refusal.
+type Confirmer func(files []FileInfo) (bool, error)
+
+// PromptConfirmer asks on out and approves if the next line read from in is "yes"
+func PromptConfirmer(in io.Reader, out io.Writer) Confirmer {
+	reader := bufio.NewReader(in)
+	return func(files []FileInfo) (bool, error) {
+		fmt.Fprint(out, "Are you sure you want to delete the files? Type 'yes' to confirm: ")
+		input, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		return strings.TrimSpace(input) == "yes", nil
+	}
+}
+
+// AlwaysConfirm approves every deletion, for unattended use
+func AlwaysConfirm(files []FileInfo) (bool, error) {
+	return true, nil
+}
+
+// DeleteOptions controls ConfirmAndDelete
+type DeleteOptions struct {
+	// Confirm approves the deletion; nil prompts on stdin and stdout
+	Confirm Confirmer
+	// BatchSize, Pause and Progress are passed on to DeleteFilesBatched
+	BatchSize int
+	Pause     time.Duration
+	Progress  func(deleted, total int)
+	// Verify, if set, is called right before each file is removed, e.g. with a RehashVerifier. Files it returns
+	// an error for are kept and passed to Skipped along with the error.
+	Verify  func(file FileInfo) error
+	Skipped func(file FileInfo, err error)
+	// AfterBatch, if set, is called with every batch once its files are deleted, e.g. to run an
+	// AfterDeleteCommand. Returning an error stops the deletion before the next batch.
+	AfterBatch func(batch []FileInfo) error
+	// Removed, if set, is called right after each file is removed, e.g. with TransactionLog.Done
+	Removed func(file FileInfo)
+}
+
+// ConfirmAndDelete asks opts.Confirm for approval and deletes files if given, reporting whether it deleted them
+func ConfirmAndDelete(files []FileInfo, opts DeleteOptions) (bool, error) {
+	confirm := opts.Confirm
+	if confirm == nil {
+		confirm = PromptConfirmer(os.Stdin, os.Stdout)
+	}
+	approved, err := confirm(files)
+	if err != nil || !approved {
+		return false, err
+	}
+	var keep func(file FileInfo) bool
+	if opts.Verify != nil {
+		keep = func(file FileInfo) bool {
+			err := opts.Verify(file)
+			if err != nil && opts.Skipped != nil {
+				opts.Skipped(file, err)
+			}
+			return err != nil
+		}
+	}
+	return true, deleteInBatches(files, opts.BatchSize, opts.Pause, keep, opts.Removed, func(batch []FileInfo, deleted int) error {
+		if opts.Progress != nil {
+			opts.Progress(deleted, len(files))
+		}
+		if opts.AfterBatch != nil {
+			return opts.AfterBatch(batch)
+		}
+		return nil
+	})
+}
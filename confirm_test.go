@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfirmAndDelete(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "a"},
+		{"b.txt", "b"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	files := []FileInfo{{Path: filepath.Join(testDir, "a.txt")}, {Path: filepath.Join(testDir, "b.txt")}}
+
+	var asked []FileInfo
+	refuse := func(files []FileInfo) (bool, error) {
+		asked = files
+		return false, nil
+	}
+	deleted, err := ConfirmAndDelete(files, DeleteOptions{Confirm: refuse})
+	if err != nil || deleted {
+		t.Fatalf("Unexpected result of a refused deletion: deleted %v, err %v", deleted, err)
+	}
+	if len(asked) != len(files) {
+		t.Errorf("Unexpected files passed to the confirmer: %v", asked)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file.Path); err != nil {
+			t.Errorf("File %s is gone after a refused deletion: %v", file.Path, err)
+		}
+	}
+
+	failing := func(files []FileInfo) (bool, error) { return true, errors.New("policy service unreachable") }
+	if deleted, err := ConfirmAndDelete(files, DeleteOptions{Confirm: failing}); err == nil || deleted {
+		t.Errorf("Unexpected result of a failed confirmation: deleted %v, err %v", deleted, err)
+	}
+
+	deleted, err = ConfirmAndDelete(files, DeleteOptions{Confirm: AlwaysConfirm})
+	if err != nil || !deleted {
+		t.Fatalf("Unexpected result of an approved deletion: deleted %v, err %v", deleted, err)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+			t.Errorf("File %s still exists after an approved deletion: %v", file.Path, err)
+		}
+	}
+}
+
+func TestPromptConfirmer(t *testing.T) {
+	for input, want := range map[string]bool{"yes\n": true, " yes \n": true, "y\n": false, "no\n": false, "": false} {
+		var out bytes.Buffer
+		approved, err := PromptConfirmer(strings.NewReader(input), &out)(nil)
+		if err != nil {
+			t.Fatalf("Error confirming %q: %v", input, err)
+		}
+		if approved != want {
+			t.Errorf("Unexpected approval for %q: got %v, want %v", input, approved, want)
+		}
+		if !strings.Contains(out.String(), "Type 'yes' to confirm") {
+			t.Errorf("Unexpected prompt: %q", out.String())
+		}
+	}
+}
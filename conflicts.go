@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// Conflict is a pair of files at the same relative path in the reference and target trees with different content
+type Conflict struct {
+	RelPath string
+	Ref     FileInfo
+	Target  FileInfo
+}
+
+// Newer returns "ref" or "target" for whichever side was modified later, or "" if unknown or equal
+func (c Conflict) Newer() string {
+	if c.Ref.ModTime.IsZero() || c.Target.ModTime.IsZero() || c.Ref.ModTime.Equal(c.Target.ModTime) {
+		return ""
+	}
+	if c.Target.ModTime.After(c.Ref.ModTime) {
+		return "target"
+	}
+	return "ref"
+}
+
+// FindConflicts returns the files present at the same relative path in both trees but with different hashes,
+// the divergent versions that exact-path duplicate detection silently skips
+func FindConflicts(refDir *DirectoryInfo, targetDir *DirectoryInfo) []Conflict {
+	refFiles := make(map[string]FileInfo)
+	for _, file := range refDir.Files {
+		refFiles[matchKey(refDir, file, true)] = file
+	}
+
+	var conflicts []Conflict
+	for _, file := range targetDir.Files {
+		relPath := matchKey(targetDir, file, true)
+		if refFile, ok := refFiles[relPath]; ok && refFile.Hash != file.Hash {
+			conflicts = append(conflicts, Conflict{RelPath: filepath.ToSlash(relPath), Ref: refFile, Target: file})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].RelPath < conflicts[j].RelPath })
+	return conflicts
+}
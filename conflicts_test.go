@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindConflicts(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/same.txt", Hash: "a"},
+		{Path: "/ref/edited.txt", Hash: "b", ModTime: older},
+		{Path: "/ref/only-ref.txt", Hash: "c"},
+	}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{
+		{Path: "/target/same.txt", Hash: "a"},
+		{Path: "/target/edited.txt", Hash: "d", ModTime: newer},
+		{Path: "/target/only-target.txt", Hash: "c"},
+	}}
+
+	conflicts := FindConflicts(refDir, targetDir)
+	if len(conflicts) != 1 {
+		t.Fatalf("Unexpected conflicts: %v", conflicts)
+	}
+	if conflicts[0].RelPath != "edited.txt" || conflicts[0].Ref.Hash != "b" || conflicts[0].Target.Hash != "d" {
+		t.Errorf("Unexpected conflict: %+v", conflicts[0])
+	}
+	if newerSide := conflicts[0].Newer(); newerSide != "target" {
+		t.Errorf("Unexpected newer side: got %q, want target", newerSide)
+	}
+}
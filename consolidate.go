@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConsolidateResult summarizes a consolidation run
+type ConsolidateResult struct {
+	Copied  int
+	Skipped int
+	Renamed int
+}
+
+// ConsolidateDirectories copies every unique file from the given directories into outDir.
+// Directories are processed in order, so the first-seen relative path wins for duplicated content.
+// Files with new content whose relative path is already taken get a numbered suffix.
+func ConsolidateDirectories(outDir string, dirs ...*DirectoryInfo) (*ConsolidateResult, error) {
+	result := &ConsolidateResult{}
+	seenHashes := make(map[string]bool)
+	usedPaths := make(map[string]bool)
+
+	for _, dirInfo := range dirs {
+		// hashing workers finish in arbitrary order, so sort to make "first seen" deterministic
+		files := append([]FileInfo(nil), dirInfo.Files...)
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+		for _, file := range files {
+			if seenHashes[file.Hash] {
+				result.Skipped++
+				continue
+			}
+			seenHashes[file.Hash] = true
+
+			relPath, err := filepath.Rel(dirInfo.BaseDir, file.Path)
+			if err != nil {
+				return result, err
+			}
+			destRelPath := uniqueRelPath(outDir, relPath, usedPaths)
+			if destRelPath != relPath {
+				result.Renamed++
+			}
+			usedPaths[destRelPath] = true
+
+			if err := copyFile(file.Path, filepath.Join(outDir, destRelPath)); err != nil {
+				return result, err
+			}
+			result.Copied++
+		}
+	}
+	return result, nil
+}
+
+// uniqueRelPath returns relPath, or relPath with a numbered suffix before the extension if it is already used
+// by this run or by an existing entry in outDir
+func uniqueRelPath(outDir, relPath string, usedPaths map[string]bool) string {
+	taken := func(candidate string) bool {
+		if usedPaths[candidate] {
+			return true
+		}
+		_, err := os.Lstat(longPath(filepath.Join(outDir, candidate)))
+		return err == nil
+	}
+	if !taken(relPath) {
+		return relPath
+	}
+	ext := filepath.Ext(relPath)
+	stem := strings.TrimSuffix(relPath, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s.%d%s", stem, i, ext)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// copyFile copies src to dest, creating parent directories and preserving mode and modification time.
+// It refuses to overwrite an existing file at dest.
+func copyFile(src, dest string) error {
+	srcFile, err := os.Open(longPath(src))
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	destFile, err := os.OpenFile(longPath(dest), os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, srcFile); err != nil {
+		destFile.Close()
+		return err
+	}
+	if err := destFile.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
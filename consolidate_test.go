@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConsolidateDirectories(t *testing.T) {
+	refDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"file1.txt", "This is file 1"},
+		{"subdir/file2.txt", "This is file 2"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+
+	targetDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"file1.txt", "Different file 1"},      // path collision, new content
+		{"moved/file2.txt", "This is file 2"},  // duplicate content
+		{"subdir/file3.txt", "This is file 3"}, // unique
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(targetDir)
+
+	outDir, err := os.MkdirTemp("", "consolidated")
+	if err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+	defer removeTestFiles(outDir)
+
+	refDirInfo, err := WalkDirectory(refDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	targetDirInfo, err := WalkDirectory(targetDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+
+	result, err := ConsolidateDirectories(outDir, refDirInfo, targetDirInfo)
+	if err != nil {
+		t.Fatalf("Error consolidating directories: %v", err)
+	}
+	if result.Copied != 4 || result.Skipped != 1 || result.Renamed != 1 {
+		t.Errorf("Unexpected consolidate result: %+v", result)
+	}
+
+	expected := map[string]string{
+		"file1.txt":        "This is file 1",
+		"file1.1.txt":      "Different file 1",
+		"subdir/file2.txt": "This is file 2",
+		"subdir/file3.txt": "This is file 3",
+	}
+	for relPath, content := range expected {
+		data, err := os.ReadFile(filepath.Join(outDir, relPath))
+		if err != nil {
+			t.Errorf("Missing consolidated file %s: %v", relPath, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("Unexpected content for %s: got %q, want %q", relPath, data, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "moved/file2.txt")); !os.IsNotExist(err) {
+		t.Errorf("Duplicate content was copied to moved/file2.txt")
+	}
+}
+
+func TestConsolidateDirectoriesExistingDestination(t *testing.T) {
+	srcDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"notes.txt", "new notes"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(srcDir)
+
+	outDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"notes.txt", "old notes"},
+		{"notes.1.txt", "older notes"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(outDir)
+
+	srcDirInfo, err := WalkDirectory(srcDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking source directory: %v", err)
+	}
+	result, err := ConsolidateDirectories(outDir, srcDirInfo)
+	if err != nil {
+		t.Fatalf("Error consolidating into a non-empty directory: %v", err)
+	}
+	if result.Copied != 1 || result.Renamed != 1 {
+		t.Errorf("Unexpected consolidate result: %+v", result)
+	}
+	for relPath, content := range map[string]string{"notes.txt": "old notes", "notes.1.txt": "older notes", "notes.2.txt": "new notes"} {
+		if data, err := os.ReadFile(filepath.Join(outDir, relPath)); err != nil || string(data) != content {
+			t.Errorf("Unexpected content for %s: got %q (%v), want %q", relPath, data, err, content)
+		}
+	}
+}
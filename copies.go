@@ -0,0 +1,35 @@
+package main
+
+import "os"
+
+// FindCopies returns the target files with the same content as the file at refPath, in any location and under
+// any name. The reference file is hashed once for every algorithm used in targetDir, so it matches regardless of
+// per-extension algorithm choices. The reference file itself is never reported.
+func FindCopies(refPath string, targetDir *DirectoryInfo) ([]FileInfo, error) {
+	info, err := os.Stat(longPath(refPath))
+	if err != nil {
+		return nil, err
+	}
+	absRefPath := absOrClean(refPath)
+
+	refHashes := make(map[string]string) // map[algorithm]hash
+	var copies []FileInfo
+	for _, file := range targetDir.Files {
+		if file.Hash == "" || file.Size != info.Size() || absOrClean(file.Path) == absRefPath {
+			continue
+		}
+		refHash, ok := refHashes[file.HashAlgo]
+		if !ok {
+			ref := FileInfo{Path: refPath, HashAlgo: file.HashAlgo}
+			if err := ref.CalculateHash(); err != nil {
+				return nil, err
+			}
+			refHash = ref.Hash
+			refHashes[file.HashAlgo] = refHash
+		}
+		if file.Hash == refHash {
+			copies = append(copies, file)
+		}
+	}
+	return copies, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFindCopies(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"original.mp4", "frames"},
+		{"backup/renamed.bin", "frames"},
+		{"backup/old/original.mp4", "frames"},
+		{"other.mp4", "FRAMES"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	// hash videos differently from everything else, the reference still has to match both
+	targetDir, err := WalkDirectoryWithOptions(testDir, WalkOptions{HashWorkers: 1, Hash: HashPolicy{ByExt: map[string]string{".mp4": "xxhash"}}})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	copies, err := FindCopies(filepath.Join(testDir, "original.mp4"), targetDir)
+	if err != nil {
+		t.Fatalf("Error finding copies: %v", err)
+	}
+
+	var paths []string
+	for _, file := range copies {
+		relPath, _ := filepath.Rel(testDir, file.Path)
+		paths = append(paths, filepath.ToSlash(relPath))
+	}
+	sort.Strings(paths)
+	if got, want := strings.Join(paths, ","), "backup/old/original.mp4,backup/renamed.bin"; got != want {
+		t.Errorf("Unexpected copies: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import "sort"
+
+// GrownGroup is a duplicate group present in both runs that gained copies
+type GrownGroup struct {
+	Before DuplicateGroup
+	After  DuplicateGroup
+	// Added are the files of After not in Before
+	Added []FileInfo
+}
+
+// GroupDelta is the difference between the duplicate groups of two runs, matched by hash and xattr digest
+type GroupDelta struct {
+	// New groups only exist in the later run
+	New []DuplicateGroup
+	// Resolved groups only exist in the earlier run
+	Resolved []DuplicateGroup
+	Grown    []GrownGroup
+}
+
+// DiffDuplicateGroups compares the duplicate groups of an earlier and a later run.
+// Groups that kept or lost copies without disappearing are not reported.
+func DiffDuplicateGroups(before, after []DuplicateGroup) GroupDelta {
+	beforeByKey := make(map[string]DuplicateGroup)
+	for _, group := range before {
+		beforeByKey[group.key()] = group
+	}
+	afterKeys := make(map[string]bool)
+
+	var delta GroupDelta
+	for _, group := range after {
+		afterKeys[group.key()] = true
+		previous, ok := beforeByKey[group.key()]
+		if !ok {
+			delta.New = append(delta.New, group)
+			continue
+		}
+		previousPaths := make(map[string]bool)
+		for _, file := range previous.Files {
+			previousPaths[file.Path] = true
+		}
+		var added []FileInfo
+		for _, file := range group.Files {
+			if !previousPaths[file.Path] {
+				added = append(added, file)
+			}
+		}
+		if len(group.Files) > len(previous.Files) {
+			delta.Grown = append(delta.Grown, GrownGroup{Before: previous, After: group, Added: added})
+		}
+	}
+	for _, group := range before {
+		if !afterKeys[group.key()] {
+			delta.Resolved = append(delta.Resolved, group)
+		}
+	}
+
+	sort.Slice(delta.New, func(i, j int) bool { return delta.New[i].key() < delta.New[j].key() })
+	sort.Slice(delta.Resolved, func(i, j int) bool { return delta.Resolved[i].key() < delta.Resolved[j].key() })
+	sort.Slice(delta.Grown, func(i, j int) bool { return delta.Grown[i].After.key() < delta.Grown[j].After.key() })
+	return delta
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestDiffDuplicateGroups(t *testing.T) {
+	before := []DuplicateGroup{
+		{Hash: "aaa", Files: []FileInfo{{Path: "a1"}, {Path: "a2"}}},
+		{Hash: "bbb", Files: []FileInfo{{Path: "b1"}, {Path: "b2"}}},
+		{Hash: "ccc", Files: []FileInfo{{Path: "c1"}, {Path: "c2"}, {Path: "c3"}}},
+	}
+	after := []DuplicateGroup{
+		{Hash: "aaa", Files: []FileInfo{{Path: "a1"}, {Path: "a2"}, {Path: "a3"}}},
+		{Hash: "ccc", Files: []FileInfo{{Path: "c1"}, {Path: "c2"}}}, // shrunk, not reported
+		{Hash: "ddd", Files: []FileInfo{{Path: "d1"}, {Path: "d2"}}},
+	}
+
+	delta := DiffDuplicateGroups(before, after)
+	if len(delta.New) != 1 || delta.New[0].Hash != "ddd" {
+		t.Errorf("Unexpected new groups: %+v", delta.New)
+	}
+	if len(delta.Resolved) != 1 || delta.Resolved[0].Hash != "bbb" {
+		t.Errorf("Unexpected resolved groups: %+v", delta.Resolved)
+	}
+	if len(delta.Grown) != 1 || delta.Grown[0].After.Hash != "aaa" || len(delta.Grown[0].Added) != 1 || delta.Grown[0].Added[0].Path != "a3" {
+		t.Errorf("Unexpected grown groups: %+v", delta.Grown)
+	}
+}
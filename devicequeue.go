@@ -0,0 +1,89 @@
+package main
+
+import "sync"
+
+// deviceQueueLimit is how many files of one device a deviceQueue holds before adding more of them waits for the
+// hashing to catch up. It is per device, so a slow disk with a full queue does not hold up files on other disks.
+const deviceQueueLimit = 10000
+
+// deviceQueue hands out files to hash so that at most perDevice of them are hashed at a time on each device,
+// taking the devices in turn. Files of a device at its limit wait in the queue while files on other devices
+// go ahead, so a slow disk cannot occupy every hash worker.
+type deviceQueue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	perDevice int
+	// limit caps the files queued per device; deviceQueueLimit outside tests
+	limit   int
+	queued  map[uint64][]FileInfo
+	running map[uint64]int
+	// devices lists every device seen, in the order they are taken from
+	devices []uint64
+	next    int
+	total   int
+	closed  bool
+}
+
+func newDeviceQueue(perDevice int) *deviceQueue {
+	q := &deviceQueue{perDevice: perDevice, limit: deviceQueueLimit, queued: make(map[uint64][]FileInfo), running: make(map[uint64]int)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// add queues file, found on device, waiting while the queue of that device is full
+func (q *deviceQueue) add(file FileInfo, device uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.queued[device]) >= q.limit {
+		q.cond.Wait()
+	}
+	if _, seen := q.running[device]; !seen {
+		q.running[device] = 0
+		q.devices = append(q.devices, device)
+	}
+	q.queued[device] = append(q.queued[device], file)
+	q.total++
+	q.cond.Broadcast()
+}
+
+// close tells take that no more files are coming
+func (q *deviceQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// take waits for a file whose device is below its limit and reserves a slot on that device, which done frees.
+// It returns false once the queue is closed and empty.
+func (q *deviceQueue) take() (FileInfo, uint64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.total == 0 && q.closed {
+			return FileInfo{}, 0, false
+		}
+		for i := range q.devices {
+			device := q.devices[(q.next+i)%len(q.devices)]
+			files := q.queued[device]
+			if len(files) == 0 || q.running[device] >= q.perDevice {
+				continue
+			}
+			q.next = (q.next + i + 1) % len(q.devices)
+			q.queued[device] = files[1:]
+			q.total--
+			q.running[device]++
+			q.cond.Broadcast()
+			return files[0], device, true
+		}
+		q.cond.Wait()
+	}
+}
+
+// done frees the slot take reserved on device
+func (q *deviceQueue) done(device uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running[device]--
+	q.cond.Broadcast()
+}
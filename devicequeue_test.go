@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeviceQueue(t *testing.T) {
+	q := newDeviceQueue(1)
+	q.add(FileInfo{Path: "/slow/1"}, 1)
+	q.add(FileInfo{Path: "/slow/2"}, 1)
+	q.add(FileInfo{Path: "/fast/1"}, 2)
+	q.add(FileInfo{Path: "/fast/2"}, 2)
+
+	// while a file of device 1 is being hashed, only files of device 2 are handed out
+	first, device, _ := q.take()
+	if first.Path != "/slow/1" || device != 1 {
+		t.Fatalf("Unexpected first file: %s on device %d", first.Path, device)
+	}
+	second, _, _ := q.take()
+	q.done(2)
+	third, _, _ := q.take()
+	if second.Path != "/fast/1" || third.Path != "/fast/2" {
+		t.Errorf("Unexpected files while device 1 is busy: got %s and %s, want /fast/1 and /fast/2", second.Path, third.Path)
+	}
+	q.done(2)
+	q.done(1)
+	q.close()
+	if last, _, ok := q.take(); !ok || last.Path != "/slow/2" {
+		t.Errorf("Unexpected last file: %s (ok %v)", last.Path, ok)
+	}
+	q.done(1)
+	if _, _, ok := q.take(); ok {
+		t.Error("Expected a closed and empty queue to hand out nothing")
+	}
+}
+
+func TestDeviceQueueConcurrent(t *testing.T) {
+	const perDevice, devices, files = 2, 3, 300
+	q := newDeviceQueue(perDevice)
+	var mu sync.Mutex
+	running := make(map[uint64]int)
+	taken := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, device, ok := q.take()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				running[device]++
+				if running[device] > perDevice {
+					t.Errorf("%d files of device %d hashed at once, want at most %d", running[device], device, perDevice)
+				}
+				taken++
+				mu.Unlock()
+				mu.Lock()
+				running[device]--
+				mu.Unlock()
+				q.done(device)
+			}
+		}()
+	}
+	for i := 0; i < files; i++ {
+		q.add(FileInfo{Path: fmt.Sprintf("/f%d", i)}, uint64(i%devices))
+	}
+	q.close()
+	wg.Wait()
+	if taken != files {
+		t.Errorf("Unexpected number of files handed out: got %d, want %d", taken, files)
+	}
+}
+
+func TestDeviceQueueSlowDevice(t *testing.T) {
+	q := newDeviceQueue(1)
+	q.limit = 2
+	q.add(FileInfo{Path: "/slow/1"}, 1)
+	q.add(FileInfo{Path: "/slow/2"}, 1)
+	// a worker stuck hashing a file of the slow device
+	if _, device, _ := q.take(); device != 1 {
+		t.Fatalf("Unexpected device of the first file: %d", device)
+	}
+	q.add(FileInfo{Path: "/slow/3"}, 1)
+
+	// the slow device's queue is full, which must not hold up files on the fast one
+	const fastFiles = 5
+	added := make(chan struct{})
+	go func() {
+		for i := 0; i < fastFiles; i++ {
+			q.add(FileInfo{Path: fmt.Sprintf("/fast/%d", i)}, 2)
+		}
+		close(added)
+	}()
+
+	var mu sync.Mutex
+	taken := make(map[uint64]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, device, ok := q.take()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				taken[device]++
+				mu.Unlock()
+				q.done(device)
+			}
+		}()
+	}
+
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Adding files of the fast device waited for the slow device")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		fast, slow := taken[2], taken[1]
+		mu.Unlock()
+		if slow != 0 {
+			t.Fatalf("A second file of the slow device was handed out while one was being hashed")
+		}
+		if fast == fastFiles {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Only %d of %d files of the fast device were hashed while the slow device was busy", fast, fastFiles)
+		}
+	}
+
+	q.done(1)
+	q.close()
+	wg.Wait()
+	if taken[1] != 2 {
+		t.Errorf("Unexpected number of files of the slow device handed out after it was done: got %d, want 2", taken[1])
+	}
+}
+
+func TestWalkDirectoryMaxHashPerDevice(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "a"},
+		{"sub/b.txt", "b"},
+		{"sub/c.txt", "c"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{HashWorkers: 4, MaxHashPerDevice: 1})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if len(dirInfo.Files) != 3 {
+		t.Fatalf("Unexpected number of files: got %d, want 3", len(dirInfo.Files))
+	}
+	for _, file := range dirInfo.Files {
+		if file.Hash == "" || file.Device != 0 {
+			t.Errorf("Unexpected entry: %+v", file)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultEdgeBlockSize is the number of bytes read from each end of a file for its EdgeHash
+const DefaultEdgeBlockSize = 4096
+
+// CalculateEdgeHash sets EdgeHash from the size and the first and last blockSize bytes of the file, using the
+// algorithm named by HashAlgo. Only those blocks are read, so it is fast on large files, but files differing
+// only in between share it. Files of at most twice blockSize are read whole.
+func (f *FileInfo) CalculateEdgeHash(blockSize int64) error {
+	if blockSize <= 0 {
+		return fmt.Errorf("edge block size must be positive, got %d", blockSize)
+	}
+	hasher, err := newHasher(f.HashAlgo)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(longPath(f.Path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	binary.Write(hasher, binary.BigEndian, size)
+	if size <= 2*blockSize {
+		if _, err := io.Copy(hasher, file); err != nil {
+			return err
+		}
+	} else {
+		if _, err := io.Copy(hasher, io.NewSectionReader(file, 0, blockSize)); err != nil {
+			return err
+		}
+		if _, err := io.Copy(hasher, io.NewSectionReader(file, size-blockSize, blockSize)); err != nil {
+			return err
+		}
+	}
+	f.Size = size
+	f.EdgeHash = fmt.Sprintf("%x", hasher.Sum(nil))
+	return nil
+}
+
+// ConfirmMatches full-hashes the target and reference file of every duplicate found without full hashes (see
+// CompareOptions.UseEdgeHash and NameAndSize) and returns those whose content hashes are equal, with File.Hash set.
+// Each reference file is hashed once however many duplicates it has. Files that cannot be hashed are passed to
+// onError, if not nil, and their duplicates left out.
+func ConfirmMatches(candidates []Duplicate, onError func(path string, err error)) []Duplicate {
+	refHashes := make(map[string]string) // map[reference path]hash, "" if it could not be hashed
+	hash := func(path, algo string) (string, bool) {
+		file := FileInfo{Path: path, HashAlgo: algo}
+		if err := file.CalculateHash(); err != nil {
+			if onError != nil {
+				onError(path, err)
+			}
+			return "", false
+		}
+		return file.Hash, true
+	}
+
+	var confirmed []Duplicate
+	for _, candidate := range candidates {
+		refHash, ok := refHashes[candidate.RefPath]
+		if !ok {
+			refHash, _ = hash(candidate.RefPath, candidate.File.HashAlgo)
+			refHashes[candidate.RefPath] = refHash
+		}
+		if refHash == "" {
+			continue
+		}
+		targetHash, ok := hash(candidate.File.Path, candidate.File.HashAlgo)
+		if !ok || targetHash != refHash {
+			continue
+		}
+		candidate.File.Hash = targetHash
+		confirmed = append(confirmed, candidate)
+	}
+	return confirmed
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCalculateEdgeHash(t *testing.T) {
+	head, middle, tail := strings.Repeat("h", 16), strings.Repeat("m", 32), strings.Repeat("t", 16)
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"original", head + middle + tail},
+		{"sameEdges", head + strings.Repeat("x", 32) + tail},
+		{"otherTail", head + middle + strings.Repeat("u", 16)},
+		{"longer", head + middle + middle + tail},
+		{"small1", "abcdefgh"},
+		{"small2", "abcdefgi"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	edgeHash := func(name string) string {
+		file := FileInfo{Path: filepath.Join(testDir, name)}
+		if err := file.CalculateEdgeHash(16); err != nil {
+			t.Fatalf("Error calculating edge hash of %s: %v", name, err)
+		}
+		if file.Hash != "" {
+			t.Errorf("Unexpected full hash of %s: got %s, want none", name, file.Hash)
+		}
+		return file.EdgeHash
+	}
+
+	original := edgeHash("original")
+	if got := edgeHash("sameEdges"); got != original {
+		t.Errorf("Files differing only in the middle should share an edge hash: got %s, want %s", got, original)
+	}
+	if edgeHash("otherTail") == original {
+		t.Error("Files with different last blocks should not share an edge hash")
+	}
+	if edgeHash("longer") == original {
+		t.Error("Files of different sizes should not share an edge hash")
+	}
+	// files within two blocks are read whole
+	if edgeHash("small1") == edgeHash("small2") {
+		t.Error("Small files with different content should not share an edge hash")
+	}
+
+	file := FileInfo{Path: filepath.Join(testDir, "original")}
+	if err := file.CalculateEdgeHash(0); err == nil {
+		t.Error("Expected an error for a zero block size")
+	}
+}
+
+func TestEdgeHashMatchAndConfirm(t *testing.T) {
+	head, tail := strings.Repeat("h", 16), strings.Repeat("t", 16)
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref/copy.bin", head + "same middle" + tail},
+		{"ref/changed.bin", head + "old middle!" + tail},
+		{"ref/gone.bin", head + "vanishes..." + tail},
+		{"target/copy.bin", head + "same middle" + tail},
+		{"target/changed.bin", head + "new middle!" + tail},
+		{"target/gone.bin", head + "vanishes..." + tail},
+		{"target/unique.bin", "something else entirely"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	walkOpts := WalkOptions{HashWorkers: 2, EdgeBlockSize: 16}
+	refDirInfo, err := WalkDirectoryWithOptions(filepath.Join(testDir, "ref"), walkOpts)
+	if err != nil {
+		t.Fatalf("Error walking reference: %v", err)
+	}
+	targetDirInfo, err := WalkDirectoryWithOptions(filepath.Join(testDir, "target"), walkOpts)
+	if err != nil {
+		t.Fatalf("Error walking target: %v", err)
+	}
+
+	if matches := CompareFilesWithOptions(refDirInfo, targetDirInfo, CompareOptions{}); len(matches) != 0 {
+		t.Errorf("Unexpected matches without full hashes: got %d, want 0", len(matches))
+	}
+	var candidates []Duplicate
+	for duplicate := range CompareFilesStream(refDirInfo, targetDirInfo, CompareOptions{UseEdgeHash: true}) {
+		candidates = append(candidates, duplicate)
+	}
+	if len(candidates) != 3 {
+		t.Fatalf("Unexpected number of edge matches: got %d, want 3", len(candidates))
+	}
+
+	if err := os.Remove(filepath.Join(testDir, "ref", "gone.bin")); err != nil {
+		t.Fatalf("Failed to remove reference file: %v", err)
+	}
+	var failed []string
+	confirmed := ConfirmMatches(candidates, func(path string, err error) {
+		failed = append(failed, filepath.Base(path))
+	})
+	if len(confirmed) != 1 || filepath.Base(confirmed[0].File.Path) != "copy.bin" {
+		t.Fatalf("Unexpected confirmed duplicates: %v", confirmed)
+	}
+	want, _ := HashReaderWith(strings.NewReader(head+"same middle"+tail), "")
+	if confirmed[0].File.Hash != want {
+		t.Errorf("Unexpected hash of the confirmed duplicate: got %s, want %s", confirmed[0].File.Hash, want)
+	}
+	if len(failed) != 1 || failed[0] != "gone.bin" {
+		t.Errorf("Unexpected confirmation failures: got %v, want [gone.bin]", failed)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// ErrorLog records the paths a walk could not process as JSON lines of the form {"path": ..., "error": ...}.
+// Its Record method is meant for Hooks.OnError, together with WalkOptions.SkipErrors.
+type ErrorLog struct {
+	mu       sync.Mutex
+	encoder  *json.Encoder
+	count    int
+	writeErr error
+}
+
+// errorLogEntry is one line of an ErrorLog
+type errorLogEntry struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// NewErrorLog returns an ErrorLog writing to w
+func NewErrorLog(w io.Writer) *ErrorLog {
+	return &ErrorLog{encoder: json.NewEncoder(w)}
+}
+
+// Record writes one failed path with its error
+func (l *ErrorLog) Record(path string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count++
+	if writeErr := l.encoder.Encode(errorLogEntry{Path: path, Error: err.Error()}); writeErr != nil && l.writeErr == nil {
+		l.writeErr = writeErr
+	}
+}
+
+// Count returns the number of paths recorded
+func (l *ErrorLog) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// Err returns the first error writing the log, if any
+func (l *ErrorLog) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writeErr
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkSkipErrors(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"keep.txt", "keep"},
+		{"vanishing.txt", "gone before it is hashed"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	vanishing := filepath.Join(testDir, "vanishing.txt")
+	var logged bytes.Buffer
+	errorLog := NewErrorLog(&logged)
+	walkOpts := WalkOptions{
+		Hooks: &Hooks{OnError: errorLog.Record},
+		// deleting the file once it is found makes hashing it fail
+		Filter: func(path string, info os.FileInfo) bool {
+			if path == vanishing {
+				os.Remove(path)
+			}
+			return true
+		},
+	}
+	if _, err := WalkDirectoryWithOptions(testDir, walkOpts); err == nil {
+		t.Fatalf("Expected the walk to fail without SkipErrors")
+	}
+	if err := os.WriteFile(vanishing, nil, 0644); err != nil {
+		t.Fatalf("Failed to recreate %s: %v", vanishing, err)
+	}
+	logged.Reset()
+	errorLog = NewErrorLog(&logged)
+	walkOpts.Hooks = &Hooks{OnError: errorLog.Record}
+	walkOpts.SkipErrors = true
+
+	dirInfo, err := WalkDirectoryWithOptions(testDir, walkOpts)
+	if err != nil {
+		t.Fatalf("Unexpected error with SkipErrors: %v", err)
+	}
+	if len(dirInfo.Files) != 1 || dirInfo.Files[0].Path != filepath.Join(testDir, "keep.txt") {
+		t.Errorf("Unexpected files: %v", dirInfo.Files)
+	}
+	if errorLog.Count() != 1 || errorLog.Err() != nil {
+		t.Errorf("Unexpected error log count: got %d (%v), want %d", errorLog.Count(), errorLog.Err(), 1)
+	}
+	var entry errorLogEntry
+	if err := json.Unmarshal(logged.Bytes(), &entry); err != nil {
+		t.Fatalf("Unexpected error log line %q: %v", logged.String(), err)
+	}
+	if entry.Path != vanishing || entry.Error == "" {
+		t.Errorf("Unexpected error log entry: %+v", entry)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExplainStep is one check of the matching logic and whether the explained file passed it
+type ExplainStep struct {
+	Check  string
+	Passed bool
+	Detail string
+}
+
+// Explanation describes why a target file was or was not classified as a duplicate
+type Explanation struct {
+	Path      string
+	Duplicate bool
+	// RefPath is the reference file the target file duplicates, if Duplicate
+	RefPath string
+	Steps   []ExplainStep
+}
+
+// explainListLimit caps how many candidate paths a step detail lists
+const explainListLimit = 5
+
+// ExplainMatch walks the target file at path through the checks CompareFilesWithOptions applies,
+// stopping at the first one it fails. The verdict always agrees with CompareFilesWithOptions.
+func ExplainMatch(refDir *DirectoryInfo, targetDir *DirectoryInfo, path string, opts CompareOptions) Explanation {
+	explanation := Explanation{Path: path}
+	// step records a check with the detail matching its outcome and reports whether it passed
+	step := func(check string, passed bool, passDetail, failDetail string) bool {
+		detail := failDetail
+		if passed {
+			detail = passDetail
+		}
+		explanation.Steps = append(explanation.Steps, ExplainStep{Check: check, Passed: passed, Detail: detail})
+		return passed
+	}
+
+	absPath := absOrClean(path)
+	var file FileInfo
+	found := false
+	for _, candidate := range targetDir.Files {
+		if absOrClean(candidate.Path) == absPath {
+			file, found = candidate, true
+			break
+		}
+	}
+	if !step("listed in target", found, file.Path,
+		fmt.Sprintf("not among the %d target files under %s; it may lie outside the target, not be a regular file, be a symlink (see -followSymlinksTarget) or have been skipped by a filter", len(targetDir.Files), targetDir.BaseDir)) {
+		return explanation
+	}
+
+	kind := "hash"
+	switch {
+	case opts.MetaOnly:
+		kind = "size and modification time"
+	case opts.UseBodyHash:
+		kind = "body hash"
+	}
+	content := contentKey(file, opts)
+	if !step(kind+" recorded", content != "", describeContent(file, opts),
+		fmt.Sprintf("the target entry has no %s, so it can never match", kind)) {
+		return explanation
+	}
+
+	var sameContent []FileInfo
+	for _, refFile := range refDir.Files {
+		if contentKey(refFile, opts) == content {
+			sameContent = append(sameContent, refFile)
+		}
+	}
+	if !step(kind+" in reference", len(sameContent) > 0, fmt.Sprintf("%d reference files share it", len(sameContent)),
+		fmt.Sprintf("no reference file has %s %s", kind, describeContent(file, opts))) {
+		return explanation
+	}
+
+	exact := opts.exactPathMatch(file)
+	check := "file name matches"
+	if exact {
+		check = "relative path matches"
+	}
+	key := opts.NormalizeTargetPath.apply(matchKey(targetDir, file, exact))
+	var sameKey []FileInfo
+	for _, refFile := range sameContent {
+		if opts.NormalizeRefPath.apply(matchKey(refDir, refFile, exact)) == key {
+			sameKey = append(sameKey, refFile)
+		}
+	}
+	elsewhere := fmt.Sprintf("reference copies with the same content are elsewhere: %s", listPaths(sameContent))
+	switch {
+	case opts.EmptyFilesSamePath && isEmpty(file):
+		elsewhere += " (-dedupIgnoreZeroByteGroupsUnlessSamePath only matches empty files at the same relative path)"
+	case exact:
+		elsewhere += " (-exactPathMatch=false matches by file name only)"
+	}
+	if !step(check, len(sameKey) > 0, key, elsewhere) {
+		return explanation
+	}
+
+	if opts.CompareXattrs {
+		var sameXattrs []FileInfo
+		for _, refFile := range sameKey {
+			if refFile.XattrDigest == file.XattrDigest {
+				sameXattrs = append(sameXattrs, refFile)
+			}
+		}
+		if !step("extended attributes match", len(sameXattrs) > 0, fmt.Sprintf("digest %q", file.XattrDigest),
+			fmt.Sprintf("reference copies have different extended attributes: %s", listPaths(sameKey))) {
+			return explanation
+		}
+		sameKey = sameXattrs
+	}
+
+	if opts.RequireModTimeMatch {
+		var sameModTime []FileInfo
+		for _, refFile := range sameKey {
+			if modTimesMatch(refFile.ModTime, file.ModTime, opts.ModTimeTolerance) {
+				sameModTime = append(sameModTime, refFile)
+			}
+		}
+		if !step("modification time matches", len(sameModTime) > 0, formatModTime(file.ModTime),
+			fmt.Sprintf("reference copies were modified at other times than %s: %s", formatModTime(file.ModTime), listPaths(sameKey))) {
+			return explanation
+		}
+		sameKey = sameModTime
+	}
+
+	if file.Content != "" {
+		var sameContent []FileInfo
+		for _, refFile := range sameKey {
+			if inlineContentMatches(refFile, file) {
+				sameContent = append(sameContent, refFile)
+			}
+		}
+		if !step("inline content matches", len(sameContent) > 0, fmt.Sprintf("%d bytes", file.Size),
+			fmt.Sprintf("the hash matches but the inline content differs from %s", listPaths(sameKey))) {
+			return explanation
+		}
+		sameKey = sameContent
+	}
+
+	refPaths := make([]string, len(sameKey))
+	for i, refFile := range sameKey {
+		refPaths[i] = refFile.Path
+	}
+	refPath := matchingRefPath(refPaths, file.Path, opts.ExcludeSameFile)
+	if opts.ExcludeSameFile && !step("not the same file", refPath != "", refPath,
+		"the only matching reference entries are the target file itself (-excludeSameDir)") {
+		return explanation
+	}
+
+	explanation.Duplicate = true
+	explanation.RefPath = refPath
+	return explanation
+}
+
+// describeContent formats the content key of file for humans
+func describeContent(file FileInfo, opts CompareOptions) string {
+	switch {
+	case opts.MetaOnly:
+		return fmt.Sprintf("%d bytes, modified %s", file.Size, formatModTime(file.ModTime))
+	case opts.UseBodyHash:
+		return file.BodyHash
+	}
+	return file.Hash
+}
+
+// listPaths joins the paths of files, eliding all but the first explainListLimit
+func listPaths(files []FileInfo) string {
+	var paths []string
+	for i, file := range files {
+		if i == explainListLimit {
+			paths = append(paths, fmt.Sprintf("and %d more", len(files)-explainListLimit))
+			break
+		}
+		paths = append(paths, file.Path)
+	}
+	return strings.Join(paths, ", ")
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestExplainMatch(t *testing.T) {
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/a.txt", Hash: "aaa"},
+		{Path: "/ref/moved/b.txt", Hash: "bbb"},
+		{Path: "/ref/moved/empty.txt", Hash: emptyDigests[DefaultHashAlgo]},
+	}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{
+		{Path: "/target/a.txt", Hash: "aaa"},
+		{Path: "/target/b.txt", Hash: "bbb"},
+		{Path: "/target/c.txt", Hash: "ccc"},
+		{Path: "/target/unhashed.txt"},
+		{Path: "/target/empty.txt", Hash: emptyDigests[DefaultHashAlgo]},
+	}}
+
+	tests := []struct {
+		path       string
+		duplicate  bool
+		failedStep string
+	}{
+		{"/target/a.txt", true, ""},
+		{"/target/b.txt", false, "relative path matches"},
+		{"/target/c.txt", false, "hash in reference"},
+		{"/target/unhashed.txt", false, "hash recorded"},
+		{"/target/missing.txt", false, "listed in target"},
+	}
+	opts := CompareOptions{ExactPathMatch: true}
+	duplicates := make(map[string]bool)
+	for _, file := range CompareFilesWithOptions(refDir, targetDir, opts) {
+		duplicates[file.Path] = true
+	}
+	for _, test := range tests {
+		explanation := ExplainMatch(refDir, targetDir, test.path, opts)
+		if explanation.Duplicate != test.duplicate {
+			t.Errorf("Unexpected verdict for %s: got %v, want %v", test.path, explanation.Duplicate, test.duplicate)
+		}
+		if explanation.Duplicate != duplicates[test.path] {
+			t.Errorf("Explanation for %s disagrees with CompareFilesWithOptions", test.path)
+		}
+		last := explanation.Steps[len(explanation.Steps)-1]
+		if test.failedStep == "" {
+			if !last.Passed || explanation.RefPath != "/ref/a.txt" {
+				t.Errorf("Unexpected explanation for %s: %+v", test.path, explanation)
+			}
+			continue
+		}
+		if last.Passed || last.Check != test.failedStep {
+			t.Errorf("Unexpected failed step for %s: got %q (passed %v), want %q", test.path, last.Check, last.Passed, test.failedStep)
+		}
+	}
+
+	explanation := ExplainMatch(refDir, targetDir, "/target/b.txt", CompareOptions{})
+	if !explanation.Duplicate || explanation.RefPath != "/ref/moved/b.txt" {
+		t.Errorf("Unexpected explanation matching by file name: %+v", explanation)
+	}
+
+	// neither a.txt records a modification time
+	explanation = ExplainMatch(refDir, targetDir, "/target/a.txt", CompareOptions{RequireModTimeMatch: true})
+	if last := explanation.Steps[len(explanation.Steps)-1]; explanation.Duplicate || last.Check != "modification time matches" {
+		t.Errorf("Unexpected explanation requiring matching modification times: %+v", explanation)
+	}
+
+	// b.txt records no size, but its hash shows it is not empty, so it still matches by file name
+	explanation = ExplainMatch(refDir, targetDir, "/target/b.txt", CompareOptions{EmptyFilesSamePath: true})
+	if !explanation.Duplicate || explanation.RefPath != "/ref/moved/b.txt" {
+		t.Errorf("Unexpected explanation for a non-empty file without a size: %+v", explanation)
+	}
+	explanation = ExplainMatch(refDir, targetDir, "/target/empty.txt", CompareOptions{EmptyFilesSamePath: true})
+	if last := explanation.Steps[len(explanation.Steps)-1]; explanation.Duplicate || last.Check != "relative path matches" {
+		t.Errorf("Unexpected explanation matching empty files by path: %+v", explanation)
+	}
+}
@@ -1,134 +1,457 @@
 package main
 
 import (
-	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type FileInfo struct {
-	Path string `yaml:"path"`
-	Hash string `yaml:"hash"`
+	Path    string    `yaml:"path"`
+	Hash    string    `yaml:"hash"`
+	Size    int64     `yaml:"size,omitempty"`
+	ModTime time.Time `yaml:"modTime,omitempty"`
+	// XattrDigest summarizes the file's extended attributes; only recorded when WalkOptions.CaptureXattrs is set
+	XattrDigest string `yaml:"xattrDigest,omitempty"`
+	// BodyHash is the hash of the content without the configured header and footer (see BodyRange).
+	// It is kept apart from Hash because it does not describe the whole file.
+	BodyHash string `yaml:"bodyHash,omitempty"`
+	// EdgeHash covers only the size and the first and last blocks of the file (see CalculateEdgeHash), a quick
+	// heuristic signature; only recorded when WalkOptions.EdgeBlockSize is set
+	EdgeHash string `yaml:"edgeHash,omitempty"`
+	// HashAlgo names the algorithm of Hash, BodyHash and EdgeHash; empty means DefaultHashAlgo.
+	// Files hashed with different algorithms never match.
+	HashAlgo string `yaml:"hashAlgo,omitempty"`
+	// Content is the whole file, base64 encoded, for files below WalkOptions.InlineContentBelow.
+	// When both files of a match carry it, it is compared in addition to the hash.
+	Content string `yaml:"content,omitempty"`
+	// Device and Inode identify the file behind hardlinks; only recorded when WalkOptions.CaptureInodes is set
+	Device uint64 `yaml:"device,omitempty"`
+	Inode  uint64 `yaml:"inode,omitempty"`
+	// Links is the number of hardlinks to the file, recorded along with Device and Inode
+	Links uint64 `yaml:"links,omitempty"`
+	// Owner and Group are the numeric user and group ID of the file; only recorded when WalkOptions.CaptureOwner is set
+	Owner string `yaml:"owner,omitempty"`
+	Group string `yaml:"group,omitempty"`
 }
 
 type DirectoryInfo struct {
-	BaseDir string     `yaml:"baseDir"`
-	Files   []FileInfo `yaml:"files"`
+	BaseDir string `yaml:"baseDir"`
+	// HashSpec records how the hashes were computed; nil for manifests written before it was recorded
+	HashSpec *HashSpec  `yaml:"hashSpec,omitempty"`
+	Files    []FileInfo `yaml:"files"`
 }
 
+// CalculateHash sets Hash using the algorithm named by HashAlgo
 func (f *FileInfo) CalculateHash() error {
-	file, err := os.Open(f.Path)
+	file, err := os.Open(longPath(f.Path))
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
+	hash, err := HashReaderWith(file, f.HashAlgo)
+	if err != nil {
 		return err
 	}
-	f.Hash = fmt.Sprintf("%x", hasher.Sum(nil))
+	f.Hash = hash
 	return nil
 }
 
+// WalkOptions controls how WalkDirectoryWithOptions scans a directory
+type WalkOptions struct {
+	// HashWorkers is the number of files hashed concurrently
+	HashWorkers int
+	// MaxHashPerDevice, if positive, also limits how many of them are on the same device (by the device ID from
+	// stat), so that workers spread over the disks of a target instead of piling onto a slow one. Without device
+	// IDs (on non-Unix platforms) all files count as one device.
+	MaxHashPerDevice int
+	// WalkWorkers is the number of directories read concurrently, worth raising on high-latency filesystems
+	WalkWorkers        int
+	OutputYamlToStdout bool
+	// YamlOutput, if set, receives the manifest as it is built instead of stdout
+	YamlOutput io.Writer
+	// FollowSymlinks hashes the content behind symlinks to regular files instead of skipping them.
+	// The recorded path is the link itself, not its target, so relative paths keep matching the tree layout.
+	// Symlinks to directories and dangling symlinks are always skipped.
+	FollowSymlinks bool
+	// SkipErrors skips files and directories that cannot be read or hashed, reporting them only to Hooks.OnError,
+	// instead of failing the walk
+	SkipErrors bool
+	// SkipBusy leaves out files another process holds open or locked (see FileBusy) instead of reading them,
+	// listing them in Summary.Busy so they can be retried later
+	SkipBusy bool
+	// WarnSpecialFiles prints a warning to stderr for every FIFO, socket or device skipped; they are skipped regardless
+	WarnSpecialFiles bool
+	// CaptureInodes records the device, inode number and link count of each file (Unix only), see
+	// PreserveHardlinkGroups and KeeperPolicy.PreferMostLinks
+	CaptureInodes bool
+	// InodesInMemory keeps what CaptureInodes records out of the manifest written to YamlOutput or stdout, for
+	// callers that need it only for this run, such as counting hardlinks once
+	InodesInMemory bool
+	// CaptureOwner records the owning user and group of each file (Unix only)
+	CaptureOwner bool
+	// Owner, if not zero, skips files not owned by its user and group, as if they were not there
+	Owner OwnerFilter
+	// CaptureXattrs records a digest of each file's extended attributes (Linux and macOS only)
+	CaptureXattrs bool
+	// Filter, if set, is asked about every file found; files it rejects are neither hashed nor recorded.
+	// It is called concurrently from the walk workers.
+	Filter func(path string, info os.FileInfo) bool
+	// Body, if not zero, also records a BodyHash leaving out the given header and footer
+	Body BodyRange
+	// MetaOnly records only stat data and never reads file content, leaving Hash empty.
+	// Use it with CompareOptions.MetaOnly for a quick shortlist of probable duplicates.
+	MetaOnly bool
+	// EdgeBlockSize, if positive, records only an EdgeHash of the first and last EdgeBlockSize bytes of each file,
+	// leaving Hash empty. Use it with CompareOptions.UseEdgeHash for a fast shortlist of probable duplicates.
+	EdgeBlockSize int64
+	// Hash chooses the hash algorithm per file; the zero value hashes everything with DefaultHashAlgo
+	Hash HashPolicy
+	// InlineContentBelow records the full content of files smaller than this many bytes (see FileInfo.Content).
+	// It must not exceed MaxInlineContent.
+	InlineContentBelow int64
+	// DiscardFiles leaves the Files of the returned DirectoryInfo empty, for callers that consume files as they
+	// are hashed through Hooks.OnFileHashed and cannot afford to hold all of them in memory
+	DiscardFiles bool
+	// Stats, if set, is updated as the walk progresses, for live monitoring
+	Stats *ScanStats
+	Hooks *Hooks
+}
+
+// DefaultWalkWorkers is the number of directory-reading goroutines used when WalkOptions.WalkWorkers is unset
+const DefaultWalkWorkers = 4
+
 func WalkDirectory(root string, parallelism int, outputYamlToStdout bool) (*DirectoryInfo, error) {
+	return WalkDirectoryWithOptions(root, WalkOptions{HashWorkers: parallelism, OutputYamlToStdout: outputYamlToStdout})
+}
+
+// WalkDirectoryWithOptions hashes every regular file under root, as configured by opts
+func WalkDirectoryWithOptions(root string, opts WalkOptions) (*DirectoryInfo, error) {
+	root = filepath.Clean(root)
+	hashWorkers := opts.HashWorkers
+	if hashWorkers < 1 {
+		hashWorkers = 1
+	}
+	walkWorkers := opts.WalkWorkers
+	if walkWorkers < 1 {
+		walkWorkers = DefaultWalkWorkers
+	}
+	hooks := opts.Hooks
+	stats := opts.Stats
+	if err := validateInlineThreshold(opts.InlineContentBelow); err != nil {
+		return nil, err
+	}
+
 	var files []FileInfo
+	var summary Summary
 	fileChan := make(chan FileInfo)
-	errChan := make(chan error, 1)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 
-	if outputYamlToStdout {
-		fmt.Printf("baseDir: %s\nfiles:\n", root)
+	var firstErr error
+	setErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	yamlOut := opts.YamlOutput
+	if yamlOut == nil && opts.OutputYamlToStdout {
+		yamlOut = os.Stdout
+	}
+	if yamlOut != nil {
+		header, err := yamlManifestHeader(root, opts.hashSpec())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(yamlOut, header); err != nil {
+			return nil, err
+		}
+	}
+
+	stats.walkStarted(hashWorkers)
+	defer stats.walkFinished(hashWorkers)
+
+	// hashFile hashes one file and records it
+	hashFile := func(fileInfo FileInfo) {
+		// keep draining after a failure so the walkers never block
+		if failed() {
+			return
+		}
+		stats.fileStarted()
+		if opts.SkipBusy {
+			// an error here resurfaces when the file is read
+			if busy, _ := FileBusy(fileInfo.Path); busy {
+				stats.fileDone(0, nil)
+				mu.Lock()
+				summary.Busy = append(summary.Busy, fileInfo.Path)
+				mu.Unlock()
+				return
+			}
+		}
+		hash := fileInfo.CalculateHash
+		switch {
+		case opts.MetaOnly:
+			hash = func() error { return nil }
+		case opts.EdgeBlockSize > 0:
+			hash = func() error { return fileInfo.CalculateEdgeHash(opts.EdgeBlockSize) }
+		case fileInfo.Size < opts.InlineContentBelow:
+			hash = func() error { return fileInfo.InlineContent(opts.Body) }
+		case !opts.Body.IsZero():
+			hash = func() error { return fileInfo.CalculateHashes(opts.Body) }
+		}
+		err := hash()
+		if errors.Is(err, ErrFooterTooLong) {
+			// the full hash is still good; only matching by body is lost for this file
+			fmt.Fprintf(os.Stderr, "Warning: %s gets no body hash and matches no other file by body: %v\n", fileInfo.Path, err)
+			err = nil
+		}
+		stats.fileDone(fileInfo.Size, err)
+		if err != nil {
+			hooks.error(fileInfo.Path, err)
+			if !opts.SkipErrors {
+				setErr(err)
+			}
+			return
+		}
+		if opts.CaptureXattrs {
+			if err := fileInfo.CalculateXattrDigest(); err != nil {
+				hooks.error(fileInfo.Path, err)
+				if !opts.SkipErrors {
+					setErr(err)
+				}
+				return
+			}
+		}
+		mu.Lock()
+		if !opts.DiscardFiles {
+			files = append(files, fileInfo)
+		}
+		summary.Files++
+		summary.Bytes += fileInfo.Size
+		mu.Unlock()
+		hooks.fileHashed(fileInfo)
+		if yamlOut != nil {
+			written := fileInfo
+			if opts.InodesInMemory {
+				written.Device, written.Inode, written.Links = 0, 0, 0
+			}
+			entry, err := yamlManifestEntry(written)
+			if err != nil {
+				// one unprintable entry must not abort the stream; the file stays in the returned DirectoryInfo
+				fmt.Fprintf(os.Stderr, "Error writing %s to YAML, skipping it in the output: %v\n", fileInfo.Path, err)
+				hooks.error(fileInfo.Path, err)
+				return
+			}
+			// Print the formatted entry atomically
+			mu.Lock()
+			_, err = io.WriteString(yamlOut, entry)
+			mu.Unlock()
+			if err != nil {
+				hooks.error(fileInfo.Path, err)
+				setErr(err)
+			}
+		}
+	}
+
+	var queue *deviceQueue
+	if opts.MaxHashPerDevice > 0 {
+		queue = newDeviceQueue(opts.MaxHashPerDevice)
 	}
 
 	// Start worker goroutines
-	for i := 0; i < parallelism; i++ {
+	for i := 0; i < hashWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for fileInfo := range fileChan {
-				if err := fileInfo.CalculateHash(); err != nil {
-					select {
-					case errChan <- err:
-					default:
-					}
-					return
-				}
-				mu.Lock()
-				files = append(files, fileInfo)
-				mu.Unlock()
-				if outputYamlToStdout {
-					data, err := yaml.Marshal(&fileInfo)
-					if err != nil {
-						errChan <- err
+			if queue != nil {
+				for {
+					fileInfo, device, ok := queue.take()
+					if !ok {
 						return
 					}
-					var output strings.Builder
-					dataLines := strings.Split(string(data), "\n")
-					for i, dataLine := range dataLines {
-						if dataLine == "" {
-							continue // Skip empty lines
-						}
-						if i == 0 {
-							output.WriteString(fmt.Sprintf("- %s\n", dataLine))
-						} else {
-							output.WriteString(fmt.Sprintf("  %s\n", dataLine))
-						}
-					}
-					// Print the formatted output string atomically
-					mu.Lock()
-					fmt.Print(output.String())
-					mu.Unlock()
+					hashFile(fileInfo)
+					queue.done(device)
 				}
 			}
+			for fileInfo := range fileChan {
+				hashFile(fileInfo)
+			}
 		}()
 	}
 
 	// Walk the directory and send files to be processed
-	go func() {
-		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			// skip symlinks
-			if info.Mode()&os.ModeSymlink != 0 {
+	walkErr := walkTree(root, walkWorkers, func(path string, info os.FileInfo) error {
+		if failed() {
+			return firstErr
+		}
+		// skip symlinks unless following them to a regular file
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !opts.FollowSymlinks {
 				return nil
 			}
-			if !info.IsDir() {
-				fileChan <- FileInfo{Path: path}
+			targetInfo, err := os.Stat(path)
+			if err != nil || !targetInfo.Mode().IsRegular() {
+				return nil
 			}
+			info = targetInfo
+		}
+		if info.IsDir() {
 			return nil
-		})
-		close(fileChan)
-		if err != nil {
-			select {
-			case errChan <- err:
-			default:
+		}
+		// FIFOs, sockets and devices can block a reader forever or never end, so never hash them
+		if !info.Mode().IsRegular() {
+			if opts.WarnSpecialFiles {
+				fmt.Fprintf(os.Stderr, "Skipping special file %s (%s)\n", path, specialFileKind(info.Mode()))
 			}
+			return nil
 		}
-	}()
+		if !opts.Owner.Matches(info) {
+			return nil
+		}
+		if opts.Filter != nil && !opts.Filter(path, info) {
+			return nil
+		}
+		fileInfo := FileInfo{Path: path, Size: info.Size(), ModTime: info.ModTime(), HashAlgo: opts.Hash.AlgoFor(path)}
+		if opts.CaptureInodes {
+			fileInfo.Device, fileInfo.Inode, fileInfo.Links, _ = fileIdentity(info)
+		}
+		if opts.CaptureOwner {
+			fileInfo.Owner, fileInfo.Group = ownerStrings(info)
+		}
+		stats.fileFound()
+		if queue != nil {
+			device, _, _, _ := fileIdentity(info)
+			queue.add(fileInfo, device)
+			return nil
+		}
+		fileChan <- fileInfo
+		return nil
+	}, func(path string, err error) error {
+		stats.walkError()
+		hooks.error(path, err)
+		if opts.SkipErrors {
+			return nil
+		}
+		return err
+	})
+	close(fileChan)
+	if queue != nil {
+		queue.close()
+	}
 
 	// Wait for all workers to finish
 	wg.Wait()
-	close(errChan)
 
 	// Check for errors
-	for err := range errChan {
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	summary.HashWorkers, summary.WalkWorkers = hashWorkers, walkWorkers
+	sort.Strings(summary.Busy)
+	hooks.complete(summary)
+
+	return &DirectoryInfo{BaseDir: root, HashSpec: opts.hashSpec(), Files: files}, nil
+}
+
+// yamlManifestHeader returns the start of a YAML manifest of root hashed as spec, to be followed by
+// yamlManifestEntry lines
+func yamlManifestHeader(root string, spec *HashSpec) (string, error) {
+	data, err := yaml.Marshal(&DirectoryInfo{BaseDir: root, HashSpec: spec})
+	if err != nil {
+		return "", err
+	}
+	// the empty file list is marshaled as "files: []"; entries are appended instead
+	return strings.TrimSuffix(string(data), "files: []\n") + "files:\n", nil
+}
+
+// marshalManifestEntry marshals a single manifest entry; tests replace it to make an entry fail
+var marshalManifestEntry = yaml.Marshal
+
+// yamlManifestEntry formats fileInfo as an item of the files list of a YAML manifest
+func yamlManifestEntry(fileInfo FileInfo) (string, error) {
+	data, err := marshalManifestEntry(&fileInfo)
+	if err != nil {
+		return "", err
+	}
+	var output strings.Builder
+	dataLines := strings.Split(string(data), "\n")
+	for i, dataLine := range dataLines {
+		if dataLine == "" {
+			continue // Skip empty lines
+		}
+		if i == 0 {
+			output.WriteString(fmt.Sprintf("- %s\n", dataLine))
+		} else {
+			output.WriteString(fmt.Sprintf("  %s\n", dataLine))
+		}
+	}
+	return output.String(), nil
+}
+
+// WriteManifest writes dirInfo to w as a YAML manifest one entry at a time, so the whole document
+// is never held in memory
+func WriteManifest(w io.Writer, dirInfo *DirectoryInfo) error {
+	header, err := yamlManifestHeader(dirInfo.BaseDir, dirInfo.HashSpec)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	for _, file := range dirInfo.Files {
+		entry, err := yamlManifestEntry(file)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if _, err := io.WriteString(w, entry); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return &DirectoryInfo{BaseDir: root, Files: files}, nil
+// specialFileKind names the type of a non-regular, non-directory file
+func specialFileKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	}
+	return "irregular file"
 }
+
 func GetFileMapFromDirectoryInfo(dirInfo *DirectoryInfo, exactPathMatch bool) map[string]map[string]bool {
+	return GetFileMapWithNormalizer(dirInfo, exactPathMatch, nil)
+}
+
+// GetFileMapWithNormalizer is GetFileMapFromDirectoryInfo with the relative paths or names passed through normalize
+func GetFileMapWithNormalizer(dirInfo *DirectoryInfo, exactPathMatch bool, normalize PathNormalizer) map[string]map[string]bool {
 	refFileMap := make(map[string]map[string]bool) // map[hash]map[relpath]bool
 	for _, file := range dirInfo.Files {
 		hash := file.Hash
@@ -139,46 +462,318 @@ func GetFileMapFromDirectoryInfo(dirInfo *DirectoryInfo, exactPathMatch bool) ma
 		}
 
 		if exactPathMatch {
-			refFileMap[hash][relPath] = true
+			refFileMap[hash][normalize.apply(relPath)] = true
 		} else {
 			fileName := filepath.Base(file.Path)
-			refFileMap[hash][fileName] = true
+			refFileMap[hash][normalize.apply(fileName)] = true
 		}
 	}
 	return refFileMap
 }
 
+// CompareOptions controls how CompareFilesWithOptions decides that a target file is a duplicate
+type CompareOptions struct {
+	// ExactPathMatch requires files to have the exact same relative path instead of the same file name
+	ExactPathMatch bool
+	// ExcludeSameFile never counts a target file as a duplicate of a reference entry at the same absolute path
+	ExcludeSameFile bool
+	// CompareXattrs additionally requires matching extended attribute digests, so content-identical files
+	// with different xattrs are not treated as interchangeable. By default xattrs are ignored.
+	CompareXattrs bool
+	// UseBodyHash matches files by BodyHash instead of Hash; files without a BodyHash never match
+	UseBodyHash bool
+	// MetaOnly matches files by size and modification time instead of content, so its results are
+	// unverified: files with equal metadata can still differ. Files without a ModTime never match.
+	MetaOnly bool
+	// UseEdgeHash matches files by EdgeHash instead of Hash, so its results are heuristic: files with the same
+	// size, beginning and end can still differ in between. ConfirmMatches settles them.
+	UseEdgeHash bool
+	// NameAndSize matches files by file name and size alone, whatever ExactPathMatch says, so its results are
+	// unverified: files sharing both can still differ. ConfirmMatches settles them.
+	NameAndSize bool
+	// RequireModTimeMatch additionally requires modification times within ModTimeTolerance of each other, so a
+	// file re-created with the same content is not a duplicate. Files without a ModTime never match.
+	RequireModTimeMatch bool
+	// ModTimeTolerance allows for file systems with coarse timestamps, e.g. 2s for FAT
+	ModTimeTolerance time.Duration
+	// EmptyFilesSamePath matches empty files only by their exact relative path, whatever ExactPathMatch and
+	// NameAndSize say for other files. All empty files share a hash, so otherwise any empty file duplicates any other.
+	EmptyFilesSamePath bool
+	// NormalizeRefPath and NormalizeTargetPath, if set, rewrite the relative path or file name of reference
+	// and target files before they are compared
+	NormalizeRefPath    PathNormalizer
+	NormalizeTargetPath PathNormalizer
+	Hooks               *Hooks
+}
+
 // CompareFiles compares files from two directories based on hash and relative path
 // If exactPathMatch is true, it requires files to have the exact same relative path
 func CompareFiles(refDir *DirectoryInfo, targetDir *DirectoryInfo, exactPathMatch bool) []FileInfo {
-	refFileMap := GetFileMapFromDirectoryInfo(refDir, exactPathMatch)
+	return CompareFilesWithOptions(refDir, targetDir, CompareOptions{ExactPathMatch: exactPathMatch})
+}
 
+// Duplicate is a target file together with the reference path it duplicates
+type Duplicate struct {
+	File    FileInfo
+	RefPath string
+}
+
+// CompareFilesWithOptions compares files from two directories based on hash and relative path, as configured by opts
+func CompareFilesWithOptions(refDir *DirectoryInfo, targetDir *DirectoryInfo, opts CompareOptions) []FileInfo {
 	var duplicates []FileInfo
+	compareFiles(refDir, targetDir, opts, func(duplicate Duplicate) {
+		duplicates = append(duplicates, duplicate.File)
+	})
+	return duplicates
+}
+
+// CompareFilesStream is like CompareFilesWithOptions, but emits each duplicate on the returned channel
+// as soon as it is found. The channel is closed once all target files have been compared.
+func CompareFilesStream(refDir *DirectoryInfo, targetDir *DirectoryInfo, opts CompareOptions) <-chan Duplicate {
+	duplicateChan := make(chan Duplicate)
+	go func() {
+		defer close(duplicateChan)
+		compareFiles(refDir, targetDir, opts, func(duplicate Duplicate) {
+			duplicateChan <- duplicate
+		})
+	}()
+	return duplicateChan
+}
+
+// compareFiles calls emit for every target file that duplicates a reference file
+func compareFiles(refDir *DirectoryInfo, targetDir *DirectoryInfo, opts CompareOptions, emit func(Duplicate)) {
+	refPathMap := getPathMapFromDirectoryInfo(refDir, opts)
+	refContent := make(map[string]string) // map[path]inline content
+	refModTimes := make(map[string]time.Time)
+	for _, file := range refDir.Files {
+		if file.Content != "" {
+			refContent[file.Path] = file.Content
+		}
+		if opts.RequireModTimeMatch {
+			refModTimes[file.Path] = file.ModTime
+		}
+	}
+
+	summary := Summary{Files: len(targetDir.Files)}
+	var freed freedSpace
 	for _, file := range targetDir.Files {
-		hash := file.Hash
-		relPath, _ := filepath.Rel(targetDir.BaseDir, file.Path)
+		summary.Bytes += file.Size
 
-		if paths, exists := refFileMap[hash]; exists {
-			if exactPathMatch {
-				if _, pathExists := paths[relPath]; pathExists {
-					duplicates = append(duplicates, file)
-				}
-			} else {
-				fileName := filepath.Base(file.Path)
-				if _, nameExists := paths[fileName]; nameExists {
-					duplicates = append(duplicates, file)
-				}
-			}
+		key := compareKey(targetDir, file, opts, opts.NormalizeTargetPath)
+		if key == "" {
+			continue
+		}
+		refPaths := refPathMap[key]
+		if file.Content != "" && len(refContent) > 0 {
+			refPaths = withInlineContent(refPaths, refContent, file)
+		}
+		if opts.RequireModTimeMatch {
+			refPaths = withModTime(refPaths, refModTimes, file, opts.ModTimeTolerance)
+		}
+		refPath := matchingRefPath(refPaths, file.Path, opts.ExcludeSameFile)
+		if refPath == "" {
+			continue
 		}
+		summary.Duplicates++
+		freed.add(file)
+		opts.Hooks.duplicateFound(file, refPath)
+		emit(Duplicate{File: file, RefPath: refPath})
 	}
+	summary.DuplicateBytes = freed.bytes
+	opts.Hooks.complete(summary)
+}
 
-	return duplicates
+// matchKey returns the relative path of file, or just its name if exactPathMatch is false
+func matchKey(dirInfo *DirectoryInfo, file FileInfo, exactPathMatch bool) string {
+	if !exactPathMatch {
+		return filepath.Base(file.Path)
+	}
+	relPath, _ := filepath.Rel(dirInfo.BaseDir, file.Path)
+	return relPath
+}
+
+// contentKey returns what stands in for the content of file under opts, or "" if it is not recorded
+func contentKey(file FileInfo, opts CompareOptions) string {
+	if opts.NameAndSize {
+		return fmt.Sprintf("%d", file.Size)
+	}
+	if opts.MetaOnly {
+		if file.ModTime.IsZero() {
+			return ""
+		}
+		return fmt.Sprintf("%d\x00%d", file.Size, file.ModTime.UnixNano())
+	}
+	// prefix the algorithm so digests of different algorithms can never be mistaken for each other
+	hash := file.Hash
+	switch {
+	case opts.UseEdgeHash:
+		hash = file.EdgeHash
+	case opts.UseBodyHash:
+		hash = file.BodyHash
+	}
+	if hash == "" || file.HashAlgo == "" || file.HashAlgo == DefaultHashAlgo {
+		return hash
+	}
+	return file.HashAlgo + ":" + hash
+}
+
+// exactPathMatch tells whether file is matched by its relative path rather than its file name under opts
+func (opts CompareOptions) exactPathMatch(file FileInfo) bool {
+	if opts.EmptyFilesSamePath && isEmpty(file) {
+		return true
+	}
+	return opts.ExactPathMatch && !opts.NameAndSize
+}
+
+// isEmpty tells whether file has no content. A full hash decides, since manifests written before sizes were
+// recorded have no Size for any file; only walks that skip the full hash (-metaOnly, edge hashes) go by Size,
+// and they always record it.
+func isEmpty(file FileInfo) bool {
+	if file.Hash == "" {
+		return file.Size == 0
+	}
+	algo := file.HashAlgo
+	if algo == "" {
+		algo = DefaultHashAlgo
+	}
+	return file.Hash == emptyDigests[algo]
+}
+
+// compareKey combines everything two files must share to be duplicates under opts, with the path normalized
+// for the side file is on. It returns "" for files that cannot be matched under opts.
+func compareKey(dirInfo *DirectoryInfo, file FileInfo, opts CompareOptions, normalize PathNormalizer) string {
+	hash := contentKey(file, opts)
+	if hash == "" {
+		return ""
+	}
+	key := hash + "\x00" + normalize.apply(matchKey(dirInfo, file, opts.exactPathMatch(file)))
+	if opts.CompareXattrs {
+		key += "\x00" + file.XattrDigest
+	}
+	return key
+}
+
+// getPathMapFromDirectoryInfo maps compare keys to the paths of the reference files sharing them
+func getPathMapFromDirectoryInfo(dirInfo *DirectoryInfo, opts CompareOptions) map[string][]string {
+	pathMap := make(map[string][]string) // map[compareKey][]path
+	for _, file := range dirInfo.Files {
+		key := compareKey(dirInfo, file, opts, opts.NormalizeRefPath)
+		if key == "" {
+			continue
+		}
+		pathMap[key] = append(pathMap[key], file.Path)
+	}
+	return pathMap
+}
+
+// withInlineContent returns the refPaths whose inline content, if any, matches that of file
+func withInlineContent(refPaths []string, refContent map[string]string, file FileInfo) []string {
+	var matching []string
+	for _, refPath := range refPaths {
+		if inlineContentMatches(FileInfo{Content: refContent[refPath]}, file) {
+			matching = append(matching, refPath)
+		}
+	}
+	return matching
+}
+
+// withModTime returns the refPaths modified within tolerance of file
+func withModTime(refPaths []string, refModTimes map[string]time.Time, file FileInfo, tolerance time.Duration) []string {
+	var matching []string
+	for _, refPath := range refPaths {
+		if modTimesMatch(refModTimes[refPath], file.ModTime, tolerance) {
+			matching = append(matching, refPath)
+		}
+	}
+	return matching
+}
+
+// modTimesMatch tells whether a and b are at most tolerance apart; a zero time matches nothing
+func modTimesMatch(a, b time.Time, tolerance time.Duration) bool {
+	if a.IsZero() || b.IsZero() {
+		return false
+	}
+	diff := a.Sub(b)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance
+}
+
+// matchingRefPath returns the first of refPaths that path can be a duplicate of, or "" if there is none.
+// If excludeSameFile is true, reference paths resolving to the same absolute path as path are skipped.
+func matchingRefPath(refPaths []string, path string, excludeSameFile bool) string {
+	if len(refPaths) == 0 {
+		return ""
+	}
+	if !excludeSameFile {
+		return refPaths[0]
+	}
+	absPath := absOrClean(path)
+	for _, refPath := range refPaths {
+		if absOrClean(refPath) != absPath {
+			return refPath
+		}
+	}
+	return ""
+}
+
+func absOrClean(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return absPath
 }
 
 // DeleteFiles deletes the given files
 func DeleteFiles(files []FileInfo) error {
-	for _, file := range files {
-		if err := os.Remove(file.Path); err != nil {
+	return DeleteFilesBatched(files, 0, 0, nil)
+}
+
+// DeleteFilesBatched deletes the given files in batches of batchSize, sleeping pause between batches
+// to spread the load on shared storage. A batchSize of 0 deletes everything in one batch.
+// If progress is not nil, it is called after each batch with the number of files deleted so far.
+func DeleteFilesBatched(files []FileInfo, batchSize int, pause time.Duration, progress func(deleted, total int)) error {
+	return deleteInBatches(files, batchSize, pause, nil, nil, func(batch []FileInfo, deleted int) error {
+		if progress != nil {
+			progress(deleted, len(files))
+		}
+		return nil
+	})
+}
+
+// deleteInBatches is DeleteFilesBatched leaving alone the files keep, if not nil, returns true for when asked
+// right before each removal, calling removed, if not nil, right after each file is removed, and calling afterBatch
+// with the files deleted of each batch and the number of files processed so far. An error from afterBatch stops
+// the deletion.
+func deleteInBatches(files []FileInfo, batchSize int, pause time.Duration, keep func(file FileInfo) bool, removed func(file FileInfo), afterBatch func(batch []FileInfo, processed int) error) error {
+	if batchSize <= 0 {
+		batchSize = len(files)
+	}
+	for start := 0; start < len(files); start += batchSize {
+		if start > 0 && pause > 0 {
+			time.Sleep(pause)
+		}
+		end := start + batchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		var batch []FileInfo
+		for _, file := range files[start:end] {
+			// decide right before removing, so a check such as a re-hash sees the file as it is deleted
+			if keep != nil && keep(file) {
+				continue
+			}
+			if err := os.Remove(longPath(file.Path)); err != nil {
+				return err
+			}
+			batch = append(batch, file)
+			if removed != nil {
+				removed(file)
+			}
+		}
+		if err := afterBatch(batch, end); err != nil {
 			return err
 		}
 	}
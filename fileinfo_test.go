@@ -1,10 +1,16 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
 )
 
 // Helper function to create test files based on a given structure
@@ -213,3 +219,504 @@ func TestCompareFiles(t *testing.T) {
 		t.Errorf("Some expected duplicates (non-exact match) were not found: %v", expectedNonExact)
 	}
 }
+
+func TestCompareFilesExcludeSameFile(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a/file1.txt", "This is file 1"},
+		{"a/b/file1.txt", "This is file 1"},
+		{"a/b/file2.txt", "This is file 2"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	// reference and target overlap: the target is a subdirectory of the reference
+	refDirInfo, err := WalkDirectory(filepath.Join(testDir, "a"), 1, false)
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	targetDirInfo, err := WalkDirectory(filepath.Join(testDir, "a/b"), 1, false)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+
+	if duplicates := CompareFiles(refDirInfo, targetDirInfo, false); len(duplicates) != 2 {
+		t.Errorf("Expected self matches without ExcludeSameFile: got %d duplicates, want 2", len(duplicates))
+	}
+
+	duplicates := CompareFilesWithOptions(refDirInfo, targetDirInfo, CompareOptions{ExcludeSameFile: true})
+	if len(duplicates) != 1 || duplicates[0].Path != filepath.Join(testDir, "a/b/file1.txt") {
+		t.Errorf("Unexpected duplicates with ExcludeSameFile: %v", duplicates)
+	}
+}
+
+func TestCompareFilesStream(t *testing.T) {
+	refDir, targetDir, err := createExactTestFiles()
+	if err != nil {
+		t.Fatalf("Failed to create exact test files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+	defer removeTestFiles(targetDir)
+
+	refDirInfo, err := WalkDirectory(refDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	targetDirInfo, err := WalkDirectory(targetDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+
+	count := 0
+	for duplicate := range CompareFilesStream(refDirInfo, targetDirInfo, CompareOptions{ExactPathMatch: true}) {
+		relPath, _ := filepath.Rel(targetDir, duplicate.File.Path)
+		if duplicate.RefPath != filepath.Join(refDir, relPath) {
+			t.Errorf("Unexpected reference path for %s: %s", duplicate.File.Path, duplicate.RefPath)
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("Unexpected number of streamed duplicates: got %d, want 3", count)
+	}
+}
+
+func TestCompareFilesMetaOnly(t *testing.T) {
+	refDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"same.txt", "aaaa"},
+		{"edited.txt", "aaaa"},
+		{"touched.txt", "aaaa"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create reference files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+	targetDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"same.txt", "aaaa"},
+		{"edited.txt", "bbbb"}, // same size and mtime, different content: an unverified match
+		{"touched.txt", "aaaa"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create target files: %v", err)
+	}
+	defer removeTestFiles(targetDir)
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, dir := range []string{refDir, targetDir} {
+		for _, name := range []string{"same.txt", "edited.txt", "touched.txt"} {
+			if err := os.Chtimes(filepath.Join(dir, name), modTime, modTime); err != nil {
+				t.Fatalf("Failed to set modification time: %v", err)
+			}
+		}
+	}
+	touched := modTime.Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(targetDir, "touched.txt"), touched, touched); err != nil {
+		t.Fatalf("Failed to set modification time: %v", err)
+	}
+
+	refDirInfo, err := WalkDirectoryWithOptions(refDir, WalkOptions{HashWorkers: 1, MetaOnly: true})
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	targetDirInfo, err := WalkDirectoryWithOptions(targetDir, WalkOptions{HashWorkers: 1, MetaOnly: true})
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+	for _, file := range targetDirInfo.Files {
+		if file.Hash != "" {
+			t.Errorf("Unexpected hash for %s in metadata-only walk: %s", file.Path, file.Hash)
+		}
+	}
+
+	duplicates := CompareFilesWithOptions(refDirInfo, targetDirInfo, CompareOptions{ExactPathMatch: true, MetaOnly: true})
+	got := make(map[string]bool)
+	for _, file := range duplicates {
+		got[filepath.Base(file.Path)] = true
+	}
+	if len(got) != 2 || !got["same.txt"] || !got["edited.txt"] {
+		t.Errorf("Unexpected metadata-only duplicates: %v", got)
+	}
+
+	if duplicates := CompareFilesWithOptions(refDirInfo, targetDirInfo, CompareOptions{ExactPathMatch: true}); len(duplicates) != 0 {
+		t.Errorf("Unexpected number of content duplicates without hashes: got %d, want 0", len(duplicates))
+	}
+}
+
+func TestCompareFilesNameAndSize(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref/photo.jpg", "aaaa"},
+		{"ref/notes.txt", "aaaa"},
+		{"target/backup/photo.jpg", "aaaa"},
+		{"target/old/photo.jpg", "bbbb"}, // same name and size, different content: an unverified match
+		{"target/notes.txt", "aaaaa"},
+		{"target/other.txt", "aaaa"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	walkOpts := WalkOptions{HashWorkers: 1, MetaOnly: true}
+	refDirInfo, err := WalkDirectoryWithOptions(filepath.Join(testDir, "ref"), walkOpts)
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	targetDirInfo, err := WalkDirectoryWithOptions(filepath.Join(testDir, "target"), walkOpts)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+
+	// the name is matched even when exact paths are asked for
+	var candidates []Duplicate
+	for duplicate := range CompareFilesStream(refDirInfo, targetDirInfo, CompareOptions{ExactPathMatch: true, NameAndSize: true}) {
+		candidates = append(candidates, duplicate)
+	}
+	got := make(map[string]bool)
+	for _, duplicate := range candidates {
+		relPath, _ := filepath.Rel(targetDirInfo.BaseDir, duplicate.File.Path)
+		got[filepath.ToSlash(relPath)] = true
+	}
+	if len(got) != 2 || !got["backup/photo.jpg"] || !got["old/photo.jpg"] {
+		t.Errorf("Unexpected name and size matches: %v", got)
+	}
+
+	confirmed := ConfirmMatches(candidates, nil)
+	if len(confirmed) != 1 || filepath.Base(filepath.Dir(confirmed[0].File.Path)) != "backup" {
+		t.Errorf("Unexpected confirmed matches: %v", confirmed)
+	}
+}
+
+func TestWalkDirectoryFollowSymlinks(t *testing.T) {
+	refDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"canonical/photo.jpg", "photo content"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+
+	// reference layout points at canonical content through symlinks
+	os.MkdirAll(filepath.Join(refDir, "album"), 0755)
+	if err := os.Symlink(filepath.Join(refDir, "canonical/photo.jpg"), filepath.Join(refDir, "album/photo.jpg")); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	os.Symlink(filepath.Join(refDir, "missing.jpg"), filepath.Join(refDir, "album/dangling.jpg"))
+	os.Symlink(filepath.Join(refDir, "canonical"), filepath.Join(refDir, "linkeddir"))
+
+	targetDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"album/photo.jpg", "photo content"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(targetDir)
+
+	targetDirInfo, err := WalkDirectory(targetDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+
+	refDirInfo, err := WalkDirectory(refDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	if duplicates := CompareFiles(refDirInfo, targetDirInfo, true); len(duplicates) != 0 {
+		t.Errorf("Symlinked reference files matched without FollowSymlinks: %v", duplicates)
+	}
+
+	refDirInfo, err = WalkDirectoryWithOptions(refDir, WalkOptions{HashWorkers: 1, FollowSymlinks: true})
+	if err != nil {
+		t.Fatalf("Error walking reference directory with FollowSymlinks: %v", err)
+	}
+	if len(refDirInfo.Files) != 2 {
+		t.Errorf("Unexpected reference files with FollowSymlinks: %v", refDirInfo.Files)
+	}
+
+	found := false
+	for duplicate := range CompareFilesStream(refDirInfo, targetDirInfo, CompareOptions{ExactPathMatch: true}) {
+		found = true
+		if duplicate.RefPath != filepath.Join(refDir, "album/photo.jpg") {
+			t.Errorf("Expected the link path as reference path, got %s", duplicate.RefPath)
+		}
+	}
+	if !found {
+		t.Errorf("Target file matching symlinked reference content was not flagged")
+	}
+}
+
+func TestDeleteFilesBatched(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"file1.txt", "1"},
+		{"file2.txt", "2"},
+		{"file3.txt", "3"},
+		{"file4.txt", "4"},
+		{"file5.txt", "5"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	dirInfo, err := WalkDirectory(testDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+
+	var batches []int
+	err = DeleteFilesBatched(dirInfo.Files, 2, time.Millisecond, func(deleted, total int) {
+		batches = append(batches, deleted)
+	})
+	if err != nil {
+		t.Fatalf("Error deleting files: %v", err)
+	}
+	if len(batches) != 3 || batches[0] != 2 || batches[1] != 4 || batches[2] != 5 {
+		t.Errorf("Unexpected batch progress: %v", batches)
+	}
+	for _, file := range dirInfo.Files {
+		if _, err := os.Stat(file.Path); !os.IsNotExist(err) {
+			t.Errorf("File was not deleted: %s", file.Path)
+		}
+	}
+}
+
+func TestWalkDirectoryWalkWorkers(t *testing.T) {
+	var fileStructure []struct{ Path, Content string }
+	for _, dir := range []string{"a", "a/b", "a/b/c", "d", "d/e", "f"} {
+		for _, name := range []string{"1.txt", "2.txt"} {
+			fileStructure = append(fileStructure, struct{ Path, Content string }{filepath.Join(dir, name), dir + name})
+		}
+	}
+	testDir, err := createTestFiles(fileStructure)
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	var summary Summary
+	dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{
+		HashWorkers: 2,
+		WalkWorkers: 3,
+		Hooks:       &Hooks{OnComplete: func(s Summary) { summary = s }},
+	})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if len(dirInfo.Files) != len(fileStructure) {
+		t.Errorf("Unexpected number of files: got %d, want %d", len(dirInfo.Files), len(fileStructure))
+	}
+	if summary.HashWorkers != 2 || summary.WalkWorkers != 3 {
+		t.Errorf("Unexpected worker counts in summary: %+v", summary)
+	}
+
+	if _, err := WalkDirectory(filepath.Join(testDir, "missing"), 1, false); err == nil {
+		t.Errorf("Expected an error walking a missing directory")
+	}
+}
+
+func TestCanonicalPaths(t *testing.T) {
+	refDir, targetDir, err := createExactTestFiles()
+	if err != nil {
+		t.Fatalf("Failed to create exact test files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+	defer removeTestFiles(targetDir)
+
+	refDirInfo, err := WalkDirectory(refDir+"/./subdir/..", 1, false)
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	if refDirInfo.BaseDir != refDir {
+		t.Errorf("Base directory was not cleaned: %s", refDirInfo.BaseDir)
+	}
+
+	// a hand-written manifest with messy but equivalent paths
+	targetDirInfo := &DirectoryInfo{BaseDir: targetDir + "//.", Files: []FileInfo{
+		{Path: targetDir + "/./file1.txt", Hash: "eedf707e950e8315f7287656d49190d08dcafc0ebd0fd68ee653cd2ce6801b01"},
+		{Path: targetDir + "/subdir/../subdir//file3.txt", Hash: "3db623ae371bcede75cbce0f1200e873822b93547867d5ad29716418c4eb8293"},
+	}}
+	if err := CanonicalizePaths(targetDirInfo, false); err != nil {
+		t.Fatalf("Error canonicalizing paths: %v", err)
+	}
+	if targetDirInfo.Files[1].Path != filepath.Join(targetDir, "subdir/file3.txt") {
+		t.Errorf("File path was not cleaned: %s", targetDirInfo.Files[1].Path)
+	}
+	if duplicates := CompareFiles(refDirInfo, targetDirInfo, true); len(duplicates) != 2 {
+		t.Errorf("Unexpected duplicates for canonicalized paths: %v", duplicates)
+	}
+
+	// resolving symlinks rebases files onto the real base directory
+	linkDir := targetDir + "-link"
+	if err := os.Symlink(targetDir, linkDir); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+	defer os.Remove(linkDir)
+	linkedDirInfo := &DirectoryInfo{BaseDir: linkDir, Files: []FileInfo{{Path: filepath.Join(linkDir, "file1.txt")}}}
+	if err := CanonicalizePaths(linkedDirInfo, true); err != nil {
+		t.Fatalf("Error resolving symlinks: %v", err)
+	}
+	resolved, _ := filepath.EvalSymlinks(targetDir)
+	if linkedDirInfo.BaseDir != resolved || linkedDirInfo.Files[0].Path != filepath.Join(resolved, "file1.txt") {
+		t.Errorf("Unexpected resolved paths: %+v", linkedDirInfo)
+	}
+}
+
+func TestCompareFilesRequireModTimeMatch(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/a.txt", Hash: "aaa", ModTime: modTime},
+		{Path: "/ref/b.txt", Hash: "bbb", ModTime: modTime},
+		{Path: "/ref/c.txt", Hash: "ccc", ModTime: modTime},
+		{Path: "/ref/d.txt", Hash: "ddd"},
+	}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{
+		{Path: "/target/a.txt", Hash: "aaa", ModTime: modTime},
+		{Path: "/target/b.txt", Hash: "bbb", ModTime: modTime.Add(-time.Second)},
+		{Path: "/target/c.txt", Hash: "ccc", ModTime: modTime.Add(time.Hour)}, // re-created
+		{Path: "/target/d.txt", Hash: "ddd", ModTime: modTime},
+	}}
+
+	for _, tc := range []struct {
+		tolerance time.Duration
+		want      []string
+	}{
+		{0, []string{"/target/a.txt"}},
+		{2 * time.Second, []string{"/target/a.txt", "/target/b.txt"}},
+	} {
+		var got []string
+		for _, file := range CompareFilesWithOptions(refDir, targetDir, CompareOptions{RequireModTimeMatch: true, ModTimeTolerance: tc.tolerance}) {
+			got = append(got, file.Path)
+		}
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("Unexpected duplicates with tolerance %v: got %v, want %v", tc.tolerance, got, tc.want)
+		}
+	}
+
+	if got := CompareFilesWithOptions(refDir, targetDir, CompareOptions{}); len(got) != 4 {
+		t.Errorf("Unexpected duplicates by content only: got %d, want 4", len(got))
+	}
+}
+
+func TestCompareFilesEmptyFilesSamePath(t *testing.T) {
+	const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/pkg/__init__.py", Hash: emptyHash},
+		{Path: "/ref/placeholder", Hash: emptyHash},
+		{Path: "/ref/pkg/mod.py", Hash: "mmm", Size: 3},
+	}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{
+		{Path: "/target/pkg/__init__.py", Hash: emptyHash},
+		{Path: "/target/other/__init__.py", Hash: emptyHash},
+		{Path: "/target/.keep", Hash: emptyHash},
+		{Path: "/target/elsewhere/mod.py", Hash: "mmm", Size: 3},
+	}}
+
+	for _, tc := range []struct {
+		opts CompareOptions
+		want []string
+	}{
+		// by file name, any empty __init__.py duplicates the reference one
+		{CompareOptions{}, []string{"/target/pkg/__init__.py", "/target/other/__init__.py", "/target/elsewhere/mod.py"}},
+		{CompareOptions{EmptyFilesSamePath: true}, []string{"/target/pkg/__init__.py", "/target/elsewhere/mod.py"}},
+		{CompareOptions{EmptyFilesSamePath: true, ExactPathMatch: true}, []string{"/target/pkg/__init__.py"}},
+		{CompareOptions{EmptyFilesSamePath: true, NameAndSize: true}, []string{"/target/pkg/__init__.py", "/target/elsewhere/mod.py"}},
+	} {
+		var got []string
+		for _, file := range CompareFilesWithOptions(refDir, targetDir, tc.opts) {
+			got = append(got, file.Path)
+		}
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("Unexpected duplicates with %+v: got %v, want %v", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestCompareFilesEmptyFilesSamePathLegacyManifest(t *testing.T) {
+	testDir, err := createTestFiles(nil)
+	if err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	// manifests from before sizes were recorded list no size for any file, empty or not
+	manifests := map[string]string{
+		"ref.yml": `baseDir: /ref
+files:
+- path: /ref/pkg/__init__.py
+  hash: e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+- path: /ref/pkg/mod.py
+  hash: mmm
+- path: /ref/pkg/.keep
+  hash: ef46db3751d8e999
+  hashAlgo: xxhash
+`,
+		"target.yml": `baseDir: /target
+files:
+- path: /target/other/__init__.py
+  hash: e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+- path: /target/elsewhere/mod.py
+  hash: mmm
+- path: /target/elsewhere/.keep
+  hash: ef46db3751d8e999
+  hashAlgo: xxhash
+`,
+	}
+	loaded := make(map[string]*DirectoryInfo)
+	for name, content := range manifests {
+		path := filepath.Join(testDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+		if loaded[name], err = readDirectoryInfoFromYAML(path); err != nil {
+			t.Fatalf("Error reading %s: %v", name, err)
+		}
+	}
+
+	var got []string
+	for _, file := range CompareFilesWithOptions(loaded["ref.yml"], loaded["target.yml"], CompareOptions{EmptyFilesSamePath: true}) {
+		got = append(got, file.Path)
+	}
+	if want := []string{"/target/elsewhere/mod.py"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Unexpected duplicates from sizeless manifests: got %v, want %v", got, want)
+	}
+}
+
+func TestWalkDirectoryYAMLEntryFailure(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "a"},
+		{"bad.txt", "bad"},
+		{"c.txt", "c"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	defer func(marshal func(interface{}) ([]byte, error)) { marshalManifestEntry = marshal }(marshalManifestEntry)
+	marshalManifestEntry = func(in interface{}) ([]byte, error) {
+		if filepath.Base(in.(*FileInfo).Path) == "bad.txt" {
+			return nil, errors.New("cannot marshal")
+		}
+		return yaml.Marshal(in)
+	}
+
+	var manifest strings.Builder
+	dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{HashWorkers: 1, YamlOutput: &manifest})
+	if err != nil {
+		t.Fatalf("Expected the walk to go on past an entry that fails to marshal, got %v", err)
+	}
+	if len(dirInfo.Files) != 3 {
+		t.Errorf("Unexpected number of files: got %d, want 3", len(dirInfo.Files))
+	}
+	var streamed DirectoryInfo
+	if err := yaml.Unmarshal([]byte(manifest.String()), &streamed); err != nil {
+		t.Fatalf("Streamed manifest does not parse: %v\n%s", err, manifest.String())
+	}
+	var names []string
+	for _, file := range streamed.Files {
+		names = append(names, filepath.Base(file.Path))
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "a.txt c.txt" {
+		t.Errorf("Unexpected streamed entries: got %v, want [a.txt c.txt]", names)
+	}
+}
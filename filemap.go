@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FileMap is the serializable form of GetFileMapFromDirectoryInfo, for consumption by other tools
+type FileMap struct {
+	BaseDir string `yaml:"baseDir" json:"baseDir"`
+	// ExactPathMatch tells whether Hashes lists relative paths (true) or just file names
+	ExactPathMatch bool `yaml:"exactPathMatch" json:"exactPathMatch"`
+	// Hashes maps each hash to the sorted relative paths or names of the files with it
+	Hashes map[string][]string `yaml:"hashes" json:"hashes"`
+}
+
+// NewFileMap builds the hash to paths map of dirInfo
+func NewFileMap(dirInfo *DirectoryInfo, exactPathMatch bool) *FileMap {
+	fileMap := &FileMap{BaseDir: dirInfo.BaseDir, ExactPathMatch: exactPathMatch, Hashes: make(map[string][]string)}
+	for hash, paths := range GetFileMapFromDirectoryInfo(dirInfo, exactPathMatch) {
+		for path := range paths {
+			fileMap.Hashes[hash] = append(fileMap.Hashes[hash], path)
+		}
+		sort.Strings(fileMap.Hashes[hash])
+	}
+	return fileMap
+}
+
+// WriteFileMap writes fileMap to w as "json" or "yaml"
+func WriteFileMap(w io.Writer, fileMap *FileMap, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(fileMap)
+	case "yaml":
+		data, err := yaml.Marshal(fileMap)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return fmt.Errorf("unknown file map format %q", format)
+}
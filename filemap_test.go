@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestWriteFileMap(t *testing.T) {
+	dirInfo := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/b/x.txt", Hash: "aaa"},
+		{Path: "/ref/a/x.txt", Hash: "aaa"},
+		{Path: "/ref/y.txt", Hash: "bbb"},
+	}}
+	fileMap := NewFileMap(dirInfo, true)
+	want := map[string][]string{"aaa": {"a/x.txt", "b/x.txt"}, "bbb": {"y.txt"}}
+	if !reflect.DeepEqual(fileMap.Hashes, want) {
+		t.Errorf("Unexpected file map: got %v, want %v", fileMap.Hashes, want)
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		var buf bytes.Buffer
+		if err := WriteFileMap(&buf, fileMap, format); err != nil {
+			t.Fatalf("Error writing %s file map: %v", format, err)
+		}
+		var loaded FileMap
+		var err error
+		if format == "json" {
+			err = json.Unmarshal(buf.Bytes(), &loaded)
+		} else {
+			err = yaml.Unmarshal(buf.Bytes(), &loaded)
+		}
+		if err != nil {
+			t.Fatalf("Error reading %s file map: %v", format, err)
+		}
+		if !reflect.DeepEqual(&loaded, fileMap) {
+			t.Errorf("Unexpected %s round trip: got %+v, want %+v", format, loaded, fileMap)
+		}
+	}
+
+	if names := NewFileMap(dirInfo, false); !reflect.DeepEqual(names.Hashes["aaa"], []string{"x.txt"}) {
+		t.Errorf("Unexpected file names: %v", names.Hashes["aaa"])
+	}
+}
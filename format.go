@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FormatBytes renders n as a human-readable size, using 1000-based units (kB, MB, ...) if si is true
+// and 1024-based units (KiB, MiB, ...) otherwise
+func FormatBytes(n int64, si bool) string {
+	unit, prefixes, suffix := int64(1024), "KMGTPE", "iB"
+	if si {
+		unit, prefixes, suffix = 1000, "kMGTPE", "B"
+	}
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n), 0
+	for value >= float64(unit) || value <= -float64(unit) {
+		value /= float64(unit)
+		exp++
+	}
+	return fmt.Sprintf("%.1f %c%s", value, prefixes[exp-1], suffix)
+}
+
+// FormatCount renders n with thousands separators, e.g. 182,374
+func FormatCount(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return sign + digits
+}
+
+// totalSize sums the sizes of files
+func totalSize(files []FileInfo) int64 {
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	return total
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestFormatBytes(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		si   bool
+		want string
+	}{
+		{0, false, "0 B"},
+		{1023, false, "1023 B"},
+		{1024, false, "1.0 KiB"},
+		{1536, false, "1.5 KiB"},
+		{4823749283, false, "4.5 GiB"},
+		{999, true, "999 B"},
+		{1000, true, "1.0 kB"},
+		{4823749283, true, "4.8 GB"},
+	} {
+		if got := FormatBytes(tc.n, tc.si); got != tc.want {
+			t.Errorf("FormatBytes(%d, %v) = %q, want %q", tc.n, tc.si, got, tc.want)
+		}
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	for n, want := range map[int]string{
+		0:        "0",
+		999:      "999",
+		1000:     "1,000",
+		182374:   "182,374",
+		-1234567: "-1,234,567",
+	} {
+		if got := FormatCount(n); got != want {
+			t.Errorf("FormatCount(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -2,8 +2,12 @@ module deduplicator
 
 go 1.20
 
+require gopkg.in/yaml.v2 v2.4.0
+
 require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/klauspost/compress v1.17.9
 	golang.org/x/crypto v0.23.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	golang.org/x/sys v0.20.0
+	golang.org/x/text v0.15.0
 )
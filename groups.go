@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DuplicateGroup is a set of files within one tree that share the same content
+type DuplicateGroup struct {
+	Hash string
+	// XattrDigest is the extended attribute digest the files share under CompareOptions.CompareXattrs
+	XattrDigest string
+	Files       []FileInfo
+}
+
+// key identifies the group among the groups of a tree and of earlier scans of it
+func (g DuplicateGroup) key() string {
+	return g.Hash + "\x00" + g.XattrDigest
+}
+
+// FindDuplicateGroups groups the files of dirInfo by content hash (or body hash under opts.UseBodyHash), and
+// additionally by extended attribute digest under opts.CompareXattrs. It returns only groups with more than
+// one file, ordered by hash. The other options do not apply within one tree.
+func FindDuplicateGroups(dirInfo *DirectoryInfo, opts CompareOptions) []DuplicateGroup {
+	byKey := make(map[string]*DuplicateGroup)
+	for _, file := range dirInfo.Files {
+		group := DuplicateGroup{Hash: file.Hash}
+		if opts.UseBodyHash {
+			group.Hash = file.BodyHash
+		}
+		if group.Hash == "" {
+			continue
+		}
+		if opts.CompareXattrs {
+			group.XattrDigest = file.XattrDigest
+		}
+		existing, ok := byKey[group.key()]
+		if !ok {
+			existing = &group
+			byKey[group.key()] = existing
+		}
+		existing.Files = append(existing.Files, file)
+	}
+
+	var groups []DuplicateGroup
+	for _, group := range byKey {
+		if len(group.Files) < 2 {
+			continue
+		}
+		files := group.Files
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].key() < groups[j].key() })
+	return groups
+}
+
+// KeeperPolicy decides which file of a duplicate group survives.
+// By default the file with the fewest path components is kept, ties broken by path order.
+// KeepPatterns and DeletePatterns override that default where they apply.
+type KeeperPolicy struct {
+	// KeepPatterns marks preferred keepers, e.g. "*/originals/*"
+	KeepPatterns []string
+	// DeletePatterns marks files to delete in preference to others, e.g. "*/copies/*"
+	DeletePatterns []string
+	// PreferMostLinks keeps the file with the most hardlinks among those the patterns leave, since deleting a
+	// path of a multiply-linked file frees nothing while deleting a standalone copy does. It needs FileInfo.Links
+	// (see WalkOptions.CaptureInodes).
+	PreferMostLinks bool
+}
+
+// ChooseKeeper returns the index of the file to keep in group.Files. If the patterns do not single out
+// a clear keeper (all or none of the files match), the default order decides and a warning explains why.
+func (p KeeperPolicy) ChooseKeeper(group DuplicateGroup) (int, string) {
+	keepMatches := matchingIndexes(group.Files, p.KeepPatterns)
+	deleteMatches := matchingIndexes(group.Files, p.DeletePatterns)
+
+	pool := make([]int, len(group.Files))
+	for i := range pool {
+		pool[i] = i
+	}
+	var warning string
+	if len(p.KeepPatterns) > 0 || len(p.DeletePatterns) > 0 {
+		switch {
+		case len(keepMatches) == 0 && len(deleteMatches) == 0:
+			warning = "no file matches -keepPattern or -deletePattern"
+		case len(keepMatches) == len(group.Files) && len(group.Files) > 1:
+			warning = "all files match -keepPattern"
+		case len(keepMatches) == 0 && len(deleteMatches) == len(group.Files):
+			warning = "all files match -deletePattern"
+		}
+	}
+
+	if len(keepMatches) > 0 {
+		pool = keepMatches
+	}
+	if len(deleteMatches) > 0 {
+		var remaining []int
+		for _, i := range pool {
+			if !containsIndex(deleteMatches, i) {
+				remaining = append(remaining, i)
+			}
+		}
+		if len(remaining) > 0 {
+			pool = remaining
+		} else if len(keepMatches) > 0 {
+			warning = "every file matching -keepPattern also matches -deletePattern"
+		}
+	}
+
+	keeper := pool[0]
+	for _, i := range pool[1:] {
+		if p.PreferMostLinks && group.Files[i].Links != group.Files[keeper].Links {
+			if group.Files[i].Links > group.Files[keeper].Links {
+				keeper = i
+			}
+			continue
+		}
+		if defaultKeeperLess(group.Files[i], group.Files[keeper]) {
+			keeper = i
+		}
+	}
+	return keeper, warning
+}
+
+// defaultKeeperLess orders keeper candidates: shallower paths first, then by path
+func defaultKeeperLess(a, b FileInfo) bool {
+	da, db := strings.Count(filepath.ToSlash(a.Path), "/"), strings.Count(filepath.ToSlash(b.Path), "/")
+	if da != db {
+		return da < db
+	}
+	return a.Path < b.Path
+}
+
+func matchingIndexes(files []FileInfo, patterns []string) []int {
+	var matches []int
+	for i, file := range files {
+		for _, pattern := range patterns {
+			if MatchPathPattern(pattern, file.Path) {
+				matches = append(matches, i)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func containsIndex(indexes []int, i int) bool {
+	for _, j := range indexes {
+		if i == j {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPathPattern matches path against a glob where * matches any run of characters, including
+// path separators, and ? matches one character. Paths are compared with forward slashes.
+func MatchPathPattern(pattern, path string) bool {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	matched, _ := regexp.MatchString(expr.String(), filepath.ToSlash(path))
+	return matched
+}
+
+// SelfDuplicates chooses a keeper in every group and returns the other files as duplicates of it.
+// Warnings about groups without a clear keeper are passed to warn, if not nil.
+func SelfDuplicates(groups []DuplicateGroup, policy KeeperPolicy, warn func(group DuplicateGroup, warning string)) []Duplicate {
+	var duplicates []Duplicate
+	for _, group := range groups {
+		keeper, warning := policy.ChooseKeeper(group)
+		if warning != "" && warn != nil {
+			warn(group, fmt.Sprintf("%s, keeping %s", warning, group.Files[keeper].Path))
+		}
+		for i, file := range group.Files {
+			if i != keeper {
+				duplicates = append(duplicates, Duplicate{File: file, RefPath: group.Files[keeper].Path})
+			}
+		}
+	}
+	return duplicates
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestChooseKeeper(t *testing.T) {
+	group := DuplicateGroup{Hash: "a", Files: []FileInfo{
+		{Path: "/photos/2024/copies/deep/img.jpg", Links: 3},
+		{Path: "/photos/2024/originals/img.jpg", Links: 1},
+		{Path: "/photos/img.jpg", Links: 1},
+	}}
+
+	for _, tc := range []struct {
+		name        string
+		policy      KeeperPolicy
+		keeper      string
+		wantWarning bool
+	}{
+		{"default keeps the shallowest path", KeeperPolicy{}, "/photos/img.jpg", false},
+		{"keep pattern", KeeperPolicy{KeepPatterns: []string{"*/originals/*"}}, "/photos/2024/originals/img.jpg", false},
+		{"delete pattern only excludes matches", KeeperPolicy{DeletePatterns: []string{"*/img.jpg"}}, "/photos/img.jpg", true},
+		{"delete pattern", KeeperPolicy{DeletePatterns: []string{"/photos/img.jpg"}}, "/photos/2024/originals/img.jpg", false},
+		{"no pattern applies", KeeperPolicy{KeepPatterns: []string{"*/masters/*"}}, "/photos/img.jpg", true},
+		{"all match keep", KeeperPolicy{KeepPatterns: []string{"/photos/*"}}, "/photos/img.jpg", true},
+		{
+			"keep and delete conflict on the same file",
+			KeeperPolicy{KeepPatterns: []string{"*/originals/*"}, DeletePatterns: []string{"*/2024/*"}},
+			"/photos/2024/originals/img.jpg",
+			true,
+		},
+		{
+			"delete narrows keep matches",
+			KeeperPolicy{KeepPatterns: []string{"*/2024/*"}, DeletePatterns: []string{"*/copies/*"}},
+			"/photos/2024/originals/img.jpg",
+			false,
+		},
+		{"most links", KeeperPolicy{PreferMostLinks: true}, "/photos/2024/copies/deep/img.jpg", false},
+		{
+			"patterns before links",
+			KeeperPolicy{DeletePatterns: []string{"*/copies/*"}, PreferMostLinks: true},
+			"/photos/img.jpg",
+			false,
+		},
+	} {
+		keeper, warning := tc.policy.ChooseKeeper(group)
+		if got := group.Files[keeper].Path; got != tc.keeper {
+			t.Errorf("%s: kept %s, want %s", tc.name, got, tc.keeper)
+		}
+		if (warning != "") != tc.wantWarning {
+			t.Errorf("%s: unexpected warning %q", tc.name, warning)
+		}
+	}
+}
+
+func TestFindDuplicateGroups(t *testing.T) {
+	dirInfo := &DirectoryInfo{BaseDir: "/d", Files: []FileInfo{
+		{Path: "/d/b", Hash: "x"},
+		{Path: "/d/a", Hash: "x"},
+		{Path: "/d/c", Hash: "y"},
+	}}
+	groups := FindDuplicateGroups(dirInfo, CompareOptions{})
+	if len(groups) != 1 || len(groups[0].Files) != 2 || groups[0].Files[0].Path != "/d/a" {
+		t.Fatalf("Unexpected groups: %+v", groups)
+	}
+
+	duplicates := SelfDuplicates(groups, KeeperPolicy{}, nil)
+	if len(duplicates) != 1 || duplicates[0].File.Path != "/d/b" || duplicates[0].RefPath != "/d/a" {
+		t.Errorf("Unexpected self duplicates: %+v", duplicates)
+	}
+}
+
+func TestFindDuplicateGroupsCompareXattrs(t *testing.T) {
+	dirInfo := &DirectoryInfo{BaseDir: "/d", Files: []FileInfo{
+		{Path: "/d/red1.jpg", Hash: "x", XattrDigest: "red"},
+		{Path: "/d/red2.jpg", Hash: "x", XattrDigest: "red"},
+		{Path: "/d/blue.jpg", Hash: "x", XattrDigest: "blue"},
+	}}
+	if groups := FindDuplicateGroups(dirInfo, CompareOptions{}); len(groups) != 1 || len(groups[0].Files) != 3 {
+		t.Errorf("Expected xattrs to be ignored by default, got %+v", groups)
+	}
+	groups := FindDuplicateGroups(dirInfo, CompareOptions{CompareXattrs: true})
+	if len(groups) != 1 || len(groups[0].Files) != 2 || groups[0].XattrDigest != "red" {
+		t.Errorf("Unexpected groups with CompareXattrs: %+v", groups)
+	}
+}
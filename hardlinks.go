@@ -0,0 +1,95 @@
+package main
+
+import "sort"
+
+// HardlinkGroup is a set of paths in one tree that are hardlinks to the same file
+type HardlinkGroup struct {
+	Files []FileInfo
+	// Scheduled tells whether every path of the group was a duplicate, so the group is deleted as a whole;
+	// otherwise none of its paths is
+	Scheduled bool
+}
+
+// PreserveHardlinkGroups makes sure the duplicates never break up a set of hardlinks in dirInfo, the tree they
+// are deleted from: the paths of a set are either all duplicates, or all removed from the plan. Files need their
+// Device and Inode (see WalkOptions.CaptureInodes); files without them are left as they are. It returns the
+// remaining duplicates in their original order and every hardlink set found, ordered by first path.
+func PreserveHardlinkGroups(duplicates []Duplicate, dirInfo *DirectoryInfo) ([]Duplicate, []HardlinkGroup) {
+	type identity struct{ device, inode uint64 }
+	linked := make(map[identity][]FileInfo)
+	for _, file := range dirInfo.Files {
+		if file.Inode == 0 {
+			continue
+		}
+		id := identity{file.Device, file.Inode}
+		linked[id] = append(linked[id], file)
+	}
+
+	scheduled := make(map[string]bool)
+	for _, duplicate := range duplicates {
+		scheduled[duplicate.File.Path] = true
+	}
+
+	var groups []HardlinkGroup
+	unscheduled := make(map[string]bool)
+	for _, files := range linked {
+		if len(files) < 2 {
+			continue
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+		group := HardlinkGroup{Files: files, Scheduled: true}
+		for _, file := range files {
+			if !scheduled[file.Path] {
+				group.Scheduled = false
+			}
+		}
+		if !group.Scheduled {
+			for _, file := range files {
+				unscheduled[file.Path] = true
+			}
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Files[0].Path < groups[j].Files[0].Path })
+
+	var remaining []Duplicate
+	for _, duplicate := range duplicates {
+		if !unscheduled[duplicate.File.Path] {
+			remaining = append(remaining, duplicate)
+		}
+	}
+	return remaining, groups
+}
+
+// FreedBytes returns the space deleting files frees. A file with several hardlinks frees its size only once every
+// one of its links is deleted, so deleting some paths of a multiply-linked file frees nothing. Files without a
+// link count (see WalkOptions.CaptureInodes) are counted in full.
+func FreedBytes(files []FileInfo) int64 {
+	var freed freedSpace
+	for _, file := range files {
+		freed.add(file)
+	}
+	return freed.bytes
+}
+
+// freedSpace totals FreedBytes one deleted file at a time
+type freedSpace struct {
+	bytes int64
+	// deleted counts the deleted links of each multiply-linked file
+	deleted map[[2]uint64]uint64
+}
+
+func (s *freedSpace) add(file FileInfo) {
+	if file.Links <= 1 || file.Inode == 0 {
+		s.bytes += file.Size
+		return
+	}
+	if s.deleted == nil {
+		s.deleted = make(map[[2]uint64]uint64)
+	}
+	id := [2]uint64{file.Device, file.Inode}
+	s.deleted[id]++
+	if s.deleted[id] == file.Links {
+		s.bytes += file.Size
+	}
+}
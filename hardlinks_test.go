@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreserveHardlinkGroups(t *testing.T) {
+	dirInfo := &DirectoryInfo{BaseDir: "/t", Files: []FileInfo{
+		{Path: "/t/a1", Device: 1, Inode: 10},
+		{Path: "/t/a2", Device: 1, Inode: 10},
+		{Path: "/t/b1", Device: 1, Inode: 20},
+		{Path: "/t/b2", Device: 1, Inode: 20},
+		{Path: "/t/c", Device: 1, Inode: 30},
+		// same inode number on another device
+		{Path: "/t/d", Device: 2, Inode: 30},
+		{Path: "/t/e"},
+	}}
+	duplicates := []Duplicate{
+		{File: dirInfo.Files[0], RefPath: "/r/a"},
+		{File: dirInfo.Files[2], RefPath: "/r/b"},
+		{File: dirInfo.Files[4], RefPath: "/r/c"},
+		{File: dirInfo.Files[1], RefPath: "/r/a"},
+		{File: dirInfo.Files[6], RefPath: "/r/e"},
+	}
+
+	remaining, groups := PreserveHardlinkGroups(duplicates, dirInfo)
+	var paths []string
+	for _, duplicate := range remaining {
+		paths = append(paths, duplicate.File.Path)
+	}
+	if want := []string{"/t/a1", "/t/c", "/t/a2", "/t/e"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Unexpected remaining duplicates: got %v, want %v", paths, want)
+	}
+	if len(groups) != 2 || !groups[0].Scheduled || groups[1].Scheduled ||
+		groups[0].Files[0].Path != "/t/a1" || groups[1].Files[0].Path != "/t/b1" {
+		t.Errorf("Unexpected hardlink groups: %+v", groups)
+	}
+}
+
+func TestFreedBytes(t *testing.T) {
+	files := []FileInfo{
+		// both links of inode 10 are deleted, so it is freed once
+		{Path: "/t/a1", Size: 100, Device: 1, Inode: 10, Links: 2},
+		{Path: "/t/a2", Size: 100, Device: 1, Inode: 10, Links: 2},
+		// inode 20 keeps two of its three links
+		{Path: "/t/b", Size: 50, Device: 1, Inode: 20, Links: 3},
+		{Path: "/t/c", Size: 7, Device: 1, Inode: 30, Links: 1},
+		// no link count recorded
+		{Path: "/t/d", Size: 3},
+	}
+	if got, want := FreedBytes(files), int64(110); got != want {
+		t.Errorf("Unexpected freed bytes: got %v, want %v", got, want)
+	}
+}
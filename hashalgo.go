@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultHashAlgo is the algorithm used when none is configured; FileInfo.HashAlgo is left empty for it
+const DefaultHashAlgo = "sha256"
+
+// hashAlgorithms maps the names accepted by -hashAlgo and -hashByExt to their constructors.
+// Everything selecting, validating or listing algorithms goes through it, so adding one only takes an entry here.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+	"xxhash": func() hash.Hash { return newXXH64() },
+}
+
+// emptyDigests maps each algorithm to its hex digest of no input, the hash every empty file gets
+var emptyDigests = func() map[string]string {
+	digests := make(map[string]string, len(hashAlgorithms))
+	for name, newHash := range hashAlgorithms {
+		digests[name] = fmt.Sprintf("%x", newHash().Sum(nil))
+	}
+	return digests
+}()
+
+// newHasher returns a hasher for algo, where "" means DefaultHashAlgo
+func newHasher(algo string) (hash.Hash, error) {
+	if algo == "" {
+		algo = DefaultHashAlgo
+	}
+	newHash, ok := hashAlgorithms[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q", algo)
+	}
+	return newHash(), nil
+}
+
+// HashAlgorithms returns the names of the supported hash algorithms, sorted
+func HashAlgorithms() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HashPolicy chooses the hash algorithm for each file
+type HashPolicy struct {
+	// Default is the algorithm for files not listed in ByExt; "" means DefaultHashAlgo
+	Default string
+	// ByExt maps lowercase extensions including the dot, e.g. ".mp4", to an algorithm
+	ByExt map[string]string
+}
+
+// AlgoFor returns the algorithm for path, normalized so that DefaultHashAlgo is ""
+func (p HashPolicy) AlgoFor(path string) string {
+	algo, ok := p.ByExt[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		algo = p.Default
+	}
+	if algo == DefaultHashAlgo {
+		return ""
+	}
+	return algo
+}
+
+// Validate checks that every algorithm of the policy is known
+func (p HashPolicy) Validate() error {
+	if _, err := newHasher(p.Default); err != nil {
+		return err
+	}
+	for ext, algo := range p.ByExt {
+		if _, err := newHasher(algo); err != nil {
+			return fmt.Errorf("%s: %w", ext, err)
+		}
+	}
+	return nil
+}
+
+// ParseHashByExt parses a mapping like ".mp4=xxhash,.mkv=xxhash" into HashPolicy.ByExt form
+func ParseHashByExt(spec string) (map[string]string, error) {
+	byExt := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ext, algo, ok := strings.Cut(entry, "=")
+		ext, algo = strings.ToLower(strings.TrimSpace(ext)), strings.TrimSpace(algo)
+		if !ok || ext == "" || algo == "" {
+			return nil, fmt.Errorf("expected .ext=algorithm, got %q", entry)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		byExt[ext] = algo
+	}
+	return byExt, nil
+}
+
+// ValidateHash checks that hash is a lowercase hex digest of the length algo produces, as the walk writes it
+func ValidateHash(hash, algo string) error {
+	hasher, err := newHasher(algo)
+	if err != nil {
+		return err
+	}
+	if want := hasher.Size() * 2; len(hash) != want {
+		if algo == "" {
+			algo = DefaultHashAlgo
+		}
+		return fmt.Errorf("%s hash %q has %d characters, expected %d", algo, hash, len(hash), want)
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("hash %q is not lowercase hex", hash)
+		}
+	}
+	return nil
+}
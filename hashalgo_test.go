@@ -0,0 +1,132 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestXXH64(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "ef46db3751d8e999"},
+		{"a", "d24ec4f1a98c6e5b"},
+		{"abc", "44bc2cf5ad770999"},
+		{"Nobody inspects the spammish repetition", "fbcea83c8a378bf1"},
+	}
+	for _, test := range tests {
+		got, err := HashReaderWith(strings.NewReader(test.input), "xxhash")
+		if err != nil {
+			t.Fatalf("Error hashing %q: %v", test.input, err)
+		}
+		if got != test.want {
+			t.Errorf("Unexpected xxhash of %q: got %s, want %s", test.input, got, test.want)
+		}
+	}
+
+	// writes split across stripe boundaries must not change the digest
+	data := []byte(strings.Repeat("0123456789abcdef", 10))
+	h := newXXH64()
+	for _, chunk := range [][]byte{data[:5], data[5:40], data[40:71], data[71:]} {
+		h.Write(chunk)
+	}
+	whole := newXXH64()
+	whole.Write(data)
+	if h.Sum64() != whole.Sum64() {
+		t.Errorf("Unexpected xxhash of chunked input: got %x, want %x", h.Sum64(), whole.Sum64())
+	}
+}
+
+func TestParseHashByExt(t *testing.T) {
+	byExt, err := ParseHashByExt(".mp4=xxhash, MKV=xxhash")
+	if err != nil {
+		t.Fatalf("Error parsing mapping: %v", err)
+	}
+	policy := HashPolicy{Default: "sha512", ByExt: byExt}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("Unexpected invalid policy: %v", err)
+	}
+	for path, want := range map[string]string{"a/movie.MP4": "xxhash", "b.mkv": "xxhash", "doc.pdf": "sha512"} {
+		if got := policy.AlgoFor(path); got != want {
+			t.Errorf("Unexpected algorithm for %s: got %q, want %q", path, got, want)
+		}
+	}
+	if got := (HashPolicy{}).AlgoFor("doc.pdf"); got != "" {
+		t.Errorf("Unexpected algorithm for the default policy: got %q, want \"\"", got)
+	}
+
+	if _, err := ParseHashByExt(".mp4"); err == nil {
+		t.Errorf("Expected an error for an entry without algorithm")
+	}
+	if err := (HashPolicy{ByExt: map[string]string{".mp4": "crc7"}}).Validate(); err == nil {
+		t.Errorf("Expected an error for an unknown algorithm")
+	}
+}
+
+func TestCompareFilesHashAlgo(t *testing.T) {
+	files := []struct{ Path, Content string }{
+		{"movie.mp4", "frames"},
+		{"doc.txt", "words"},
+	}
+	refDir, err := createTestFiles(files)
+	if err != nil {
+		t.Fatalf("Failed to create reference files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+	targetDir, err := createTestFiles(files)
+	if err != nil {
+		t.Fatalf("Failed to create target files: %v", err)
+	}
+	defer removeTestFiles(targetDir)
+
+	policy := HashPolicy{ByExt: map[string]string{".mp4": "xxhash"}}
+	refDirInfo, err := WalkDirectoryWithOptions(refDir, WalkOptions{HashWorkers: 1, Hash: policy})
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	for _, file := range refDirInfo.Files {
+		if filepath.Ext(file.Path) == ".mp4" && (file.HashAlgo != "xxhash" || len(file.Hash) != 16) {
+			t.Errorf("Unexpected hash for %s: %s %s", file.Path, file.HashAlgo, file.Hash)
+		}
+	}
+
+	sameTarget, err := WalkDirectoryWithOptions(targetDir, WalkOptions{HashWorkers: 1, Hash: policy})
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+	if duplicates := CompareFiles(refDirInfo, sameTarget, true); len(duplicates) != 2 {
+		t.Errorf("Unexpected number of duplicates with the same policy: got %d, want 2", len(duplicates))
+	}
+
+	// the target hashed everything with SHA-256, so the video can no longer match
+	defaultTarget, err := WalkDirectory(targetDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+	if duplicates := CompareFiles(refDirInfo, defaultTarget, true); len(duplicates) != 1 {
+		t.Errorf("Unexpected number of duplicates across algorithms: got %d, want 1", len(duplicates))
+	}
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	names := HashAlgorithms()
+	if len(names) != len(hashAlgorithms) || !sort.StringsAreSorted(names) {
+		t.Errorf("Unexpected algorithm names: %v", names)
+	}
+	for _, name := range names {
+		hasher, err := newHasher(name)
+		if err != nil {
+			t.Errorf("Unexpected error for listed algorithm %s: %v", name, err)
+			continue
+		}
+		if err := ValidateHash(strings.Repeat("0", hasher.Size()*2), name); err != nil {
+			t.Errorf("Unexpected error validating a %s hash: %v", name, err)
+		}
+	}
+	if err := ValidateHash("00", "crc32"); err == nil {
+		t.Errorf("Expected an error for an unknown algorithm")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Hash modes of a HashSpec
+const (
+	// HashModeFull records a Hash of every whole file
+	HashModeFull = "full"
+	// HashModeEdge records only an EdgeHash over the first and last EdgeBlockSize bytes (WalkOptions.EdgeBlockSize)
+	HashModeEdge = "edge"
+	// HashModeNone records stat data only (WalkOptions.MetaOnly)
+	HashModeNone = "none"
+)
+
+// HashSpec records how the hashes of a manifest were computed. Hashes computed differently never match even
+// for identical files, so comparing such manifests would silently find fewer duplicates, or none.
+type HashSpec struct {
+	Mode string `yaml:"mode"`
+	// Algo is the default algorithm and ByExt its per-extension overrides, as in HashPolicy; "" means DefaultHashAlgo
+	Algo          string            `yaml:"algo,omitempty"`
+	ByExt         map[string]string `yaml:"byExt,omitempty"`
+	EdgeBlockSize int64             `yaml:"edgeBlockSize,omitempty"`
+	// Body is the header and footer left out of BodyHash, or nil if no BodyHash was recorded
+	Body *BodyRange `yaml:"body,omitempty"`
+}
+
+// hashSpec returns the HashSpec of the manifest a walk with opts produces
+func (opts WalkOptions) hashSpec() *HashSpec {
+	spec := &HashSpec{Mode: HashModeFull, Algo: opts.Hash.AlgoFor("")}
+	for ext, algo := range opts.Hash.ByExt {
+		if algo == DefaultHashAlgo {
+			algo = ""
+		}
+		if algo != spec.Algo {
+			if spec.ByExt == nil {
+				spec.ByExt = make(map[string]string)
+			}
+			spec.ByExt[ext] = algo
+		}
+	}
+	switch {
+	case opts.MetaOnly:
+		return &HashSpec{Mode: HashModeNone}
+	case opts.EdgeBlockSize > 0:
+		spec.Mode, spec.EdgeBlockSize = HashModeEdge, opts.EdgeBlockSize
+	case !opts.Body.IsZero():
+		body := opts.Body
+		spec.Body = &body
+	}
+	return spec
+}
+
+// algoFor returns the algorithm of files with extension ext, "" meaning DefaultHashAlgo
+func (s *HashSpec) algoFor(ext string) string {
+	if algo, ok := s.ByExt[ext]; ok {
+		return algo
+	}
+	return s.Algo
+}
+
+// CheckHashSpecs returns an error explaining why the hashes of ref and target cannot be compared under opts.
+// A nil spec, from a manifest written before specs were recorded, is assumed to be compatible.
+func CheckHashSpecs(ref, target *HashSpec, opts CompareOptions) error {
+	return checkHashSpecs("reference", ref, "target", target, opts)
+}
+
+// checkHashSpecs is CheckHashSpecs for two sides named a and b in its errors
+func checkHashSpecs(nameA string, a *HashSpec, nameB string, b *HashSpec, opts CompareOptions) error {
+	if a == nil || b == nil || opts.MetaOnly || opts.NameAndSize {
+		return nil
+	}
+
+	sides := []struct {
+		name string
+		spec *HashSpec
+	}{{nameA, a}, {nameB, b}}
+	for _, side := range sides {
+		switch {
+		case opts.UseEdgeHash && side.spec.Mode != HashModeEdge:
+			return fmt.Errorf("the %s has no edge hashes, it was hashed in %s mode", side.name, side.spec.Mode)
+		case opts.UseBodyHash && (side.spec.Mode != HashModeFull || side.spec.Body == nil):
+			return fmt.Errorf("the %s has no body hashes, it was hashed without a header or footer to skip", side.name)
+		case !opts.UseEdgeHash && !opts.UseBodyHash && side.spec.Mode != HashModeFull:
+			return fmt.Errorf("the %s has no full hashes, it was hashed in %s mode", side.name, side.spec.Mode)
+		}
+	}
+	if opts.UseEdgeHash && a.EdgeBlockSize != b.EdgeBlockSize {
+		return fmt.Errorf("edge hashes cover %d byte blocks in the %s but %d byte blocks in the %s", a.EdgeBlockSize, nameA, b.EdgeBlockSize, nameB)
+	}
+	if opts.UseBodyHash && *a.Body != *b.Body {
+		return fmt.Errorf("body hashes were made with %s for the %s but with %s for the %s", *a.Body, nameA, *b.Body, nameB)
+	}
+
+	if a.Algo != b.Algo {
+		return fmt.Errorf("the %s is hashed with %s but the %s with %s", nameA, algoName(a.Algo), nameB, algoName(b.Algo))
+	}
+	exts := make(map[string]bool)
+	for ext := range a.ByExt {
+		exts[ext] = true
+	}
+	for ext := range b.ByExt {
+		exts[ext] = true
+	}
+	sorted := make([]string, 0, len(exts))
+	for ext := range exts {
+		sorted = append(sorted, ext)
+	}
+	sort.Strings(sorted)
+	for _, ext := range sorted {
+		if algoA, algoB := a.algoFor(ext), b.algoFor(ext); algoA != algoB {
+			return fmt.Errorf("%s files of the %s are hashed with %s but those of the %s with %s", ext, nameA, algoName(algoA), nameB, algoName(algoB))
+		}
+	}
+	return nil
+}
+
+// CheckMergeableHashSpecs returns an error if manifests record different HashSpecs, since merging them would
+// mix hashes that cannot be compared. Manifests without a HashSpec are assumed to agree.
+func CheckMergeableHashSpecs(manifests []*DirectoryInfo) error {
+	var first *DirectoryInfo
+	for _, manifest := range manifests {
+		if manifest.HashSpec == nil {
+			continue
+		}
+		if first == nil {
+			first = manifest
+			continue
+		}
+		if reflect.DeepEqual(first.HashSpec, manifest.HashSpec) {
+			continue
+		}
+		nameA, nameB := "manifest of "+first.BaseDir, "manifest of "+manifest.BaseDir
+		opts := CompareOptions{UseEdgeHash: first.HashSpec.Mode == HashModeEdge, UseBodyHash: first.HashSpec.Body != nil}
+		if err := checkHashSpecs(nameA, first.HashSpec, nameB, manifest.HashSpec, opts); err != nil {
+			return err
+		}
+		return fmt.Errorf("the %s and the %s are hashed differently", nameA, nameB)
+	}
+	return nil
+}
+
+// algoName returns the name of algo, where "" means DefaultHashAlgo
+func algoName(algo string) string {
+	if algo == "" {
+		return DefaultHashAlgo
+	}
+	return algo
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestWalkHashSpec(t *testing.T) {
+	for _, test := range []struct {
+		opts WalkOptions
+		want HashSpec
+	}{
+		{WalkOptions{}, HashSpec{Mode: HashModeFull}},
+		{WalkOptions{Hash: HashPolicy{Default: "sha256", ByExt: map[string]string{".mp4": "xxhash", ".txt": "sha256"}}}, HashSpec{Mode: HashModeFull, ByExt: map[string]string{".mp4": "xxhash"}}},
+		{WalkOptions{Hash: HashPolicy{Default: "md5"}, EdgeBlockSize: 512}, HashSpec{Mode: HashModeEdge, Algo: "md5", EdgeBlockSize: 512}},
+		{WalkOptions{Body: BodyRange{SkipHeadLines: 2}}, HashSpec{Mode: HashModeFull, Body: &BodyRange{SkipHeadLines: 2}}},
+		{WalkOptions{MetaOnly: true, Hash: HashPolicy{Default: "md5"}}, HashSpec{Mode: HashModeNone}},
+	} {
+		if got := test.opts.hashSpec(); !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("Unexpected spec for %+v: got %+v, want %+v", test.opts, *got, test.want)
+		}
+	}
+}
+
+func TestCheckHashSpecs(t *testing.T) {
+	full := &HashSpec{Mode: HashModeFull}
+	for _, test := range []struct {
+		name        string
+		ref, target *HashSpec
+		opts        CompareOptions
+		wantErr     string
+	}{
+		{"same", full, &HashSpec{Mode: HashModeFull}, CompareOptions{}, ""},
+		{"unknown", nil, &HashSpec{Mode: HashModeEdge, EdgeBlockSize: 4096}, CompareOptions{}, ""},
+		{"algorithm", full, &HashSpec{Mode: HashModeFull, Algo: "xxhash"}, CompareOptions{}, "reference is hashed with sha256 but the target with xxhash"},
+		{"extension", &HashSpec{Mode: HashModeFull, ByExt: map[string]string{".mp4": "xxhash"}}, full, CompareOptions{}, ".mp4 files of the reference are hashed with xxhash"},
+		{"same extension", &HashSpec{Mode: HashModeFull, ByExt: map[string]string{".mp4": "xxhash"}}, &HashSpec{Mode: HashModeFull, ByExt: map[string]string{".mp4": "xxhash"}}, CompareOptions{}, ""},
+		{"edge against full", &HashSpec{Mode: HashModeEdge, EdgeBlockSize: 4096}, full, CompareOptions{}, "reference has no full hashes"},
+		{"full against edge", full, &HashSpec{Mode: HashModeEdge, EdgeBlockSize: 4096}, CompareOptions{UseEdgeHash: true}, "reference has no edge hashes"},
+		{"edge block sizes", &HashSpec{Mode: HashModeEdge, EdgeBlockSize: 4096}, &HashSpec{Mode: HashModeEdge, EdgeBlockSize: 512}, CompareOptions{UseEdgeHash: true}, "4096 byte blocks in the reference but 512"},
+		{"stat only", &HashSpec{Mode: HashModeNone}, full, CompareOptions{MetaOnly: true}, ""},
+		{"missing body", full, &HashSpec{Mode: HashModeFull, Body: &BodyRange{SkipHeadLines: 1}}, CompareOptions{UseBodyHash: true}, "reference has no body hashes"},
+		{"body ranges", &HashSpec{Mode: HashModeFull, Body: &BodyRange{SkipHeadLines: 1}}, &HashSpec{Mode: HashModeFull, Body: &BodyRange{SkipHeadLines: 2}}, CompareOptions{UseBodyHash: true}, "-skipHeadLines 1 for the reference but with -skipHeadLines 2"},
+		{"body ranges unused", &HashSpec{Mode: HashModeFull, Body: &BodyRange{SkipHeadLines: 1}}, full, CompareOptions{}, ""},
+	} {
+		err := CheckHashSpecs(test.ref, test.target, test.opts)
+		if test.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("%s: unexpected error: got %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestHashSpecRecorded(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{{"a.txt", "header\nbody\n"}})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	var manifest bytes.Buffer
+	walkOpts := WalkOptions{YamlOutput: &manifest, Hash: HashPolicy{Default: "xxhash"}, Body: BodyRange{SkipHeadLines: 1}}
+	dirInfo, err := WalkDirectoryWithOptions(testDir, walkOpts)
+	if err != nil {
+		t.Fatalf("Error walking: %v", err)
+	}
+	want := walkOpts.hashSpec()
+	if !reflect.DeepEqual(dirInfo.HashSpec, want) {
+		t.Errorf("Unexpected walk spec: got %+v, want %+v", dirInfo.HashSpec, want)
+	}
+
+	var streamed DirectoryInfo
+	if err := yaml.Unmarshal(manifest.Bytes(), &streamed); err != nil {
+		t.Fatalf("Error parsing streamed manifest: %v", err)
+	}
+	if !reflect.DeepEqual(streamed.HashSpec, want) || len(streamed.Files) != 1 {
+		t.Errorf("Unexpected streamed manifest: got %+v, want spec %+v", streamed, want)
+	}
+
+	indexPath := filepath.Join(testDir, "ref.idx")
+	if err := WriteIndex(indexPath, dirInfo); err != nil {
+		t.Fatalf("Error writing index: %v", err)
+	}
+	loaded, err := ReadIndex(indexPath)
+	if err != nil {
+		t.Fatalf("Error reading index: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.HashSpec, want) {
+		t.Errorf("Unexpected index spec: got %+v, want %+v", loaded.HashSpec, want)
+	}
+
+	if _, err := ValidateDirectoryStream(dirInfo, testDir, WalkOptions{}, func(ValidationEvent) {}); err == nil || !strings.Contains(err.Error(), "manifest is hashed with xxhash but the directory with sha256") {
+		t.Errorf("Unexpected error validating with another algorithm: %v", err)
+	}
+}
+
+func TestCheckMergeableHashSpecs(t *testing.T) {
+	full := &DirectoryInfo{BaseDir: "/a", HashSpec: &HashSpec{Mode: HashModeFull}}
+	unknown := &DirectoryInfo{BaseDir: "/b"}
+	if err := CheckMergeableHashSpecs([]*DirectoryInfo{unknown, full, {BaseDir: "/c", HashSpec: &HashSpec{Mode: HashModeFull}}}); err != nil {
+		t.Errorf("Unexpected error merging alike manifests: %v", err)
+	}
+	xxhash := &DirectoryInfo{BaseDir: "/d", HashSpec: &HashSpec{Mode: HashModeFull, Algo: "xxhash"}}
+	if err := CheckMergeableHashSpecs([]*DirectoryInfo{full, unknown, xxhash}); err == nil || !strings.Contains(err.Error(), "manifest of /a is hashed with sha256 but the manifest of /d with xxhash") {
+		t.Errorf("Unexpected error merging manifests of different algorithms: %v", err)
+	}
+	body := &DirectoryInfo{BaseDir: "/e", HashSpec: &HashSpec{Mode: HashModeFull, Body: &BodyRange{SkipTailBytes: 8}}}
+	if err := CheckMergeableHashSpecs([]*DirectoryInfo{full, body}); err == nil {
+		t.Error("Unexpected success merging manifests with and without body hashes")
+	}
+
+	merged, _ := MergeManifests([]*DirectoryInfo{unknown, full})
+	if !reflect.DeepEqual(merged.HashSpec, full.HashSpec) {
+		t.Errorf("Unexpected merged spec: got %+v, want %+v", merged.HashSpec, full.HashSpec)
+	}
+}
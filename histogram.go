@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SizeBucket counts the files whose size is at least Min and below Max
+type SizeBucket struct {
+	Min int64
+	// Max is 0 for the last bucket, which has no upper bound
+	Max   int64
+	Files int
+	Bytes int64
+}
+
+// SizeHistogram sorts files into buckets by size. bounds are the ascending sizes at which a new bucket starts;
+// without them the buckets double in size from 1 byte (empty files, 1 B, 2-3 B, 4-7 B, ...) and the empty
+// buckets below the smallest and above the largest file are left out. No files give no buckets.
+func SizeHistogram(files []FileInfo, bounds []int64) []SizeBucket {
+	if len(files) == 0 {
+		return nil
+	}
+	logScale := len(bounds) == 0
+	if logScale {
+		for bound := int64(1); bound > 0; bound *= 2 {
+			bounds = append(bounds, bound)
+		}
+	}
+
+	buckets := make([]SizeBucket, len(bounds)+1)
+	for i := range buckets {
+		if i > 0 {
+			buckets[i].Min = bounds[i-1]
+		}
+		if i < len(bounds) {
+			buckets[i].Max = bounds[i]
+		}
+	}
+	for _, file := range files {
+		// the bounds are few, a linear search is fine
+		i := 0
+		for i < len(bounds) && file.Size >= bounds[i] {
+			i++
+		}
+		buckets[i].Files++
+		buckets[i].Bytes += file.Size
+	}
+
+	if logScale {
+		first, last := 0, len(buckets)-1
+		for first < last && buckets[first].Files == 0 {
+			first++
+		}
+		for last > first && buckets[last].Files == 0 {
+			last--
+		}
+		buckets = buckets[first : last+1]
+	}
+	return buckets
+}
+
+// ParseSizeBounds parses ascending bucket bounds like "4K,1M,100M,1G", where K, M, G and T are powers of 1024
+func ParseSizeBounds(spec string) ([]int64, error) {
+	var bounds []int64
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		raw, multiplier := field, int64(1)
+		if i := strings.IndexAny(field, "KMGTkmgt"); i >= 0 && i == len(field)-1 {
+			multiplier = int64(1) << (10 * (strings.IndexByte("KMGT", strings.ToUpper(field)[i]) + 1))
+			field = field[:i]
+		}
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("expected a positive size like 4K or 1048576, got %q", raw)
+		}
+		bound := n * multiplier
+		if bound/multiplier != n {
+			return nil, fmt.Errorf("size %q is too large", raw)
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("sizes must be ascending, got %d after %d", bound, bounds[len(bounds)-1])
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
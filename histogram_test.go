@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	files := []FileInfo{{Size: 0}, {Size: 5}, {Size: 6}, {Size: 1000}, {Size: 5000}}
+
+	want := []SizeBucket{
+		{Min: 0, Max: 1024, Files: 4, Bytes: 1011},
+		{Min: 1024, Max: 1 << 20, Files: 1, Bytes: 5000},
+		{Min: 1 << 20, Files: 0},
+	}
+	if got := SizeHistogram(files, []int64{1024, 1 << 20}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected buckets: got %+v, want %+v", got, want)
+	}
+
+	// log scale leaves out the empty buckets at both ends, but not between files
+	logBuckets := SizeHistogram(files, nil)
+	if first := logBuckets[0]; first.Min != 0 || first.Max != 1 || first.Files != 1 {
+		t.Errorf("Unexpected first bucket: %+v", first)
+	}
+	if last := logBuckets[len(logBuckets)-1]; last.Min != 4096 || last.Max != 8192 || last.Files != 1 {
+		t.Errorf("Unexpected last bucket: %+v", last)
+	}
+	counted, total := 0, 0
+	for _, bucket := range logBuckets {
+		counted += bucket.Files
+		total++
+	}
+	if counted != 5 || total != 14 {
+		t.Errorf("Unexpected log scale buckets: %d files in %d buckets, want 5 in 14", counted, total)
+	}
+
+	for _, bounds := range [][]int64{nil, {1024}} {
+		if got := SizeHistogram(nil, bounds); len(got) != 0 {
+			t.Errorf("Unexpected buckets without files and bounds %v: %+v", bounds, got)
+		}
+	}
+}
+
+func TestParseSizeBounds(t *testing.T) {
+	bounds, err := ParseSizeBounds("512, 4K,1m,2G")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := []int64{512, 4096, 1 << 20, 2 << 30}; !reflect.DeepEqual(bounds, want) {
+		t.Errorf("Unexpected bounds: got %v, want %v", bounds, want)
+	}
+	for _, spec := range []string{"4K,1K", "0", "-1", "4X", "K", "9000000000T"} {
+		if _, err := ParseSizeBounds(spec); err == nil {
+			t.Errorf("Unexpected success parsing %q", spec)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import "sync"
+
+// Summary is passed to Hooks.OnComplete when a walk or comparison finishes
+type Summary struct {
+	Files      int   `json:"files"`
+	Bytes      int64 `json:"bytes"`
+	Duplicates int   `json:"duplicates"`
+	// DuplicateBytes is the space deleting the duplicates frees, which counts hardlinks once (see FreedBytes)
+	DuplicateBytes int64 `json:"duplicateBytes"`
+	// HashWorkers and WalkWorkers are the concurrency a walk ran with
+	HashWorkers int `json:"hashWorkers,omitempty"`
+	WalkWorkers int `json:"walkWorkers,omitempty"`
+	// Busy are the files a walk with WalkOptions.SkipBusy left out because another process held them
+	Busy []string `json:"busy,omitempty"`
+}
+
+// Hooks lets library consumers react to scan events without parsing stdout. All fields are optional.
+//
+// Concurrency contract: WalkDirectoryWithOptions calls hooks from its worker goroutines, but calls
+// through the same Hooks value are serialized, so a hook never runs concurrently with another hook
+// of the same Hooks value. Hooks run on the hot path and should return quickly; a blocking hook
+// stalls the scan. A Hooks value must not be copied after first use.
+type Hooks struct {
+	OnFileHashed     func(file FileInfo)
+	OnDuplicateFound func(file FileInfo, refPath string)
+	OnError          func(path string, err error)
+	OnComplete       func(summary Summary)
+
+	mu sync.Mutex
+}
+
+// clone returns new Hooks with the callbacks of h, which may be nil, for wrapping some of them without
+// touching h
+func (h *Hooks) clone() *Hooks {
+	if h == nil {
+		return &Hooks{}
+	}
+	return &Hooks{OnFileHashed: h.OnFileHashed, OnDuplicateFound: h.OnDuplicateFound, OnError: h.OnError, OnComplete: h.OnComplete}
+}
+
+func (h *Hooks) fileHashed(file FileInfo) {
+	if h == nil || h.OnFileHashed == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.OnFileHashed(file)
+}
+
+func (h *Hooks) duplicateFound(file FileInfo, refPath string) {
+	if h == nil || h.OnDuplicateFound == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.OnDuplicateFound(file, refPath)
+}
+
+func (h *Hooks) error(path string, err error) {
+	if h == nil || h.OnError == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.OnError(path, err)
+}
+
+func (h *Hooks) complete(summary Summary) {
+	if h == nil || h.OnComplete == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.OnComplete(summary)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	refDir, targetDir, err := createExactTestFiles()
+	if err != nil {
+		t.Fatalf("Failed to create exact test files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+	defer removeTestFiles(targetDir)
+
+	hashed := 0
+	var walkSummary Summary
+	walkHooks := &Hooks{
+		OnFileHashed: func(file FileInfo) { hashed++ },
+		OnComplete:   func(summary Summary) { walkSummary = summary },
+	}
+	refDirInfo, err := WalkDirectoryWithOptions(refDir, WalkOptions{HashWorkers: 4, Hooks: walkHooks})
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	if hashed != 4 || walkSummary.Files != 4 {
+		t.Errorf("Unexpected walk events: %d hashed, summary %+v", hashed, walkSummary)
+	}
+
+	targetDirInfo, err := WalkDirectory(targetDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+
+	found := make(map[string]string)
+	var compareSummary Summary
+	compareHooks := &Hooks{
+		OnDuplicateFound: func(file FileInfo, refPath string) { found[file.Path] = refPath },
+		OnComplete:       func(summary Summary) { compareSummary = summary },
+	}
+	CompareFilesWithOptions(refDirInfo, targetDirInfo, CompareOptions{ExactPathMatch: true, Hooks: compareHooks})
+	if compareSummary.Duplicates != 3 || len(found) != 3 {
+		t.Errorf("Unexpected compare events: %v, summary %+v", found, compareSummary)
+	}
+	if refPath := found[filepath.Join(targetDir, "subdir/file3.txt")]; refPath != filepath.Join(refDir, "subdir/file3.txt") {
+		t.Errorf("Unexpected reference path for subdir/file3.txt: %s", refPath)
+	}
+}
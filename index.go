@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+)
+
+// indexVersion is bumped whenever the index layout changes incompatibly
+const indexVersion = 1
+
+// index is the on-disk form of a binary reference index. Unlike the YAML manifest it is not meant
+// to be edited by hand; it exists because gob decodes an order of magnitude faster than yaml.v2.
+type index struct {
+	Version  int
+	BaseDir  string
+	HashSpec *HashSpec
+	Files    []FileInfo
+}
+
+// WriteIndex writes dirInfo to path in the binary index format, zstd compressed if path ends in .zst
+func WriteIndex(path string, dirInfo *DirectoryInfo) error {
+	return WriteIndexWithLevel(path, dirInfo, DefaultCompressLevel)
+}
+
+// WriteIndexWithLevel is like WriteIndex, compressing .zst indexes at the given zstd level
+func WriteIndexWithLevel(path string, dirInfo *DirectoryInfo, level int) error {
+	w, err := createManifest(path, level)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(w).Encode(index{Version: indexVersion, BaseDir: dirInfo.BaseDir, HashSpec: dirInfo.HashSpec, Files: dirInfo.Files}); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ReadIndex reads a binary index written by WriteIndex
+func ReadIndex(path string) (*DirectoryInfo, error) {
+	r, err := openManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var idx index
+	if err := gob.NewDecoder(bufio.NewReader(r)).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("reading index %s: %w", path, err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("index %s has version %d, expected %d", path, idx.Version, indexVersion)
+	}
+	dirInfo := &DirectoryInfo{BaseDir: idx.BaseDir, HashSpec: idx.HashSpec, Files: idx.Files}
+	if err := CanonicalizePaths(dirInfo, false); err != nil {
+		return nil, err
+	}
+	return dirInfo, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "indexdir")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	dirInfo := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/a.txt", Hash: "abc", Size: 3, ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Path: "/ref/b.txt", Hash: "def"},
+	}}
+	indexPath := filepath.Join(testDir, "ref.idx")
+	if err := WriteIndex(indexPath, dirInfo); err != nil {
+		t.Fatalf("Error writing index: %v", err)
+	}
+
+	loaded, err := ReadIndex(indexPath)
+	if err != nil {
+		t.Fatalf("Error reading index: %v", err)
+	}
+	if loaded.BaseDir != dirInfo.BaseDir || len(loaded.Files) != len(dirInfo.Files) {
+		t.Fatalf("Unexpected index contents: %+v", loaded)
+	}
+	for i, file := range loaded.Files {
+		want := dirInfo.Files[i]
+		if file.Path != want.Path || file.Hash != want.Hash || file.Size != want.Size || !file.ModTime.Equal(want.ModTime) {
+			t.Errorf("Unexpected file %d: got %+v, want %+v", i, file, want)
+		}
+	}
+
+	if _, err := ReadIndex(filepath.Join(testDir, "missing.idx")); err == nil {
+		t.Errorf("Expected an error reading a missing index")
+	}
+}
+
+// benchmarkManifestEntries is the size of the reference set used to compare index and YAML load times. A million
+// entries would be closer to real reference sets, but marshalling them to YAML alone takes several GB.
+const benchmarkManifestEntries = 100000
+
+func writeBenchmarkManifests(b *testing.B) (string, string, func()) {
+	b.Helper()
+	testDir, err := os.MkdirTemp("", "indexbench")
+	if err != nil {
+		b.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	dirInfo := &DirectoryInfo{BaseDir: "/ref", Files: make([]FileInfo, benchmarkManifestEntries)}
+	for i := range dirInfo.Files {
+		dirInfo.Files[i] = FileInfo{
+			Path:    fmt.Sprintf("/ref/dir%d/file%d.txt", i%1000, i),
+			Hash:    fmt.Sprintf("%064x", i),
+			Size:    int64(i),
+			ModTime: time.Unix(int64(i), 0).UTC(),
+		}
+	}
+
+	indexPath := filepath.Join(testDir, "ref.idx")
+	if err := WriteIndex(indexPath, dirInfo); err != nil {
+		b.Fatalf("Error writing index: %v", err)
+	}
+	yamlPath := filepath.Join(testDir, "ref.yml")
+	data, err := yaml.Marshal(dirInfo)
+	if err != nil {
+		b.Fatalf("Error marshalling YAML: %v", err)
+	}
+	if err := os.WriteFile(yamlPath, data, 0644); err != nil {
+		b.Fatalf("Error writing YAML: %v", err)
+	}
+	return indexPath, yamlPath, func() { os.RemoveAll(testDir) }
+}
+
+func BenchmarkLoadManifest(b *testing.B) {
+	indexPath, yamlPath, cleanup := writeBenchmarkManifests(b)
+	defer cleanup()
+
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ReadIndex(indexPath); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("yaml", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := readDirectoryInfoFromYAML(yamlPath); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+)
+
+// MaxInlineContent caps WalkOptions.InlineContentBelow so that manifests stay a reasonable size
+const MaxInlineContent = 64 * 1024
+
+// InlineContent reads the whole file into Content, base64 encoded, and sets Hash (and BodyHash for a non-zero
+// body) from the same bytes. It is meant for tiny files, where keeping the content costs about as much as a hash.
+// It never reads more than MaxInlineContent bytes into memory: a file that has grown beyond that since it was
+// listed is hashed as a stream and gets no Content.
+func (f *FileInfo) InlineContent(body BodyRange) error {
+	file, err := os.Open(longPath(f.Path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxInlineContent+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxInlineContent {
+		rest := io.MultiReader(bytes.NewReader(data), file)
+		if body.IsZero() {
+			f.Hash, err = HashReaderWith(rest, f.HashAlgo)
+			return err
+		}
+		return f.hashesFrom(rest, body)
+	}
+	if f.Hash, err = HashReaderWith(bytes.NewReader(data), f.HashAlgo); err != nil {
+		return err
+	}
+	if !body.IsZero() {
+		if f.BodyHash, err = HashReaderWith(NewBodyReader(bytes.NewReader(data), body), f.HashAlgo); err != nil {
+			return err
+		}
+	}
+	f.Content = base64.StdEncoding.EncodeToString(data)
+	return nil
+}
+
+// validateInlineThreshold checks a WalkOptions.InlineContentBelow value
+func validateInlineThreshold(threshold int64) error {
+	if threshold < 0 || threshold > MaxInlineContent {
+		return fmt.Errorf("inline content threshold must be between 0 and %d bytes, got %d", MaxInlineContent, threshold)
+	}
+	return nil
+}
+
+// inlineContentMatches reports whether two files may be duplicates as far as their inline content goes:
+// if both carry it, it must be equal, which rules out hash collisions for small files
+func inlineContentMatches(a, b FileInfo) bool {
+	return a.Content == "" || b.Content == "" || a.Content == b.Content
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkDirectoryInlineContent(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"tiny.txt", "tiny"},
+		{"large.txt", "larger than the threshold"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	hashed, err := WalkDirectory(testDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	inlined, err := WalkDirectoryWithOptions(testDir, WalkOptions{HashWorkers: 1, InlineContentBelow: 10})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	hashes := make(map[string]string)
+	for _, file := range hashed.Files {
+		hashes[file.Path] = file.Hash
+	}
+	for _, file := range inlined.Files {
+		if file.Hash != hashes[file.Path] {
+			t.Errorf("Unexpected hash for %s: got %s, want %s", file.Path, file.Hash, hashes[file.Path])
+		}
+		wantContent := ""
+		if filepath.Base(file.Path) == "tiny.txt" {
+			wantContent = base64.StdEncoding.EncodeToString([]byte("tiny"))
+		}
+		if file.Content != wantContent {
+			t.Errorf("Unexpected inline content for %s: got %q, want %q", file.Path, file.Content, wantContent)
+		}
+	}
+
+	// inlined and hashed-only scans still match each other
+	if duplicates := CompareFiles(inlined, hashed, true); len(duplicates) != 2 {
+		t.Errorf("Unexpected number of duplicates between inlined and hashed scans: got %d, want 2", len(duplicates))
+	}
+
+	if _, err := WalkDirectoryWithOptions(testDir, WalkOptions{HashWorkers: 1, InlineContentBelow: MaxInlineContent + 1}); err == nil {
+		t.Errorf("Expected an error for an inline threshold above %d", MaxInlineContent)
+	}
+}
+
+func TestCompareFilesInlineContent(t *testing.T) {
+	// equal hashes with different inline content, as a hash collision would have
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{{Path: "/ref/a", Hash: "abc", Content: "YQ=="}}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{
+		{Path: "/target/a", Hash: "abc", Content: "Yg=="},
+	}}
+	if duplicates := CompareFiles(refDir, targetDir, true); len(duplicates) != 0 {
+		t.Errorf("Unexpected duplicates with differing inline content: %v", duplicates)
+	}
+	if explanation := ExplainMatch(refDir, targetDir, "/target/a", CompareOptions{ExactPathMatch: true}); explanation.Duplicate {
+		t.Errorf("Unexpected explanation verdict with differing inline content: %+v", explanation)
+	}
+
+	targetDir.Files[0].Content = "YQ=="
+	if duplicates := CompareFiles(refDir, targetDir, true); len(duplicates) != 1 {
+		t.Errorf("Unexpected number of duplicates with equal inline content: got %d, want 1", len(duplicates))
+	}
+}
+
+func TestInlineContentGrownFile(t *testing.T) {
+	testDir, err := createTestFiles(nil)
+	if err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	const size = 256 << 20
+	path := createSparseFile(t, testDir, "grown.bin", size)
+	plain := FileInfo{Path: path, HashAlgo: "xxhash"}
+	if err := plain.CalculateHash(); err != nil {
+		t.Fatalf("Error hashing %s: %v", path, err)
+	}
+
+	// listed while it was tiny, and grown since
+	for _, body := range []BodyRange{{}, {SkipHeadBytes: 10}} {
+		file := FileInfo{Path: path, Size: 10, HashAlgo: "xxhash"}
+		allocated := allocatedBy(func() { err = file.InlineContent(body) })
+		if err != nil {
+			t.Fatalf("Error inlining %s: %v", path, err)
+		}
+		if allocated > 4*MaxInlineContent+1<<20 {
+			t.Errorf("Allocated %d bytes inlining a %d byte file", allocated, size)
+		}
+		if file.Content != "" || file.Hash != plain.Hash {
+			t.Errorf("Unexpected result for a grown file: content of %d characters, hash %s, want %s", len(file.Content), file.Hash, plain.Hash)
+		}
+		if !body.IsZero() && file.BodyHash == "" {
+			t.Errorf("Expected a body hash for a grown file")
+		}
+	}
+}
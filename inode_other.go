@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileIdentity is not supported on this platform
+func fileIdentity(info os.FileInfo) (device, inode, links uint64, ok bool) {
+	return 0, 0, 0, false
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode number of info, which identify the file behind hardlinks, and
+// how many hardlinks the file has
+func fileIdentity(info os.FileInfo) (device, inode, links uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), uint64(stat.Nlink), true
+}
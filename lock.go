@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned by AcquireDirLock when another process holds the lock
+type ErrLocked struct {
+	Dir string
+	PID int
+	// Path is the lock file, set where a lock left behind by a crashed run cannot be told apart, so that the
+	// user can remove it
+	Path string
+}
+
+func (e *ErrLocked) Error() string {
+	msg := fmt.Sprintf("%s is locked by another deduplicator run", e.Dir)
+	if e.PID > 0 {
+		msg = fmt.Sprintf("%s (pid %d)", msg, e.PID)
+	}
+	if e.Path != "" {
+		msg += fmt.Sprintf("; if that run is no longer going, remove %s", e.Path)
+	}
+	return msg
+}
+
+// DirLock is an advisory lock keyed to a directory, held while destructive operations run on it
+type DirLock struct {
+	file *os.File
+	path string
+}
+
+// lockFilePath returns the lock file location for dir. The lock lives in the temp directory,
+// keyed by the absolute path, so it never shows up in the scanned tree itself.
+func lockFilePath(dir string) string {
+	sum := sha256.Sum256([]byte(absOrClean(dir)))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("deduplicator-%x.lock", sum[:8]))
+}
+
+// AcquireDirLock takes the advisory lock for dir. If another process holds it, it returns an *ErrLocked,
+// or blocks until the lock is released when wait is true. Locks left behind by crashed processes are
+// detected and taken over, except on platforms other than Unix and Windows, where the error names the lock file.
+func AcquireDirLock(dir string, wait bool) (*DirLock, error) {
+	path := lockFilePath(dir)
+	file, err := lockFile(path, wait)
+	if err == errLockBusy {
+		locked := &ErrLocked{Dir: dir, PID: readLockPID(path)}
+		if !staleLocksDetected {
+			locked.Path = path
+		}
+		return nil, locked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Truncate(0); err == nil {
+		fmt.Fprintf(file, "%d\n", os.Getpid())
+	}
+	return &DirLock{file: file, path: path}, nil
+}
+
+// Release unlocks the directory
+func (l *DirLock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file, l.path)
+	l.file = nil
+	return err
+}
+
+func readLockPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
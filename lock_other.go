@@ -0,0 +1,41 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var errLockBusy = errors.New("lock is busy")
+
+// lockFile creates path exclusively. Without flock, a lock file whose recorded process
+// no longer exists is considered stale and taken over, where processAlive can tell.
+func lockFile(path string, wait bool) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return file, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if pid := readLockPID(path); pid > 0 {
+			if alive, known := processAlive(pid); known && !alive {
+				os.Remove(path)
+				continue
+			}
+		}
+		if !wait {
+			return nil, errLockBusy
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func unlockFile(file *os.File, path string) error {
+	err := file.Close()
+	os.Remove(path)
+	return err
+}
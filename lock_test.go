@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestAcquireDirLock(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "lockdir")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	lock, err := AcquireDirLock(testDir, false)
+	if err != nil {
+		t.Fatalf("Error acquiring lock: %v", err)
+	}
+
+	_, err = AcquireDirLock(testDir, false)
+	var lockedErr *ErrLocked
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("Expected ErrLocked while the lock is held, got %v", err)
+	}
+	if lockedErr.PID != os.Getpid() {
+		t.Errorf("Unexpected lock holder pid: got %d, want %d", lockedErr.PID, os.Getpid())
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Error releasing lock: %v", err)
+	}
+	lock, err = AcquireDirLock(testDir, false)
+	if err != nil {
+		t.Fatalf("Error reacquiring released lock: %v", err)
+	}
+	lock.Release()
+}
+
+func TestErrLockedNamesStaleLock(t *testing.T) {
+	err := &ErrLocked{Dir: "/data", PID: 42, Path: "/tmp/deduplicator-0123456789abcdef.lock"}
+	want := "/data is locked by another deduplicator run (pid 42); if that run is no longer going, remove /tmp/deduplicator-0123456789abcdef.lock"
+	if err.Error() != want {
+		t.Errorf("Unexpected error: got %q, want %q", err.Error(), want)
+	}
+}
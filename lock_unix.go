@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errLockBusy = errors.New("lock is busy")
+
+// staleLocksDetected tells whether lockFile takes over the lock of a process that no longer runs
+const staleLocksDetected = true
+
+// lockFile opens path and takes an exclusive flock on it. The kernel drops flocks when
+// their holder exits, so a lock file left behind by a crashed run is simply reused.
+func lockFile(path string, wait bool) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err = syscall.Flock(int(file.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return nil, errLockBusy
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// unlockFile leaves the lock file in place: removing it could let two runs lock different inodes
+func unlockFile(file *os.File, path string) error {
+	defer file.Close()
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxShortPath is the MAX_PATH limit beyond which Windows needs the extended-length prefix
+const maxShortPath = 260
+
+// longPath returns path in the \\?\ extended-length form when it is too long for the classic Windows APIs.
+// The os package only does this for absolute paths, so relative paths from a deep walk would still fail.
+func longPath(path string) string {
+	if len(path) < maxShortPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(absPath, `\\`) {
+		// UNC path: \\server\share\... becomes \\?\UNC\server\share\...
+		return `\\?\UNC\` + absPath[2:]
+	}
+	return `\\?\` + absPath
+}
@@ -1,162 +1,2186 @@
 package main
 
 import (
-	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
-func main() {
+// stringList is a flag.Value collecting every occurrence of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// options holds the command line flags
+type options struct {
+	Mode                 string
+	RefDir               string
+	RefFile              string
+	CompareTreesEqual    bool
+	SizeHistogram        bool
+	HistogramBuckets     []int64
+	NameCollisions       bool
+	PrintSchema          string
+	TargetDir            string
+	RefYaml              string
+	RefYamls             stringList
+	MergeReports         stringList
+	MatchPolicy          MatchPolicy
+	SortBy               SortKey
+	ManifestOut          string
+	CompressLevel        int
+	RefIndex             string
+	DumpIndex            string
+	WriteIndex           string
+	TargetYaml           string
+	HashWorkers          int
+	MaxHashPerDevice     int
+	WalkWorkers          int
+	ExactPathMatch       bool
+	NormalizeRefPath     PathNormalizer
+	NormalizeTargetPath  PathNormalizer
+	FollowSymlinksRef    bool
+	FollowSymlinksTarget bool
+	ResolveSymlinks      bool
+	ExcludeSameDir       bool
+	DeleteFiles          bool
+	ConsolidateTo        string
+	PruneEmptyDirs       bool
+	PruneAllEmptyDirs    bool
+	DeleteBatchSize      int
+	DeletePause          time.Duration
+	AfterDelete          string
+	AfterDeletePerBatch  bool
+	AfterDeleteFailFast  bool
+	RehashOnMatch        bool
+	PreserveHardlinks    bool
+	NoLock               bool
+	WaitLock             bool
+	SummaryOnly          bool
+	MaxReported          int
+	TimeWindow           time.Duration
+	RequireModTimeMatch  bool
+	ModTimeTolerance     time.Duration
+	EmptyFilesSamePath   bool
+	ShowConflicts        bool
+	ShowRenames          bool
+	FindTruncated        bool
+	ReportDelta          string
+	TruncatedMinSize     int64
+	AudioFingerprint     bool
+	TopByCount           int
+	AudioSimilarity      float64
+	Fpcalc               string
+	CompareXattrs        bool
+	Body                 BodyRange
+	KeepPatterns         stringList
+	DeletePatterns       stringList
+	PreferFewerLinks     bool
+	SampleRate           float64
+	Seed                 int64
+	ScriptOut            string
+	GroupOutput          string
+	Outputs              stringList
+	Preview              bool
+	PreviewBytes         int
+	MetaOnly             bool
+	ManifestsOnly        bool
+	ResultCache          string
+	EdgesOnly            bool
+	NameAndSize          bool
+	EdgeBlockSize        int64
+	Confirm              bool
+	CompareSizesOnly     bool
+	Hash                 HashPolicy
+	ListHashAlgos        bool
+	InlineContentBelow   int64
+	WarnSpecialFiles     bool
+	Explain              string
+	Paranoid             bool
+	SI                   bool
+	CPUProfile           string
+	MemProfile           string
+	VerifyManifestPaths  bool
+	StrictManifest       bool
+	StrictHashLength     bool
+	ErrorLog             string
+	TransactionLog       string
+	Owner                OwnerFilter
+	CaptureOwner         bool
+	SkipBusy             bool
+	StatsFd              int
+	StatsSocket          string
+	StatsInterval        time.Duration
+
+	// normalizeSpecs are the -normalizeRefPaths and -normalizeTargetPaths given, for -dedupResultCache
+	normalizeSpecs string
+	// errorLog receives the walk errors if ErrorLog is set
+	errorLog *ErrorLog
+	// scanStats counts the walks' progress if StatsFd or StatsSocket is set
+	scanStats *ScanStats
+	// busyFiles collects the files left alone under SkipBusy
+	busyFiles []string
+	// walkErrors collects the paths the walks skipped with -errorLog, for the -output reports
+	walkErrors []ReportError
+}
+
+func parseFlags() *options {
+	opts := &options{}
+
 	// Define flags
-	refDirPath := flag.String("refDir", "", "Path to the reference directory")
-	targetDirPath := flag.String("targetDir", "", "Path to the target directory")
-	parallelism := flag.Int("parallelism", runtime.NumCPU()/2, "Number of parallel workers")
-	exactPathMatch := flag.Bool("exactPathMatch", true, "Exact path match flag")
-	deleteFiles := flag.Bool("deleteFiles", false, "Delete files flag")
+	configPath := flag.String("config", "", "Read options from this YAML file (or TOML if it ends in .toml), keyed by flag name; flags given on the command line take precedence")
+	flag.StringVar(&opts.Mode, "mode", "", "What to do: scan (print the reference manifest), validate (check a directory against a manifest), dedup, self (dedup within -targetDir), probe (estimate duplication from a sample), mergeManifests (combine several -refYaml), mergeReports (see -mergeReports), dumpIndex (see -dumpIndex), findCopies (see -refFile), compareTrees (see -compareTreesEqual), sizeHistogram (see -sizeHistogram), nameCollisions (see -dedupAcrossCaseAndUnicodeNormalizationReport) or printSchema (see -printSchema); inferred from the other flags if empty")
+	flag.StringVar(&opts.RefDir, "refDir", "", "Path to the reference directory")
+	flag.StringVar(&opts.RefFile, "refFile", "", "Path to a single reference file whose copies to list in the target (-mode findCopies)")
+	flag.BoolVar(&opts.SizeHistogram, "sizeHistogram", false, "Only print how many files and bytes of the target fall into each size range, without hashing anything (-mode sizeHistogram)")
+	flag.BoolVar(&opts.NameCollisions, "dedupAcrossCaseAndUnicodeNormalizationReport", false, "Only report target paths that are distinct as stored but collide once case is folded and Unicode normalized, as they would on macOS (-mode nameCollisions)")
+	flag.StringVar(&opts.PrintSchema, "printSchema", "", "Only print the JSON Schema of a machine-readable output: "+strings.Join(SchemaFormats(), ", ")+" (-mode printSchema)")
+	histogramBuckets := flag.String("histogramBuckets", "", "Ascending sizes at which the -sizeHistogram buckets start, e.g. 4K,1M,100M,1G; buckets double in size if empty")
+	flag.BoolVar(&opts.CompareTreesEqual, "compareTreesEqual", false, "Only check whether the reference and target trees hold the same files with the same content, exiting non-zero and listing the first differences if not (-mode compareTrees)")
+	flag.StringVar(&opts.TargetDir, "targetDir", "", "Path to the target directory")
+	defaultHashWorkers := runtime.NumCPU() / 2
+	if defaultHashWorkers < 1 {
+		defaultHashWorkers = 1
+	}
+	flag.IntVar(&opts.HashWorkers, "hashWorkers", defaultHashWorkers, "Number of files hashed in parallel")
+	flag.IntVar(&opts.HashWorkers, "parallelism", defaultHashWorkers, "Deprecated alias for -hashWorkers")
+	flag.IntVar(&opts.MaxHashPerDevice, "maxHashConcurrencyPerDevice", 0, "Hash at most this many files at once on each device (disk), so the -hashWorkers spread over all disks of a tree (0 for no limit)")
+	flag.IntVar(&opts.WalkWorkers, "walkWorkers", DefaultWalkWorkers, "Number of directories read in parallel; raise for high-latency filesystems")
+	flag.BoolVar(&opts.ExactPathMatch, "exactPathMatch", true, "Exact path match flag")
+	normalizeRefPaths := flag.String("normalizeRefPaths", "", "Normalize reference paths or names before matching them: comma-separated lower, nfc and slash")
+	normalizeTargetPaths := flag.String("normalizeTargetPaths", "", "Normalize target paths or names before matching them: comma-separated lower, nfc and slash")
+	flag.BoolVar(&opts.FollowSymlinksRef, "followSymlinksRef", false, "Hash the content behind symlinks to files in the reference directory (recorded under the link path)")
+	flag.BoolVar(&opts.FollowSymlinksTarget, "followSymlinksTarget", false, "Hash the content behind symlinks to files in the target directory (recorded under the link path)")
+	flag.BoolVar(&opts.ResolveSymlinks, "resolveBaseDirs", false, "Resolve symlinks in the reference and target base directories so equivalent spellings of a path compare equal")
+	flag.BoolVar(&opts.WarnSpecialFiles, "warnSpecialFiles", false, "Warn about every named pipe, socket and device skipped while scanning")
+	flag.BoolVar(&opts.ExcludeSameDir, "excludeSameDir", false, "Never count a target file as a duplicate of a reference entry with the same absolute path (for overlapping directories)")
+	flag.BoolVar(&opts.DeleteFiles, "deleteFiles", false, "Delete files flag")
+	flag.StringVar(&opts.ConsolidateTo, "consolidateTo", "", "Copy the unique files of both reference and target into this directory instead of planning deletions")
+	flag.BoolVar(&opts.PruneEmptyDirs, "pruneEmptyDirs", false, "After deleting files, remove target directories that became empty")
+	flag.BoolVar(&opts.PruneAllEmptyDirs, "pruneAllEmptyDirs", false, "With -pruneEmptyDirs, also remove target directories that were already empty")
+	flag.IntVar(&opts.DeleteBatchSize, "deleteBatchSize", 0, "Delete files in batches of this size (0 deletes all at once)")
+	flag.DurationVar(&opts.DeletePause, "deletePause", 0, "Pause between deletion batches, e.g. 500ms")
+	flag.BoolVar(&opts.PreserveHardlinks, "preserveHardlinkGroups", false, "Delete target paths that are hardlinks to the same file all together or not at all (Unix only)")
+	flag.BoolVar(&opts.RehashOnMatch, "rehashOnMatch", false, "With -deleteFiles, re-hash each duplicate and its reference file right before deleting it, and keep it if either changed since the scan")
+	flag.StringVar(&opts.AfterDelete, "afterDelete", "", "Shell command run after each deleted file, with the path appended as an argument")
+	flag.BoolVar(&opts.AfterDeletePerBatch, "afterDeletePerBatch", false, "Run the -afterDelete command once per deletion batch with the deleted paths on stdin, one per line")
+	flag.BoolVar(&opts.AfterDeleteFailFast, "afterDeleteFailFast", false, "Stop deleting when the -afterDelete command fails instead of reporting the failures at the end")
+	flag.BoolVar(&opts.NoLock, "noLock", false, "Do not take the advisory lock that -deleteFiles holds on the target directory from before the scan until the run ends")
+	flag.BoolVar(&opts.WaitLock, "waitLock", false, "Wait for another run holding the target directory lock instead of refusing to run")
+	flag.BoolVar(&opts.SummaryOnly, "summaryOnly", false, "Print only the aggregate duplicate counts and reclaimable space instead of the per-file plan")
+	flag.IntVar(&opts.MaxReported, "maxReported", 0, "Print at most this many lines of the deletion plan, followed by the totals of all duplicates (0 prints everything)")
+	flag.BoolVar(&opts.RequireModTimeMatch, "requireModTimeMatch", false, "Only treat files as duplicates if their modification times also match (within -modTimeTolerance)")
+	flag.BoolVar(&opts.EmptyFilesSamePath, "dedupIgnoreZeroByteGroupsUnlessSamePath", false, "Only treat empty files as duplicates if they have the same relative path, whatever -exactPathMatch says for other files")
+	flag.DurationVar(&opts.ModTimeTolerance, "modTimeTolerance", 0, "How far apart modification times may be under -requireModTimeMatch, e.g. 2s for FAT")
+	flag.DurationVar(&opts.TimeWindow, "dedupByTimeWindow", 0, "Also report same-size files modified within this duration of each other as likely related (report only)")
+	flag.BoolVar(&opts.SI, "si", false, "Print sizes in powers of 1000 (kB, MB) instead of 1024 (KiB, MiB)")
+	flag.BoolVar(&opts.ShowConflicts, "showConflicts", false, "Report files at the same relative path in reference and target whose content differs")
+	flag.BoolVar(&opts.ShowRenames, "dedupAcrossRenames", false, "Report reference files whose content only appears at a different path in the target as renames (report only)")
+	flag.BoolVar(&opts.FindTruncated, "findTruncated", false, "Report files whose content is a prefix of a larger file, e.g. incomplete downloads (report only)")
+	flag.Int64Var(&opts.TruncatedMinSize, "truncatedMinSize", 1024, "Smallest file size in bytes -findTruncated considers")
+	flag.BoolVar(&opts.AudioFingerprint, "dedupByAudioFingerprint", false, "Also report audio files that probably hold the same recording in another format or bitrate, by acoustic fingerprint (needs Chromaprint's fpcalc; report only)")
+	flag.IntVar(&opts.TopByCount, "topDuplicatesByCount", 0, "Also report this many contents with the most copies, with their count, size and a sample path (report only)")
+	flag.Float64Var(&opts.AudioSimilarity, "audioSimilarity", 0.85, "Fraction of matching fingerprint bits, between 0.5 and 1, from which -dedupByAudioFingerprint reports two tracks")
+	flag.StringVar(&opts.Fpcalc, "fpcalc", "fpcalc", "Path to Chromaprint's fpcalc, used by -dedupByAudioFingerprint")
+	flag.BoolVar(&opts.CompareXattrs, "compareXattrs", false, "Only treat files as duplicates if their extended attributes also match (Linux and macOS; ignored by default)")
+	flag.Int64Var(&opts.Body.SkipHeadBytes, "skipHeadBytes", 0, "Compare files by a body hash that leaves out this many leading bytes")
+	flag.IntVar(&opts.Body.SkipHeadLines, "skipHeadLines", 0, "Compare files by a body hash that leaves out this many leading lines")
+	flag.Int64Var(&opts.Body.SkipTailBytes, "skipTailBytes", 0, "Compare files by a body hash that leaves out this many trailing bytes")
+	flag.IntVar(&opts.Body.SkipTailLines, "skipTailLines", 0, "Compare files by a body hash that leaves out this many trailing lines")
+	flag.StringVar(&opts.ReportDelta, "dedupReportDelta", "", "In -mode self, instead of a plan, report duplicate groups that are new, resolved or grown since this earlier manifest of the target")
+	flag.Var(&opts.KeepPatterns, "keepPattern", "In -mode self, prefer keeping files whose path matches this glob (* spans directories); repeatable")
+	flag.Var(&opts.DeletePatterns, "deletePattern", "In -mode self, prefer deleting files whose path matches this glob (* spans directories); repeatable")
+	flag.BoolVar(&opts.PreferFewerLinks, "dedupPreferFewerLinks", false, "In -mode self, keep the copy with the most hardlinks and delete those with fewer, which frees real space (Unix only)")
+	flag.Float64Var(&opts.SampleRate, "sampleRate", 1, "Percentage of files hashed in -mode probe")
+	flag.Int64Var(&opts.Seed, "seed", 0, "Seed selecting the files sampled in -mode probe")
+	flag.BoolVar(&opts.CompareSizesOnly, "compareSizesOnly", false, "Without reading any content, report an upper bound on duplication from files sharing a size, to judge whether a full scan is worthwhile")
+	flag.BoolVar(&opts.MetaOnly, "metaOnly", false, "Match files by size, modification time and name without reading their content; results are unverified and cannot be deleted")
+	flag.StringVar(&opts.ResultCache, "dedupResultCache", "", "Keep the duplicates found in this file, and reuse them instead of comparing again while neither tree nor the options changed")
+	flag.BoolVar(&opts.ManifestsOnly, "compareManifestToManifest", false, "Compare a -refYaml or -refIndex with a -targetYaml without either tree being present, and report the duplicates instead of planning their deletion")
+	flag.BoolVar(&opts.EdgesOnly, "edgesOnly", false, "Match files by size and a hash of only their first and last blocks, reading a few KB per file; results are heuristic and cannot be deleted without -confirm")
+	flag.Int64Var(&opts.EdgeBlockSize, "edgeBlockSize", DefaultEdgeBlockSize, "Bytes read from each end of a file by -edgesOnly")
+	flag.BoolVar(&opts.NameAndSize, "dedupByNameAndSize", false, "Match files by file name and size alone, without reading any content; results are unverified and cannot be deleted without -confirm")
+	flag.BoolVar(&opts.Confirm, "confirm", false, "With -edgesOnly or -dedupByNameAndSize, full-hash the candidates and their reference files, and act only on those that match")
+	flag.BoolVar(&opts.Paranoid, "paranoid", false, "Compare every hash-matched group byte for byte before acting on it, and leave out groups whose content differs or cannot be read")
+	flag.BoolVar(&opts.Paranoid, "compareContentForHashMatches", false, "Alias for -paranoid")
+	flag.StringVar(&opts.Explain, "explain", "", "Instead of a plan, print why this target file is or is not considered a duplicate")
+	flag.StringVar(&opts.Hash.Default, "hashAlgo", DefaultHashAlgo, "Hash algorithm, one of "+strings.Join(HashAlgorithms(), ", ")+" (xxhash is fast, but not cryptographic)")
+	flag.BoolVar(&opts.ListHashAlgos, "listHashAlgos", false, "Print the supported hash algorithms and their digest lengths, then exit")
+	flag.Int64Var(&opts.InlineContentBelow, "inlineContentBelow", 0, fmt.Sprintf("Record the full content of files smaller than this many bytes (at most %d) in the manifest and compare it too", MaxInlineContent))
+	owner := flag.String("owner", "", "Only consider target files owned by this user, a name or numeric ID (Unix only)")
+	group := flag.String("group", "", "Only consider target files owned by this group, a name or numeric ID (Unix only)")
+	flag.BoolVar(&opts.SkipBusy, "skipBusy", false, "Skip files another process holds open or locked, when hashing and when deleting, and list them at the end for a later retry (best effort)")
+	flag.BoolVar(&opts.CaptureOwner, "captureOwner", false, "Record the numeric owner and group ID of each file in the manifest (Unix only)")
+	hashByExt := flag.String("hashByExt", "", "Per-extension hash algorithm overrides, e.g. '.mp4=xxhash,.mkv=xxhash'")
+	flag.Var(&opts.Outputs, "output", "Send the duplicates and a summary to text, json or csv, written to stdout or format:path, instead of the plan; repeatable, e.g. -output text -output csv:dups.csv")
+	flag.StringVar(&opts.GroupOutput, "groupOutput", "", "Also write the duplicate clusters (hash, size, keeper and member paths) as JSON to this path, or to stdout instead of the plan if -")
+	flag.BoolVar(&opts.Preview, "preview", false, "Precede the plan lines of each file duplicated with a preview of its content (hex summary for binary files)")
+	flag.IntVar(&opts.PreviewBytes, "previewBytes", DefaultPreviewBytes, "Number of leading bytes shown by -preview")
+	sortBy := flag.String("sortBy", "", "Order the duplicates of the plan and of -output by path, size, wastedSpace, groupSize or mtime instead of the order they were found in")
+	flag.StringVar(&opts.ScriptOut, "scriptOut", "", "Write the deletion plan as an executable shell script to this path instead of stdout")
 
 	// Define YAML input flags
-	refYamlPath := flag.String("refYaml", "", "Path to reference directory YAML file")
-	targetYamlPath := flag.String("targetYaml", "", "Path to target directory YAML file")
+	flag.Var(&opts.RefYamls, "refYaml", "Path to reference directory YAML file; repeatable for -mode mergeManifests")
+	matchPolicy := flag.String("matchPolicy", string(MatchAny), "With several -refYaml in dedup mode, a target file is a duplicate if it is in any of them, or only if it is in all of them")
+	flag.Var(&opts.MergeReports, "mergeReports", "Path to the -output json report of one shard of a target; repeatable, merges them into one report sent to -output (-mode mergeReports)")
+	flag.StringVar(&opts.ManifestOut, "manifestOut", "", "Write the manifest of -mode scan or mergeManifests to this file instead of stdout")
+	flag.IntVar(&opts.CompressLevel, "compressLevel", DefaultCompressLevel, "zstd level (1-22) for manifests, indexes and dumps written to paths ending in .zst")
+	flag.StringVar(&opts.TargetYaml, "targetYaml", "", "Path to target directory YAML file")
+	flag.StringVar(&opts.RefIndex, "refIndex", "", "Path to a binary reference index written by -writeIndex, loads much faster than -refYaml")
+	flag.StringVar(&opts.DumpIndex, "dumpIndex", "", "Write the reference hash to paths map to this file (JSON if it ends in .json, YAML otherwise; - for stdout) without comparing anything")
+	flag.StringVar(&opts.WriteIndex, "writeIndex", "", "Also write the reference directory info to this path as a binary index")
+
+	flag.StringVar(&opts.CPUProfile, "cpuprofile", "", "Write a CPU profile to this file")
+	flag.StringVar(&opts.MemProfile, "memprofile", "", "Write a heap profile to this file on exit")
+
+	flag.BoolVar(&opts.VerifyManifestPaths, "verifyManifestPaths", false, "Check that every file listed in a loaded YAML manifest still exists with its recorded size before comparing")
+	flag.BoolVar(&opts.StrictHashLength, "strictHashLength", false, "Fail instead of warning when a loaded manifest has a hash that is not hex of the length its algorithm produces")
+	flag.StringVar(&opts.TransactionLog, "dedupTransactionLog", "", "With -deleteFiles, append every planned deletion to this JSON lines file before deleting anything, then each outcome and whether the run finished")
+	flag.IntVar(&opts.StatsFd, "statsFd", 0, "Write live scan statistics as JSON lines to this already open file descriptor, e.g. 3")
+	flag.StringVar(&opts.StatsSocket, "statsSocket", "", "Write live scan statistics as JSON lines to this Unix socket")
+	flag.DurationVar(&opts.StatsInterval, "statsInterval", time.Second, "Interval between -statsFd or -statsSocket lines")
+	flag.StringVar(&opts.ErrorLog, "errorLog", "", "Skip files and directories that cannot be read instead of failing, and record each as a JSON line with its error in this file")
+	flag.BoolVar(&opts.StrictManifest, "strictManifest", false, "With -verifyManifestPaths, fail instead of warning when files are missing or changed")
+
+	flag.Parse()
+	if *configPath != "" {
+		if err := ApplyConfig(flag.CommandLine, *configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -config: %v\n", err)
+			exit(1)
+		}
+	}
+	if len(opts.RefYamls) > 0 {
+		opts.RefYaml = opts.RefYamls[0]
+	}
+
+	if *hashByExt != "" {
+		byExt, err := ParseHashByExt(*hashByExt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -hashByExt: %v\n", err)
+			exit(1)
+		}
+		opts.Hash.ByExt = byExt
+	}
+	var err error
+	if opts.MatchPolicy, err = ParseMatchPolicy(*matchPolicy); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -matchPolicy: %v\n", err)
+		exit(1)
+	}
+	if opts.NormalizeRefPath, err = ParsePathNormalizer(*normalizeRefPaths); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -normalizeRefPaths: %v\n", err)
+		exit(1)
+	}
+	if opts.NormalizeTargetPath, err = ParsePathNormalizer(*normalizeTargetPaths); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -normalizeTargetPaths: %v\n", err)
+		exit(1)
+	}
+	opts.normalizeSpecs = *normalizeRefPaths + "\x00" + *normalizeTargetPaths
+	if opts.Owner, err = ParseOwnerFilter(*owner, *group); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -owner or -group: %v\n", err)
+		exit(1)
+	}
+	if *sortBy != "" {
+		if opts.SortBy, err = ParseSortKey(*sortBy); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -sortBy: %v\n", err)
+			exit(1)
+		}
+	}
+
+	if err := opts.Hash.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid hash options: %v\n", err)
+		exit(1)
+	}
+
+	if err := validateCompressLevel(opts.CompressLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -compressLevel: %v\n", err)
+		exit(1)
+	}
+	if *histogramBuckets != "" {
+		var err error
+		if opts.HistogramBuckets, err = ParseSizeBounds(*histogramBuckets); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -histogramBuckets: %v\n", err)
+			exit(1)
+		}
+	}
+	if err := validateInlineThreshold(opts.InlineContentBelow); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -inlineContentBelow: %v\n", err)
+		exit(1)
+	}
+
+	if opts.TopByCount < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -topDuplicatesByCount: must not be negative, got %d\n", opts.TopByCount)
+		exit(1)
+	}
+	if opts.AudioSimilarity <= 0.5 || opts.AudioSimilarity > 1 {
+		fmt.Fprintf(os.Stderr, "Invalid -audioSimilarity: must be above 0.5 and at most 1, got %g\n", opts.AudioSimilarity)
+		exit(1)
+	}
+
+	if opts.EdgeBlockSize <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -edgeBlockSize: must be positive, got %d\n", opts.EdgeBlockSize)
+		exit(1)
+	}
+	if opts.ManifestsOnly {
+		if opts.RefDir != "" || opts.TargetDir != "" || (opts.RefYaml == "" && opts.RefIndex == "") || opts.TargetYaml == "" {
+			fmt.Fprintln(os.Stderr, "-compareManifestToManifest takes -refYaml or -refIndex and -targetYaml, and no -refDir or -targetDir")
+			exit(1)
+		}
+		if opts.DeleteFiles || opts.ScriptOut != "" || opts.ConsolidateTo != "" || opts.FindTruncated || opts.AudioFingerprint ||
+			opts.Paranoid || opts.RehashOnMatch || opts.Preview || opts.EdgesOnly || opts.Confirm {
+			fmt.Fprintln(os.Stderr, "Deleting or reading files requires a live target: -compareManifestToManifest cannot be combined with -deleteFiles, -scriptOut, -consolidateTo, -findTruncated, -dedupByAudioFingerprint, -paranoid, -rehashOnMatch, -preview, -edgesOnly or -confirm")
+			exit(1)
+		}
+	}
+	if opts.Confirm && !opts.EdgesOnly && !opts.NameAndSize {
+		fmt.Fprintln(os.Stderr, "-confirm only applies to -edgesOnly and -dedupByNameAndSize")
+		exit(1)
+	}
+	if opts.EdgesOnly && (opts.MetaOnly || opts.CompareSizesOnly || !opts.Body.IsZero() || opts.InlineContentBelow > 0) {
+		fmt.Fprintln(os.Stderr, "-edgesOnly cannot be combined with -metaOnly, -compareSizesOnly, -inlineContentBelow or header/footer options")
+		exit(1)
+	}
+	if opts.NameAndSize && (opts.EdgesOnly || opts.MetaOnly || opts.CompareSizesOnly || !opts.Body.IsZero() || opts.InlineContentBelow > 0) {
+		fmt.Fprintln(os.Stderr, "-dedupByNameAndSize cannot be combined with -edgesOnly, -metaOnly, -compareSizesOnly, -inlineContentBelow or header/footer options")
+		exit(1)
+	}
+
+	if opts.TransactionLog != "" && !opts.DeleteFiles {
+		fmt.Fprintln(os.Stderr, "-dedupTransactionLog only applies to -deleteFiles")
+		exit(1)
+	}
+
+	if opts.StatsFd < 0 || opts.StatsFd == 1 || opts.StatsFd == 2 {
+		fmt.Fprintf(os.Stderr, "Invalid -statsFd: must be 3 or above, got %d\n", opts.StatsFd)
+		exit(1)
+	}
+	if opts.StatsFd > 0 && opts.StatsSocket != "" {
+		fmt.Fprintln(os.Stderr, "-statsFd and -statsSocket cannot be combined")
+		exit(1)
+	}
+	if opts.StatsInterval <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -statsInterval: must be positive, got %s\n", opts.StatsInterval)
+		exit(1)
+	}
+
+	if opts.Preview && opts.PreviewBytes <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -previewBytes: must be positive, got %d\n", opts.PreviewBytes)
+		exit(1)
+	}
+
+	if err := opts.Body.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid header/footer options: %v\n", err)
+		exit(1)
+	}
+	// files matched by their body can differ in the header and footer, so deleting one would lose that data
+	if !opts.Body.IsZero() && (opts.DeleteFiles || opts.ScriptOut != "") {
+		fmt.Fprintln(os.Stderr, "-skipHeadBytes, -skipHeadLines, -skipTailBytes and -skipTailLines only report matches and cannot be combined with -deleteFiles or -scriptOut")
+		exit(1)
+	}
+	return opts
+}
+
+func main() {
+	opts := parseFlags()
+	if opts.ListHashAlgos {
+		printHashAlgos()
+		return
+	}
+
+	if err := startProfiling(opts.CPUProfile, opts.MemProfile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting profiling: %v\n", err)
+		exit(1)
+	}
+	defer runCleanup()
+	openErrorLog(opts)
+	openScanStats(opts)
+	if opts.SkipBusy {
+		cleanupFuncs = append(cleanupFuncs, func() { reportBusyFiles(opts.busyFiles) })
+	}
+
+	mode := opts.Mode
+	if mode == "" {
+		// infer the mode the way the flags were always interpreted
+		switch {
+		case opts.DumpIndex != "":
+			mode = "dumpIndex"
+		case len(opts.MergeReports) > 0:
+			mode = "mergeReports"
+		case opts.CompareTreesEqual:
+			mode = "compareTrees"
+		case opts.SizeHistogram:
+			mode = "sizeHistogram"
+		case opts.NameCollisions:
+			mode = "nameCollisions"
+		case opts.PrintSchema != "":
+			mode = "printSchema"
+		case opts.RefFile != "":
+			mode = "findCopies"
+		case opts.TargetDir != "" || opts.TargetYaml != "":
+			mode = "dedup"
+		case opts.RefYaml != "" || opts.RefIndex != "":
+			mode = "validate"
+		default:
+			mode = "scan"
+		}
+	}
+
+	if len(opts.RefYamls) > 1 && mode != "mergeManifests" && mode != "dedup" {
+		fmt.Fprintln(os.Stderr, "Only -mode mergeManifests and dedup accept more than one -refYaml")
+		exit(1)
+	}
+
+	if opts.Explain != "" && mode != "dedup" {
+		fmt.Fprintln(os.Stderr, "-explain is only supported in dedup mode")
+		exit(1)
+	}
+	if (opts.DumpIndex != "") != (mode == "dumpIndex") {
+		fmt.Fprintln(os.Stderr, "-dumpIndex and -mode dumpIndex go together")
+		exit(1)
+	}
+	if opts.SizeHistogram != (mode == "sizeHistogram") {
+		fmt.Fprintln(os.Stderr, "-sizeHistogram and -mode sizeHistogram go together")
+		exit(1)
+	}
+	if opts.NameCollisions != (mode == "nameCollisions") {
+		fmt.Fprintln(os.Stderr, "-dedupAcrossCaseAndUnicodeNormalizationReport and -mode nameCollisions go together")
+		exit(1)
+	}
+	if (opts.PrintSchema != "") != (mode == "printSchema") {
+		fmt.Fprintln(os.Stderr, "-printSchema and -mode printSchema go together")
+		exit(1)
+	}
+	if opts.HistogramBuckets != nil && mode != "sizeHistogram" {
+		fmt.Fprintln(os.Stderr, "-histogramBuckets is only supported in sizeHistogram mode")
+		exit(1)
+	}
+	if len(opts.MergeReports) > 0 && mode != "mergeReports" {
+		fmt.Fprintln(os.Stderr, "-mergeReports is only supported in mergeReports mode")
+		exit(1)
+	}
+	if opts.CompareTreesEqual && mode != "compareTrees" {
+		fmt.Fprintln(os.Stderr, "-compareTreesEqual and -mode compareTrees go together")
+		exit(1)
+	}
+	if opts.ReportDelta != "" && mode != "self" {
+		fmt.Fprintln(os.Stderr, "-dedupReportDelta is only supported in self mode")
+		exit(1)
+	}
+	if opts.PreferFewerLinks && mode != "self" {
+		fmt.Fprintln(os.Stderr, "-dedupPreferFewerLinks is only supported in self mode")
+		exit(1)
+	}
+	if opts.CompareSizesOnly && mode != "dedup" && mode != "self" {
+		fmt.Fprintln(os.Stderr, "-compareSizesOnly is only supported in dedup and self modes")
+		exit(1)
+	}
+	if opts.TopByCount > 0 && mode != "dedup" && mode != "self" {
+		fmt.Fprintln(os.Stderr, "-topDuplicatesByCount is only supported in dedup and self modes")
+		exit(1)
+	}
+	if opts.AudioFingerprint && mode != "dedup" && mode != "self" {
+		fmt.Fprintln(os.Stderr, "-dedupByAudioFingerprint is only supported in dedup and self modes")
+		exit(1)
+	}
+	if opts.GroupOutput != "" && mode != "dedup" && mode != "self" {
+		fmt.Fprintln(os.Stderr, "-groupOutput is only supported in dedup and self modes")
+		exit(1)
+	}
+	if opts.MetaOnly && mode != "dedup" {
+		fmt.Fprintln(os.Stderr, "-metaOnly is only supported in dedup mode")
+		exit(1)
+	}
+	if !opts.Owner.IsZero() && mode != "dedup" && mode != "self" {
+		fmt.Fprintln(os.Stderr, "-owner and -group are only supported in dedup and self modes")
+		exit(1)
+	}
+	if opts.ResultCache != "" && mode != "dedup" {
+		fmt.Fprintln(os.Stderr, "-dedupResultCache is only supported in dedup mode")
+		exit(1)
+	}
+	if opts.EdgesOnly && mode != "dedup" {
+		fmt.Fprintln(os.Stderr, "-edgesOnly is only supported in dedup mode")
+		exit(1)
+	}
+	if opts.NameAndSize && mode != "dedup" {
+		fmt.Fprintln(os.Stderr, "-dedupByNameAndSize is only supported in dedup mode")
+		exit(1)
+	}
+	if opts.SortBy != "" && mode != "dedup" && mode != "self" && mode != "mergeReports" {
+		fmt.Fprintln(os.Stderr, "-sortBy is only supported in dedup, self and mergeReports modes")
+		exit(1)
+	}
+	if opts.RequireModTimeMatch && mode != "dedup" {
+		fmt.Fprintln(os.Stderr, "-requireModTimeMatch is only supported in dedup mode")
+		exit(1)
+	}
+	if opts.EmptyFilesSamePath && mode != "dedup" {
+		fmt.Fprintln(os.Stderr, "-dedupIgnoreZeroByteGroupsUnlessSamePath is only supported in dedup mode")
+		exit(1)
+	}
+	if opts.ModTimeTolerance < 0 {
+		fmt.Fprintln(os.Stderr, "Invalid -modTimeTolerance: must not be negative")
+		exit(1)
+	}
+	if opts.ModTimeTolerance > 0 && !opts.RequireModTimeMatch {
+		fmt.Fprintln(os.Stderr, "-modTimeTolerance only applies with -requireModTimeMatch")
+		exit(1)
+	}
+
+	switch mode {
+	case "scan":
+		runScan(opts)
+	case "validate":
+		runValidate(opts)
+	case "dedup":
+		runDedup(opts)
+	case "self":
+		runSelf(opts)
+	case "probe":
+		runProbe(opts)
+	case "mergeManifests":
+		runMergeManifests(opts)
+	case "mergeReports":
+		runMergeReports(opts)
+	case "dumpIndex":
+		runDumpIndex(opts)
+	case "findCopies":
+		runFindCopies(opts)
+	case "compareTrees":
+		runCompareTrees(opts)
+	case "sizeHistogram":
+		runSizeHistogram(opts)
+	case "nameCollisions":
+		runNameCollisions(opts)
+	case "printSchema":
+		runPrintSchema(opts)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown mode %q, expected scan, validate, dedup, self, probe, mergeManifests, mergeReports, dumpIndex, findCopies, compareTrees, sizeHistogram, nameCollisions or printSchema\n", mode)
+		exit(1)
+	}
+}
+
+// runScan hashes the reference directory and streams its manifest as YAML to stdout or -manifestOut
+func runScan(opts *options) {
+	if opts.RefDir == "" {
+		fmt.Fprintln(os.Stderr, "Reference directory path must be provided")
+		exit(1)
+	}
+	var manifestOut io.WriteCloser
+	if opts.ManifestOut != "" {
+		var err error
+		if manifestOut, err = createManifest(opts.ManifestOut, opts.CompressLevel); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating manifest: %v\n", err)
+			exit(1)
+		}
+	}
+	refDirInfo, err := WalkDirectoryWithOptions(opts.RefDir, withRunOptions(opts, WalkOptions{
+		YamlOutput:         manifestOut,
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		OutputYamlToStdout: true,
+		FollowSymlinks:     opts.FollowSymlinksRef,
+		CaptureXattrs:      opts.CompareXattrs,
+		Body:               opts.Body,
+	}))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error walking reference directory: %v\n", err)
+		exit(1)
+	}
+	if manifestOut != nil {
+		if err := manifestOut.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing manifest: %v\n", err)
+			exit(1)
+		}
+	}
+	writeReferenceIndex(refDirInfo, opts)
+}
+
+// writeReferenceIndex writes the -writeIndex file if requested
+func writeReferenceIndex(refDirInfo *DirectoryInfo, opts *options) {
+	if opts.WriteIndex == "" {
+		return
+	}
+	if err := WriteIndexWithLevel(opts.WriteIndex, refDirInfo, opts.CompressLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing reference index: %v\n", err)
+		exit(1)
+	}
+}
+
+// runValidate checks a directory against a manifest of it, reporting matched, missing, extra and changed files.
+// The directory defaults to the base directory recorded in the manifest.
+func runValidate(opts *options) {
+	manifestPath := opts.RefYaml
+	readManifest := readDirectoryInfoFromYAML
+	if opts.RefIndex != "" {
+		manifestPath, readManifest = opts.RefIndex, ReadIndex
+	}
+	if manifestPath == "" {
+		fmt.Fprintln(os.Stderr, "Reference YAML file or index must be provided to validate against")
+		exit(1)
+	}
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading reference manifest: %v\n", err)
+		exit(1)
+	}
+	checkManifestHashes(manifest, manifestPath, opts.StrictHashLength)
+	dir := opts.RefDir
+	if dir == "" {
+		dir = manifest.BaseDir
+	}
+
+	fmt.Printf("Validating %s against %s...\n", dir, manifestPath)
+	// the directory is checked file by file as it is hashed, so only the manifest is held in memory
+	counts, err := ValidateDirectoryStream(manifest, dir, withRunOptions(opts, WalkOptions{
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		FollowSymlinks:     opts.FollowSymlinksRef,
+		CaptureXattrs:      opts.CompareXattrs,
+		Body:               opts.Body,
+	}), func(event ValidationEvent) {
+		switch event.Status {
+		case ValidationMissing:
+			fmt.Printf("missing: %s\n", event.File.Path)
+		case ValidationExtra:
+			fmt.Printf("extra: %s\n", event.File.Path)
+		case ValidationChanged:
+			fmt.Printf("changed: %s\n", event.File.Path)
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error walking reference directory: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("%s matched, %s missing, %s extra, %s changed\n", FormatCount(counts.Matched), FormatCount(counts.Missing), FormatCount(counts.Extra), FormatCount(counts.Changed))
+	if !counts.OK() {
+		exit(1)
+	}
+}
+
+// loadTargetDirectoryInfo loads the target of a run that may delete from it. With -deleteFiles it first takes the
+// advisory lock on the target, so no other run changes the tree between the scan and the deletions. A manifest
+// names its base directory, so that is locked as soon as the manifest is read.
+func loadTargetDirectoryInfo(opts *options, walkOpts WalkOptions) *DirectoryInfo {
+	if !opts.DeleteFiles || opts.NoLock {
+		return loadDirectoryInfo(opts, "target", opts.TargetDir, opts.TargetYaml, "", walkOpts)
+	}
+	if opts.TargetYaml == "" {
+		lockTargetDir(opts, opts.TargetDir)
+		return loadDirectoryInfo(opts, "target", opts.TargetDir, "", "", walkOpts)
+	}
+	dirInfo := loadDirectoryInfo(opts, "target", "", opts.TargetYaml, "", walkOpts)
+	lockTargetDir(opts, dirInfo.BaseDir)
+	return dirInfo
+}
+
+// lockTargetDir takes the advisory lock on dir until the run ends
+func lockTargetDir(opts *options, dir string) {
+	lock, err := AcquireDirLock(dir, opts.WaitLock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error locking target directory: %v\n", err)
+		exit(1)
+	}
+	cleanupFuncs = append(cleanupFuncs, func() { lock.Release() })
+}
+
+// loadDirectoryInfo reads a binary index from indexPath or a manifest from yamlPath if given, otherwise walks dirPath.
+// walkOpts.Owner also filters a manifest, on the owner and group it recorded.
+func loadDirectoryInfo(opts *options, label, dirPath, yamlPath, indexPath string, walkOpts WalkOptions) *DirectoryInfo {
+	var dirInfo *DirectoryInfo
+	var err error
+	switch {
+	case indexPath != "":
+		dirInfo, err = ReadIndex(indexPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s index: %v\n", label, err)
+			exit(1)
+		}
+		checkManifestHashes(dirInfo, indexPath, opts.StrictHashLength)
+		if opts.VerifyManifestPaths {
+			checkManifestPaths(dirInfo, indexPath, opts.StrictManifest)
+		}
+	case yamlPath != "":
+		dirInfo, err = readDirectoryInfoFromYAML(yamlPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s YAML: %v\n", label, err)
+			exit(1)
+		}
+		checkManifestHashes(dirInfo, yamlPath, opts.StrictHashLength)
+		if opts.VerifyManifestPaths {
+			checkManifestPaths(dirInfo, yamlPath, opts.StrictManifest)
+		}
+		if err := FilterRecordedOwner(dirInfo, walkOpts.Owner); err != nil {
+			fmt.Fprintf(os.Stderr, "Error filtering %s YAML by owner: %v\n", label, err)
+			exit(1)
+		}
+	case dirPath != "":
+		dirInfo, err = WalkDirectoryWithOptions(dirPath, withRunOptions(opts, walkOpts))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error walking %s directory: %v\n", label, err)
+			exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "%s directory path or YAML file must be provided\n", strings.ToUpper(label[:1])+label[1:])
+		exit(1)
+	}
+
+	if opts.ResolveSymlinks {
+		// a manifest's base directory may only exist on the machine it was made on, so this is best effort
+		if err := CanonicalizePaths(dirInfo, true); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not resolve %s base directory %s: %v\n", label, dirInfo.BaseDir, err)
+		}
+	}
+	return dirInfo
+}
+
+// printHashAlgos lists the supported hash algorithms with their digest length in bits and hex characters
+func printHashAlgos() {
+	for _, algo := range HashAlgorithms() {
+		hasher, err := newHasher(algo)
+		if err != nil {
+			continue
+		}
+		note := ""
+		if algo == DefaultHashAlgo {
+			note = " (default)"
+		}
+		fmt.Printf("%-8s %4d bits, %3d hex characters%s\n", algo, hasher.Size()*8, hasher.Size()*2, note)
+	}
+}
+
+// openErrorLog creates the -errorLog file, closing it and reporting how many paths were skipped on exit
+func openErrorLog(opts *options) {
+	if opts.ErrorLog == "" {
+		return
+	}
+	file, err := os.Create(opts.ErrorLog)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating error log: %v\n", err)
+		exit(1)
+	}
+	opts.errorLog = NewErrorLog(file)
+	cleanupFuncs = append(cleanupFuncs, func() {
+		if err := opts.errorLog.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing error log: %v\n", err)
+		}
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing error log: %v\n", err)
+		}
+		if count := opts.errorLog.Count(); count > 0 {
+			fmt.Fprintf(os.Stderr, "%s paths could not be read and were skipped, see %s\n", FormatCount(count), opts.ErrorLog)
+		}
+	})
+}
+
+// openTransactionLog opens the -dedupTransactionLog file for appending, refusing to go on if the last run it
+// records did not finish, so that its account of what is left undone is looked at first
+func openTransactionLog(path string) *TransactionLog {
+	if existing, err := os.Open(path); err == nil {
+		state, err := ReadTransactionLog(existing)
+		existing.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading transaction log %s: %v\n", path, err)
+			exit(1)
+		}
+		if !state.Committed && (state.Aborted || len(state.Pending) > 0) {
+			fmt.Fprintf(os.Stderr, "Transaction log %s records an unfinished run with %s deletions pending; review it and move it away first.\n", path, FormatCount(len(state.Pending)))
+			exit(1)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening transaction log: %v\n", err)
+		exit(1)
+	}
+	cleanupFuncs = append(cleanupFuncs, func() {
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing transaction log: %v\n", err)
+		}
+	})
+	return NewTransactionLog(file)
+}
+
+// openScanStats starts emitting live scan statistics to -statsFd or -statsSocket, if one is given
+func openScanStats(opts *options) {
+	var out io.WriteCloser
+	switch {
+	case opts.StatsFd > 0:
+		out = os.NewFile(uintptr(opts.StatsFd), "statsFd")
+	case opts.StatsSocket != "":
+		conn, err := net.Dial("unix", opts.StatsSocket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error connecting to stats socket: %v\n", err)
+			exit(1)
+		}
+		out = conn
+	default:
+		return
+	}
+	opts.scanStats = NewScanStats()
+	stop := EmitScanStats(opts.scanStats, out, opts.StatsInterval)
+	cleanupFuncs = append(cleanupFuncs, func() {
+		// monitoring going away is not worth failing the run over
+		if err := stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: writing scan statistics stopped: %v\n", err)
+		}
+		out.Close()
+	})
+}
+
+// withRunOptions applies the options shared by every walk: it makes the walk skip unreadable paths and record
+// them in the -errorLog file, report its progress to -statsFd or -statsSocket, if given, and apply -captureOwner
+func withRunOptions(opts *options, walkOpts WalkOptions) WalkOptions {
+	walkOpts.Stats = opts.scanStats
+	walkOpts.CaptureOwner = opts.CaptureOwner
+	walkOpts.MaxHashPerDevice = opts.MaxHashPerDevice
+	// chained onto any hooks already set, such as those Run collects its result through
+	hooks := walkOpts.Hooks.clone()
+	if opts.SkipBusy {
+		walkOpts.SkipBusy = true
+		onComplete := hooks.OnComplete
+		hooks.OnComplete = func(summary Summary) {
+			opts.busyFiles = append(opts.busyFiles, summary.Busy...)
+			if onComplete != nil {
+				onComplete(summary)
+			}
+		}
+	}
+	if opts.errorLog != nil {
+		walkOpts.SkipErrors = true
+		onError := hooks.OnError
+		hooks.OnError = func(path string, err error) {
+			opts.errorLog.Record(path, err)
+			opts.walkErrors = append(opts.walkErrors, ReportError{Path: path, Error: err.Error()})
+			if onError != nil {
+				onError(path, err)
+			}
+		}
+	}
+	walkOpts.Hooks = hooks
+	return walkOpts
+}
+
+// reportBusyFiles lists the files -skipBusy left alone
+func reportBusyFiles(busy []string) {
+	if len(busy) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s files were in use by another process and skipped, retry them later:\n", FormatCount(len(busy)))
+	for _, path := range busy {
+		fmt.Fprintf(os.Stderr, "  %s\n", path)
+	}
+}
+
+// runFindCopies lists the target files with the same content as -refFile, one path per line
+func runFindCopies(opts *options) {
+	if opts.RefFile == "" {
+		fmt.Fprintln(os.Stderr, "Reference file path must be provided to find its copies")
+		exit(1)
+	}
+	targetDirInfo := loadDirectoryInfo(opts, "target", opts.TargetDir, opts.TargetYaml, "", WalkOptions{
+		HashWorkers:      opts.HashWorkers,
+		WalkWorkers:      opts.WalkWorkers,
+		Hash:             opts.Hash,
+		WarnSpecialFiles: opts.WarnSpecialFiles,
+		FollowSymlinks:   opts.FollowSymlinksTarget,
+	})
+	copies, err := FindCopies(opts.RefFile, targetDirInfo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error hashing reference file: %v\n", err)
+		exit(1)
+	}
+	for _, file := range copies {
+		fmt.Println(file.Path)
+	}
+	fmt.Fprintf(os.Stderr, "Found %s copies of %s (%s) among %s target files.\n", FormatCount(len(copies)), opts.RefFile, FormatBytes(totalSize(copies), opts.SI), FormatCount(len(targetDirInfo.Files)))
+}
+
+// maxTreeDifferences is how many differences runCompareTrees lists
+const maxTreeDifferences = 10
+
+// requireComparableHashes exits if the reference and target were hashed in ways compareOpts cannot compare, which would
+// otherwise silently find fewer duplicates
+func requireComparableHashes(ref, target *HashSpec, compareOpts CompareOptions) {
+	if err := CheckHashSpecs(ref, target, compareOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot compare the reference and target hashes: %v. Hash both with the same options.\n", err)
+		exit(1)
+	}
+}
+
+// runSizeHistogram prints the size distribution of the target, from a walk that only stats files
+func runSizeHistogram(opts *options) {
+	targetDirInfo := loadDirectoryInfo(opts, "target", opts.TargetDir, opts.TargetYaml, "", WalkOptions{
+		HashWorkers:      opts.HashWorkers,
+		WalkWorkers:      opts.WalkWorkers,
+		WarnSpecialFiles: opts.WarnSpecialFiles,
+		FollowSymlinks:   opts.FollowSymlinksTarget,
+		Owner:            opts.Owner,
+		MetaOnly:         true,
+	})
+
+	buckets := SizeHistogram(targetDirInfo.Files, opts.HistogramBuckets)
+	fmt.Printf("Sizes of %s files (%s), each range up to but not including its upper bound:\n", FormatCount(len(targetDirInfo.Files)), FormatBytes(totalSize(targetDirInfo.Files), opts.SI))
+	for _, bucket := range buckets {
+		sizes := fmt.Sprintf("%s and larger", FormatBytes(bucket.Min, opts.SI))
+		if bucket.Max > 0 {
+			sizes = fmt.Sprintf("%s - %s", FormatBytes(bucket.Min, opts.SI), FormatBytes(bucket.Max, opts.SI))
+		}
+		fmt.Printf("%-24s %12s files %12s\n", sizes, FormatCount(bucket.Files), FormatBytes(bucket.Bytes, opts.SI))
+	}
+}
+
+// runPrintSchema prints the JSON Schema of the output named by -printSchema
+func runPrintSchema(opts *options) {
+	schema, err := OutputSchema(opts.PrintSchema)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -printSchema: %v\n", err)
+		exit(1)
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding the schema: %v\n", err)
+		exit(1)
+	}
+	fmt.Println(string(data))
+}
+
+// runNameCollisions reports the target paths that would collide on a case-insensitive, normalizing file system.
+// A walk only stats files, so whether colliding files are identical is only known from a manifest with hashes.
+func runNameCollisions(opts *options) {
+	targetDirInfo := loadDirectoryInfo(opts, "target", opts.TargetDir, opts.TargetYaml, "", WalkOptions{
+		HashWorkers:      opts.HashWorkers,
+		WalkWorkers:      opts.WalkWorkers,
+		WarnSpecialFiles: opts.WarnSpecialFiles,
+		FollowSymlinks:   opts.FollowSymlinksTarget,
+		Owner:            opts.Owner,
+		MetaOnly:         true,
+	})
+
+	collisions := FindNameCollisions(targetDirInfo)
+	for _, collision := range collisions {
+		kind := "files"
+		switch {
+		case collision.Dir:
+			kind = "directories, contents would merge"
+		case collision.Identical:
+			kind = "identical files, one copy is enough"
+		}
+		fmt.Printf("# %s (%s)\n", collision.Normalized, kind)
+		for _, path := range collision.Paths {
+			// names differing only in normalization look the same, so spell out their code points
+			if strconv.QuoteToASCII(path) != strconv.Quote(path) {
+				fmt.Printf("#   %s  %+q\n", path, path)
+			} else {
+				fmt.Printf("#   %s\n", path)
+			}
+		}
+	}
+	fmt.Printf("# %s sets of paths among %s target files collide once case is folded and Unicode normalized.\n",
+		FormatCount(len(collisions)), FormatCount(len(targetDirInfo.Files)))
+}
+
+// runCompareTrees checks that reference and target hold the same relative paths with the same content,
+// exiting 1 with the first differences if they do not
+func runCompareTrees(opts *options) {
+	refDirInfo := loadDirectoryInfo(opts, "reference", opts.RefDir, opts.RefYaml, opts.RefIndex, WalkOptions{
+		HashWorkers:      opts.HashWorkers,
+		WalkWorkers:      opts.WalkWorkers,
+		Hash:             opts.Hash,
+		WarnSpecialFiles: opts.WarnSpecialFiles,
+		FollowSymlinks:   opts.FollowSymlinksRef,
+	})
+	targetDirInfo := loadDirectoryInfo(opts, "target", opts.TargetDir, opts.TargetYaml, "", WalkOptions{
+		HashWorkers:      opts.HashWorkers,
+		WalkWorkers:      opts.WalkWorkers,
+		Hash:             opts.Hash,
+		WarnSpecialFiles: opts.WarnSpecialFiles,
+		FollowSymlinks:   opts.FollowSymlinksTarget,
+	})
+	requireComparableHashes(refDirInfo.HashSpec, targetDirInfo.HashSpec, CompareOptions{})
+
+	result := ValidateDirectory(refDirInfo, targetDirInfo)
+	if result.OK() {
+		fmt.Printf("identical: %s files (%s)\n", FormatCount(len(result.Matched)), FormatBytes(totalSize(result.Matched), opts.SI))
+		return
+	}
+
+	var differences []string
+	for _, file := range result.Missing {
+		differences = append(differences, "only in reference: "+matchKey(refDirInfo, file, true))
+	}
+	for _, file := range result.Extra {
+		differences = append(differences, "only in target: "+matchKey(targetDirInfo, file, true))
+	}
+	for _, file := range result.Changed {
+		differences = append(differences, "content differs: "+matchKey(targetDirInfo, file, true))
+	}
+	for i, difference := range differences {
+		if i == maxTreeDifferences {
+			fmt.Printf("... and %s more\n", FormatCount(len(differences)-i))
+			break
+		}
+		fmt.Println(difference)
+	}
+	fmt.Printf("different: %s only in reference, %s only in target, %s with different content, %s identical\n", FormatCount(len(result.Missing)), FormatCount(len(result.Extra)), FormatCount(len(result.Changed)), FormatCount(len(result.Matched)))
+	exit(1)
+}
+
+// runDumpIndex writes the hash to paths map of the reference to the -dumpIndex file
+func runDumpIndex(opts *options) {
+	refDirInfo := loadDirectoryInfo(opts, "reference", opts.RefDir, opts.RefYaml, opts.RefIndex, WalkOptions{
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		FollowSymlinks:     opts.FollowSymlinksRef,
+	})
+	fileMap := NewFileMap(refDirInfo, opts.ExactPathMatch)
+
+	format := "yaml"
+	if strings.HasSuffix(strings.TrimSuffix(strings.ToLower(opts.DumpIndex), zstdExt), ".json") {
+		format = "json"
+	}
+	if opts.DumpIndex == "-" {
+		if err := WriteFileMap(os.Stdout, fileMap, format); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing index dump: %v\n", err)
+			exit(1)
+		}
+		return
+	}
+	out, err := createManifest(opts.DumpIndex, opts.CompressLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating index dump: %v\n", err)
+		exit(1)
+	}
+	if err := WriteFileMap(out, fileMap, format); err != nil {
+		out.Close()
+		fmt.Fprintf(os.Stderr, "Error writing index dump: %v\n", err)
+		exit(1)
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing index dump: %v\n", err)
+		exit(1)
+	}
+}
+
+// runMergeManifests combines the -refYaml manifests into one, reporting paths they disagree about
+func runMergeManifests(opts *options) {
+	if len(opts.RefYamls) == 0 {
+		fmt.Fprintln(os.Stderr, "At least one -refYaml manifest must be provided to merge")
+		exit(1)
+	}
+	var manifests []*DirectoryInfo
+	inputFiles := 0
+	for _, path := range opts.RefYamls {
+		manifest, err := readDirectoryInfoFromYAML(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading reference YAML %s: %v\n", path, err)
+			exit(1)
+		}
+		checkManifestHashes(manifest, path, opts.StrictHashLength)
+		manifests = append(manifests, manifest)
+		inputFiles += len(manifest.Files)
+	}
+
+	if err := CheckMergeableHashSpecs(manifests); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot merge manifests hashed differently: %v\n", err)
+		exit(1)
+	}
+	merged, conflicts := MergeManifests(manifests)
+	for _, conflict := range conflicts {
+		fmt.Fprintf(os.Stderr, "Conflict: %s recorded with hash %s and %s, keeping %s\n", conflict.Path, conflict.Kept.Hash, conflict.Dropped.Hash, conflict.Kept.Hash)
+	}
+
+	if opts.ManifestOut == "" {
+		if err := WriteManifest(os.Stdout, merged); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing merged manifest: %v\n", err)
+			exit(1)
+		}
+	} else if err := writeDirectoryInfoToYAML(merged, opts.ManifestOut, opts.CompressLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing merged manifest: %v\n", err)
+		exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Merged %s entries from %s manifests into %s (%s conflicts).\n",
+		FormatCount(inputFiles), FormatCount(len(manifests)), FormatCount(len(merged.Files)), FormatCount(len(conflicts)))
+}
+
+// runMergeReports combines the -output json reports of shards of a target into one report
+func runMergeReports(opts *options) {
+	if len(opts.MergeReports) == 0 {
+		fmt.Fprintln(os.Stderr, "At least one -mergeReports report must be provided to merge")
+		exit(1)
+	}
+	var reports []Report
+	reported := 0
+	for _, path := range opts.MergeReports {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening report: %v\n", err)
+			exit(1)
+		}
+		report, err := ReadReport(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading report %s: %v\n", path, err)
+			exit(1)
+		}
+		reports = append(reports, report)
+		reported += len(report.Duplicates)
+	}
+
+	merged := MergeReports(reports)
+	if opts.SortBy != "" {
+		SortDuplicates(merged.Duplicates, opts.SortBy)
+	}
+	if len(opts.Outputs) == 0 {
+		opts.Outputs = stringList{"text"}
+	}
+	writeOutputs(opts, func(sink ResultSink) error { return SendReport(sink, merged) })
+	fmt.Fprintf(os.Stderr, "Merged %s duplicates from %s reports into %s.\n",
+		FormatCount(reported), FormatCount(len(reports)), FormatCount(len(merged.Duplicates)))
+}
+
+// runProbe hashes a deterministic sample of the reference and target directories and
+// extrapolates how many target files are duplicates, as a quick go/no-go before a full scan
+func runProbe(opts *options) {
+	if opts.RefDir == "" || opts.TargetDir == "" {
+		fmt.Fprintln(os.Stderr, "Reference and target directory paths must be provided to probe")
+		exit(1)
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 100 {
+		fmt.Fprintln(os.Stderr, "Sample rate must be a percentage between 0 and 100")
+		exit(1)
+	}
+	rate := opts.SampleRate / 100
+
+	refSampler := &Sampler{Rate: rate, Seed: opts.Seed, ExactPathMatch: opts.ExactPathMatch}
+	refDirInfo := loadDirectoryInfo(opts, "reference", opts.RefDir, "", "", WalkOptions{
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		FollowSymlinks:     opts.FollowSymlinksRef,
+		Filter:             refSampler.Filter(opts.RefDir),
+	})
+	targetSampler := &Sampler{Rate: rate, Seed: opts.Seed, ExactPathMatch: opts.ExactPathMatch}
+	targetDirInfo := loadDirectoryInfo(opts, "target", opts.TargetDir, "", "", WalkOptions{
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		FollowSymlinks:     opts.FollowSymlinksTarget,
+		Filter:             targetSampler.Filter(opts.TargetDir),
+	})
+
+	duplicates := CompareFilesWithOptions(refDirInfo, targetDirInfo, CompareOptions{
+		ExactPathMatch:      opts.ExactPathMatch,
+		ExcludeSameFile:     opts.ExcludeSameDir,
+		NormalizeRefPath:    opts.NormalizeRefPath,
+		NormalizeTargetPath: opts.NormalizeTargetPath,
+	})
+	estimate := EstimateDuplicates(duplicates, rate)
+	targetFiles, targetBytes := targetSampler.Totals()
+
+	fmt.Printf("Sampled %s of %s target files (%g%%, seed %d).\n", FormatCount(len(targetDirInfo.Files)), FormatCount(targetFiles), opts.SampleRate, opts.Seed)
+	fmt.Printf("Found %s duplicates (%s) in the sample.\n", FormatCount(estimate.SampledDuplicates), FormatBytes(estimate.SampledBytes, opts.SI))
+	fmt.Printf("Estimated duplicates: %s ± %s of %s target files\n", FormatCount(int(math.Round(estimate.Duplicates))), FormatCount(int(math.Round(estimate.DuplicatesMargin))), FormatCount(targetFiles))
+	fmt.Printf("Estimated reclaimable space: %s of %s\n", FormatBytes(int64(estimate.Bytes), opts.SI), FormatBytes(targetBytes, opts.SI))
+	fmt.Println("This is an extrapolation with an approximate 95% interval; small samples or a few huge files can skew it. Run a full scan before deleting anything.")
+}
+
+// runDedup finds target files duplicating reference files, then plans, deletes or consolidates them
+func runDedup(opts *options) {
+	if len(opts.RefYamls) > 1 {
+		runDedupMultiple(opts)
+		return
+	}
+	// neither reads file content, so there are no hashes to act on
+	statOnly := opts.MetaOnly || opts.CompareSizesOnly
+	if statOnly && (opts.DeleteFiles || opts.ScriptOut != "" || opts.GroupOutput != "" || opts.ConsolidateTo != "" || opts.ShowConflicts) {
+		fmt.Fprintln(os.Stderr, "-metaOnly and -compareSizesOnly results are unverified and cannot be combined with -deleteFiles, -scriptOut, -groupOutput, -consolidateTo or -showConflicts")
+		exit(1)
+	}
+	// edge hashes only stand in for full hashes after -confirm
+	if opts.EdgesOnly && (opts.ConsolidateTo != "" || opts.ShowConflicts || opts.FindTruncated || opts.TimeWindow > 0 || opts.Explain != "" ||
+		(!opts.Confirm && (opts.DeleteFiles || opts.ScriptOut != "" || opts.GroupOutput != ""))) {
+		fmt.Fprintln(os.Stderr, "-edgesOnly cannot be combined with -consolidateTo, -showConflicts, -findTruncated, -dedupByTimeWindow or -explain, nor with -deleteFiles, -scriptOut or -groupOutput unless -confirm is given")
+		exit(1)
+	}
+	// a name and a size are not even a hash, and mean nothing to the content-based reports
+	if opts.NameAndSize && (opts.ConsolidateTo != "" || opts.ShowConflicts || opts.FindTruncated || opts.TimeWindow > 0 || opts.Explain != "" || opts.ShowRenames ||
+		opts.TopByCount > 0 || opts.ResultCache != "" || (!opts.Confirm && (opts.DeleteFiles || opts.ScriptOut != "" || opts.GroupOutput != ""))) {
+		fmt.Fprintln(os.Stderr, "-dedupByNameAndSize cannot be combined with -consolidateTo, -showConflicts, -findTruncated, -dedupByTimeWindow, -explain, -dedupAcrossRenames, -topDuplicatesByCount or -dedupResultCache, nor with -deleteFiles, -scriptOut or -groupOutput unless -confirm is given")
+		exit(1)
+	}
+
+	var edgeBlockSize int64
+	if opts.EdgesOnly {
+		edgeBlockSize = opts.EdgeBlockSize
+	}
+	compareOpts := CompareOptions{
+		ExactPathMatch:      opts.ExactPathMatch,
+		ExcludeSameFile:     opts.ExcludeSameDir,
+		CompareXattrs:       opts.CompareXattrs,
+		UseBodyHash:         !opts.Body.IsZero(),
+		MetaOnly:            opts.MetaOnly,
+		UseEdgeHash:         opts.EdgesOnly,
+		NameAndSize:         opts.NameAndSize,
+		RequireModTimeMatch: opts.RequireModTimeMatch,
+		ModTimeTolerance:    opts.ModTimeTolerance,
+		EmptyFilesSamePath:  opts.EmptyFilesSamePath,
+		NormalizeRefPath:    opts.NormalizeRefPath,
+		NormalizeTargetPath: opts.NormalizeTargetPath,
+	}
+
+	refWalkOpts := WalkOptions{
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		FollowSymlinks:     opts.FollowSymlinksRef,
+		CaptureXattrs:      opts.CompareXattrs,
+		Body:               opts.Body,
+		MetaOnly:           statOnly || opts.NameAndSize,
+		EdgeBlockSize:      edgeBlockSize,
+	}
+	targetWalkOpts := WalkOptions{
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		// a manifest without hashes is of no use later, and machine-readable output on stdout must stay parseable
+		OutputYamlToStdout: !statOnly && !opts.EdgesOnly && !opts.NameAndSize && opts.Explain == "" && !machineOutputOnStdout(opts),
+		FollowSymlinks:     opts.FollowSymlinksTarget,
+		CaptureXattrs:      opts.CompareXattrs,
+		CaptureInodes:      true, // link counts keep hardlinks from being counted as reclaimable more than once
+		InodesInMemory:     !opts.PreserveHardlinks,
+		Owner:              opts.Owner,
+		Body:               opts.Body,
+		MetaOnly:           statOnly || opts.NameAndSize,
+		EdgeBlockSize:      edgeBlockSize,
+	}
+	// Run checks the hashes before walking the target; sizes compare without hashes, so there are none to check
+	runCompareOpts := compareOpts
+	if opts.CompareSizesOnly {
+		runCompareOpts.MetaOnly = true
+	}
+	// Without deletion or a script to write, print the plan live as duplicates are found
+	var plan *planPrinter
+	if printsPlanLive(opts) {
+		plan = newPlanPrinter(opts)
+		runCompareOpts.Hooks = &Hooks{OnDuplicateFound: func(file FileInfo, refPath string) {
+			plan.print(Duplicate{File: file, RefPath: refPath})
+		}}
+	}
+	compared := false
+	result, err := Run(Options{
+		TargetYaml: opts.TargetYaml,
+		RefWalk:    refWalkOpts,
+		TargetWalk: targetWalkOpts,
+		Compare:    runCompareOpts,
+		LoadRef: func(walkOpts WalkOptions) (*DirectoryInfo, error) {
+			refDirInfo := loadDirectoryInfo(opts, "reference", opts.RefDir, opts.RefYaml, opts.RefIndex, walkOpts)
+			writeReferenceIndex(refDirInfo, opts)
+			return refDirInfo, nil
+		},
+		LoadTarget: func(walkOpts WalkOptions) (*DirectoryInfo, error) {
+			return loadTargetDirectoryInfo(opts, walkOpts), nil
+		},
+		BeforeCompare: func(refDirInfo, targetDirInfo *DirectoryInfo) bool {
+			compared = reportLoadedTrees(refDirInfo, targetDirInfo, compareOpts, opts)
+			return compared
+		},
+	})
+	if err != nil {
+		// the loaders exit on their own errors, so this is the hash check
+		fmt.Fprintf(os.Stderr, "Cannot compare the reference and target hashes: %v. Hash both with the same options.\n", err)
+		exit(1)
+	}
+	if !compared {
+		return
+	}
+	if plan != nil {
+		plan.finish(opts)
+		return
+	}
+	handleDuplicates(result.Duplicates, result.Ref, result.Target, opts)
+}
 
-	flag.Parse()
+// printsPlanLive tells whether runDedup prints the plan as duplicates are found, which it can unless it is to
+// delete, write a script or output, or sort the duplicates first
+func printsPlanLive(opts *options) bool {
+	return !opts.DeleteFiles && opts.ScriptOut == "" && !opts.SummaryOnly && !opts.Paranoid && !opts.PreserveHardlinks && opts.GroupOutput == "" && len(opts.Outputs) == 0 && opts.SortBy == ""
+}
 
-	// Read or compute directory info for reference directory
-	var refDirInfo *DirectoryInfo
-	var err error
+// reportLoadedTrees does everything runDedup does with the loaded trees before, or instead of, the plain
+// comparison. It returns whether that comparison is still to be done.
+func reportLoadedTrees(refDirInfo, targetDirInfo *DirectoryInfo, compareOpts CompareOptions, opts *options) bool {
+	if opts.CompareSizesOnly {
+		printSizeEstimate(EstimateBySize(refDirInfo, targetDirInfo), targetDirInfo, opts)
+		return false
+	}
 
-	if *refYamlPath != "" {
-		refDirInfo, err = readDirectoryInfoFromYAML(*refYamlPath)
+	if opts.ConsolidateTo != "" {
+		result, err := ConsolidateDirectories(opts.ConsolidateTo, refDirInfo, targetDirInfo)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading reference YAML: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error consolidating directories: %v\n", err)
+			exit(1)
 		}
-	} else if *refDirPath != "" {
-		refDirInfo, err = WalkDirectory(*refDirPath, *parallelism, *targetDirPath == "")
+		fmt.Printf("Copied %s unique files to %s (%s duplicates skipped, %s renamed on path collision).\n", FormatCount(result.Copied), opts.ConsolidateTo, FormatCount(result.Skipped), FormatCount(result.Renamed))
+		return false
+	}
+
+	if opts.TimeWindow > 0 {
+		printRelatedByTimeWindow(append(append([]FileInfo(nil), refDirInfo.Files...), targetDirInfo.Files...), opts.TimeWindow)
+	}
+
+	if opts.ShowConflicts {
+		printConflicts(FindConflicts(refDirInfo, targetDirInfo), opts)
+	}
+
+	if opts.FindTruncated {
+		truncated, err := FindTruncatedFiles(append(append([]FileInfo(nil), refDirInfo.Files...), targetDirInfo.Files...), opts.TruncatedMinSize)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error walking reference directory: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error looking for truncated files: %v\n", err)
+			exit(1)
 		}
-	} else {
-		fmt.Fprintln(os.Stderr, "Reference directory path or YAML file must be provided")
-		os.Exit(1)
+		printTruncatedFiles(truncated, opts)
 	}
 
-	// If no target directory is given, output the reference directory info as YAML
-	if *targetDirPath == "" && *targetYamlPath == "" {
+	if opts.AudioFingerprint {
+		printSimilarAudio(refDirInfo.Files, targetDirInfo.Files, false, opts)
+	}
 
-		if *refDirPath != "" {
-			// deletion candidate:
-			// if we always stream output to stdout, we can remove this block
-			// err := writeDirectoryInfoToYAML(refDirInfo, os.Stdout)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing reference directory info to YAML: %v\n", err)
-				os.Exit(1)
-			}
-		} else {
-			fmt.Println("Validating reference directory against yaml...")
-			refFileMap := GetFileMapFromDirectoryInfo(refDirInfo, *exactPathMatch)
+	if opts.TopByCount > 0 {
+		printTopByCount(TopDuplicatesByCount(append(append([]FileInfo(nil), refDirInfo.Files...), targetDirInfo.Files...), opts.TopByCount), opts)
+	}
 
-			// validate reference directory against the yaml
-			currentRefDirInfo, err := WalkDirectory(refDirInfo.BaseDir, *parallelism, false)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error walking reference directory: %v\n", err)
-				os.Exit(1)
-			}
-			for _, file := range currentRefDirInfo.Files {
-				yamlEntry, ok := refFileMap[file.Hash]
-				if !ok {
-					fmt.Fprintf(os.Stderr, "File %s not found in reference directory\n", file.Path)
-					os.Exit(1)
-				} else {
-					fmt.Printf("File %s found in reference directory: %v\n", file.Path, yamlEntry)
-				}
+	if opts.Explain != "" {
+		printExplanation(ExplainMatch(refDirInfo, targetDirInfo, opts.Explain, compareOpts))
+		return false
+	}
+
+	if opts.ShowRenames {
+		printRenames(FindRenames(refDirInfo, targetDirInfo, compareOpts))
+	}
+
+	if opts.MetaOnly {
+		printMetaOnlyMatches(refDirInfo, targetDirInfo, compareOpts, opts)
+		return false
+	}
+
+	if opts.EdgesOnly || opts.NameAndSize {
+		var candidates []Duplicate
+		for duplicate := range CompareFilesStream(refDirInfo, targetDirInfo, compareOpts) {
+			candidates = append(candidates, duplicate)
+		}
+		if opts.SortBy != "" {
+			SortDuplicates(candidates, opts.SortBy)
+		}
+		kind := "edge"
+		heading := fmt.Sprintf("HEURISTIC: files with the same size, name and first and last %s, the rest of their content was not read.", FormatBytes(opts.EdgeBlockSize, opts.SI))
+		if opts.NameAndSize {
+			kind = "name and size"
+			heading = "UNVERIFIED: files with the same name and size, file content was not read."
+		}
+		if !opts.Confirm {
+			printCandidateMatches(candidates, targetDirInfo, opts, heading, kind+" match")
+			return false
+		}
+		duplicates := ConfirmMatches(candidates, func(path string, err error) {
+			fmt.Fprintf(os.Stderr, "Warning: cannot confirm %s: %v\n", path, err)
+		})
+		fmt.Fprintf(os.Stderr, "Confirmed %s of %s %s matches by full hash.\n", FormatCount(len(duplicates)), FormatCount(len(candidates)), kind)
+		if printsPlanLive(opts) {
+			printDeletionPlan(duplicates, opts)
+			return false
+		}
+		handleDuplicates(duplicates, refDirInfo, targetDirInfo, opts)
+		return false
+	}
+
+	if opts.ResultCache != "" {
+		duplicates := compareWithResultCache(refDirInfo, targetDirInfo, compareOpts, opts)
+		if printsPlanLive(opts) {
+			printDeletionPlan(duplicates, opts)
+			return false
+		}
+		handleDuplicates(duplicates, refDirInfo, targetDirInfo, opts)
+		return false
+	}
+	return true
+}
+
+// runDedupMultiple is runDedup against several reference manifests, combined by -matchPolicy.
+// It reports which manifests have each duplicate before the usual plan or deletion.
+func runDedupMultiple(opts *options) {
+	if opts.RefDir != "" || opts.RefIndex != "" || opts.ConsolidateTo != "" || opts.ShowConflicts || opts.ShowRenames || opts.FindTruncated ||
+		opts.TimeWindow > 0 || opts.AudioFingerprint || opts.TopByCount > 0 || opts.Explain != "" || opts.MetaOnly || opts.EdgesOnly || opts.NameAndSize || opts.CompareSizesOnly || opts.ResultCache != "" {
+		fmt.Fprintln(os.Stderr, "Several -refYaml cannot be combined with -refDir, -refIndex, -consolidateTo, -showConflicts, -dedupAcrossRenames, -findTruncated, -dedupByTimeWindow, -dedupByAudioFingerprint, -topDuplicatesByCount, -explain, -metaOnly, -edgesOnly, -dedupByNameAndSize, -compareSizesOnly or -dedupResultCache")
+		exit(1)
+	}
+
+	var refs []*DirectoryInfo
+	for _, path := range opts.RefYamls {
+		refs = append(refs, loadDirectoryInfo(opts, "reference", "", path, "", WalkOptions{}))
+	}
+	targetDirInfo := loadTargetDirectoryInfo(opts, WalkOptions{
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		FollowSymlinks:     opts.FollowSymlinksTarget,
+		CaptureXattrs:      opts.CompareXattrs,
+		CaptureInodes:      true,
+		Owner:              opts.Owner,
+		Body:               opts.Body,
+	})
+
+	compareOpts := CompareOptions{
+		ExactPathMatch:      opts.ExactPathMatch,
+		ExcludeSameFile:     opts.ExcludeSameDir,
+		CompareXattrs:       opts.CompareXattrs,
+		UseBodyHash:         !opts.Body.IsZero(),
+		RequireModTimeMatch: opts.RequireModTimeMatch,
+		ModTimeTolerance:    opts.ModTimeTolerance,
+		EmptyFilesSamePath:  opts.EmptyFilesSamePath,
+		NormalizeRefPath:    opts.NormalizeRefPath,
+		NormalizeTargetPath: opts.NormalizeTargetPath,
+	}
+	for _, ref := range refs {
+		requireComparableHashes(ref.HashSpec, targetDirInfo.HashSpec, compareOpts)
+	}
+	multiDuplicates := CompareAgainstReferences(refs, targetDirInfo, compareOpts, opts.MatchPolicy)
+
+	duplicates := make([]Duplicate, len(multiDuplicates))
+	for i, duplicate := range multiDuplicates {
+		duplicates[i] = duplicate.Duplicate
+		if machineOutputOnStdout(opts) {
+			continue
+		}
+		var matched []string
+		for j, refPath := range duplicate.Matches {
+			if refPath != "" {
+				matched = append(matched, opts.RefYamls[j])
 			}
 		}
+		fmt.Printf("# %s is in %d of %d manifests: %s\n", duplicate.File.Path, len(matched), len(refs), strings.Join(matched, ", "))
+	}
+	handleDuplicates(duplicates, refs[0], targetDirInfo, opts)
+}
 
+// runSelf finds groups of identical files within the target directory and plans or deletes all but one keeper per group
+func runSelf(opts *options) {
+	targetDirInfo := loadTargetDirectoryInfo(opts, WalkOptions{
+		HashWorkers:        opts.HashWorkers,
+		WalkWorkers:        opts.WalkWorkers,
+		Hash:               opts.Hash,
+		InlineContentBelow: opts.InlineContentBelow,
+		WarnSpecialFiles:   opts.WarnSpecialFiles,
+		FollowSymlinks:     opts.FollowSymlinksTarget,
+		CaptureXattrs:      opts.CompareXattrs,
+		CaptureInodes:      true,
+		Owner:              opts.Owner,
+		Body:               opts.Body,
+		MetaOnly:           opts.CompareSizesOnly,
+	})
+
+	if opts.CompareSizesOnly {
+		printSizeEstimate(EstimateSelfBySize(targetDirInfo), targetDirInfo, opts)
 		return
 	}
 
-	// Read or compute directory info for target directory
-	var targetDirInfo *DirectoryInfo
+	if opts.AudioFingerprint {
+		printSimilarAudio(nil, targetDirInfo.Files, true, opts)
+	}
+
+	if opts.TopByCount > 0 {
+		printTopByCount(TopDuplicatesByCount(targetDirInfo.Files, opts.TopByCount), opts)
+	}
 
-	if *targetYamlPath != "" {
-		targetDirInfo, err = readDirectoryInfoFromYAML(*targetYamlPath)
+	groupOpts := CompareOptions{UseBodyHash: !opts.Body.IsZero(), CompareXattrs: opts.CompareXattrs}
+	groups := FindDuplicateGroups(targetDirInfo, groupOpts)
+	if opts.ReportDelta != "" {
+		earlier, err := readDirectoryInfoFromYAML(opts.ReportDelta)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading target YAML: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error reading earlier manifest: %v\n", err)
+			exit(1)
 		}
-	} else if *targetDirPath != "" {
-		targetDirInfo, err = WalkDirectory(*targetDirPath, *parallelism, true)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error walking target directory: %v\n", err)
-			os.Exit(1)
+		printGroupDelta(DiffDuplicateGroups(FindDuplicateGroups(earlier, groupOpts), groups), opts)
+		return
+	}
+	policy := KeeperPolicy{KeepPatterns: opts.KeepPatterns, DeletePatterns: opts.DeletePatterns, PreferMostLinks: opts.PreferFewerLinks}
+	duplicates := SelfDuplicates(groups, policy, func(group DuplicateGroup, warning string) {
+		fmt.Fprintf(os.Stderr, "Warning: no clear keeper among %s files with hash %s: %s\n", FormatCount(len(group.Files)), group.Hash, warning)
+	})
+	handleDuplicates(duplicates, targetDirInfo, targetDirInfo, opts)
+}
+
+// printGroupDelta prints the duplicate groups that changed between two runs
+func printGroupDelta(delta GroupDelta, opts *options) {
+	fmt.Printf("%s new, %s resolved and %s grown duplicate groups.\n", FormatCount(len(delta.New)), FormatCount(len(delta.Resolved)), FormatCount(len(delta.Grown)))
+	for _, group := range delta.New {
+		fmt.Printf("new: %s copies of %s (%s each)\n", FormatCount(len(group.Files)), group.Hash, FormatBytes(group.Files[0].Size, opts.SI))
+		for _, file := range group.Files {
+			fmt.Printf("  %s\n", file.Path)
+		}
+	}
+	for _, group := range delta.Grown {
+		fmt.Printf("grown: %s to %s copies of %s (%s each)\n", FormatCount(len(group.Before.Files)), FormatCount(len(group.After.Files)), group.After.Hash, FormatBytes(group.After.Files[0].Size, opts.SI))
+		for _, file := range group.Added {
+			fmt.Printf("  + %s\n", file.Path)
 		}
 	}
+	for _, group := range delta.Resolved {
+		fmt.Printf("resolved: %s copies of %s\n", FormatCount(len(group.Files)), group.Hash)
+	}
+}
+
+// compareWithResultCache returns the duplicates stored in -dedupResultCache if they were found for the same
+// trees and options, and otherwise compares and stores the result for the next run
+func compareWithResultCache(refDirInfo *DirectoryInfo, targetDirInfo *DirectoryInfo, compareOpts CompareOptions, opts *options) []Duplicate {
+	key, err := ComparisonKey(refDirInfo, targetDirInfo, compareOpts, opts.normalizeSpecs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error digesting the comparison inputs: %v\n", err)
+		exit(1)
+	}
+	duplicates, hit, err := LoadCachedResult(opts.ResultCache, key)
+	if err != nil {
+		// a broken cache only costs a comparison
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if hit {
+		fmt.Fprintf(os.Stderr, "Reusing the duplicates in %s, neither tree nor the options changed.\n", opts.ResultCache)
+		return duplicates
+	}
+	duplicates = CompareDirectories(refDirInfo, targetDirInfo, compareOpts).Duplicates
+	if err := StoreCachedResult(opts.ResultCache, key, duplicates); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot write result cache: %v\n", err)
+	}
+	return duplicates
+}
 
-	// Compare files
-	duplicates := CompareFiles(refDirInfo, targetDirInfo, *exactPathMatch)
+// handleDuplicates deletes the duplicates after confirmation if -deleteFiles is set, otherwise outputs the deletion plan
+func handleDuplicates(duplicates []Duplicate, refDirInfo *DirectoryInfo, targetDirInfo *DirectoryInfo, opts *options) {
+	if opts.Paranoid {
+		var mismatches []ContentMismatch
+		duplicates, mismatches = VerifyDuplicateContent(duplicates)
+		reportContentMismatches(mismatches)
+	}
+	if opts.PreserveHardlinks {
+		var groups []HardlinkGroup
+		duplicates, groups = PreserveHardlinkGroups(duplicates, targetDirInfo)
+		reportHardlinkGroups(groups)
+	}
+	if opts.SortBy != "" {
+		SortDuplicates(duplicates, opts.SortBy)
+	}
+	files := duplicateFiles(duplicates)
+	if len(opts.Outputs) > 0 {
+		writeOutputs(opts, func(sink ResultSink) error {
+			return SendResults(sink, duplicates, targetDirInfo, opts.walkErrors, opts.busyFiles)
+		})
+		if !opts.DeleteFiles {
+			return
+		}
+	}
+	if opts.GroupOutput != "" {
+		writeGroupOutput(duplicates, opts.GroupOutput)
+		if opts.GroupOutput == "-" && !opts.DeleteFiles {
+			return
+		}
+	}
 
 	// Handle deletion flag
-	if *deleteFiles {
-		fmt.Printf("A total of %d duplicate files found.\n", len(duplicates))
-		fmt.Print("Are you sure you want to delete the files? Type 'yes' to confirm: ")
-		reader := bufio.NewReader(os.Stdin)
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimSpace(input)
-
-		if input == "yes" {
-			err = DeleteFiles(duplicates)
+	if opts.DeleteFiles {
+		// fail before asking rather than with an error per file after the confirmation
+		if err := CheckDeletable(files); err != nil {
+			var readOnly *ReadOnlyError
+			if errors.As(err, &readOnly) {
+				fmt.Fprintf(os.Stderr, "Target %s is read-only, nothing will be deleted.\n", readOnly.Dir)
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %v; nothing will be deleted.\n", err)
+			}
+			exit(1)
+		}
+
+		fmt.Printf("A total of %s duplicate files found (%s).\n", FormatCount(len(files)), FormatBytes(FreedBytes(files), opts.SI))
+		deleteOpts := DeleteOptions{
+			Confirm:   PromptConfirmer(os.Stdin, os.Stdout),
+			BatchSize: opts.DeleteBatchSize,
+			Pause:     opts.DeletePause,
+		}
+		if opts.DeleteBatchSize > 0 {
+			deleteOpts.Progress = func(deleted, total int) {
+				fmt.Printf("Deleted %s of %s files.\n", FormatCount(deleted), FormatCount(total))
+			}
+		}
+		changed := 0
+		if opts.RehashOnMatch {
+			refPaths := make(map[string]string, len(duplicates))
+			for _, duplicate := range duplicates {
+				refPaths[duplicate.File.Path] = duplicate.RefPath
+			}
+			deleteOpts.Verify = RehashVerifier(refPaths, opts.Body)
+			deleteOpts.Skipped = func(file FileInfo, err error) {
+				fmt.Fprintf(os.Stderr, "Warning: not deleting %s: %v\n", file.Path, err)
+				changed++
+			}
+		}
+		if opts.SkipBusy {
+			verify, skipped := deleteOpts.Verify, deleteOpts.Skipped
+			deleteOpts.Verify = func(file FileInfo) error {
+				if busy, _ := FileBusy(file.Path); busy {
+					return ErrFileBusy
+				}
+				if verify != nil {
+					return verify(file)
+				}
+				return nil
+			}
+			deleteOpts.Skipped = func(file FileInfo, err error) {
+				if errors.Is(err, ErrFileBusy) {
+					opts.busyFiles = append(opts.busyFiles, file.Path)
+					return
+				}
+				if skipped != nil {
+					skipped(file, err)
+				}
+			}
+		}
+		hookFailures := 0
+		if opts.AfterDelete != "" {
+			hook := AfterDeleteCommand{Command: opts.AfterDelete, PerBatch: opts.AfterDeletePerBatch}
+			deleteOpts.AfterBatch = func(batch []FileInfo) error {
+				err := hook.Run(batch)
+				if err == nil || opts.AfterDeleteFailFast {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				hookFailures++
+				return nil
+			}
+		}
+		var txLog *TransactionLog
+		if opts.TransactionLog != "" {
+			txLog = openTransactionLog(opts.TransactionLog)
+			// log the intents once approved, and delete nothing if that fails
+			confirm, skipped := deleteOpts.Confirm, deleteOpts.Skipped
+			deleteOpts.Confirm = func(files []FileInfo) (bool, error) {
+				approved, err := confirm(files)
+				if err != nil || !approved {
+					return approved, err
+				}
+				if err := txLog.Begin(duplicates); err != nil {
+					return false, fmt.Errorf("writing transaction log: %w", err)
+				}
+				return true, nil
+			}
+			deleteOpts.Removed = txLog.Done
+			deleteOpts.Skipped = func(file FileInfo, err error) {
+				txLog.Skipped(file, err)
+				if skipped != nil {
+					skipped(file, err)
+				}
+			}
+		}
+		deleted, err := ConfirmAndDelete(files, deleteOpts)
+		if txLog != nil && deleted {
+			finish := txLog.Commit
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error deleting files: %v\n", err)
-				os.Exit(1)
+				finish = func() error { return txLog.Abort(err) }
+			}
+			if err := finish(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing transaction log: %v\n", err)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error deleting files: %v\n", err)
+			exit(1)
+		}
+		if changed > 0 {
+			fmt.Fprintf(os.Stderr, "Kept %s files that failed the -rehashOnMatch check, see the warnings above.\n", FormatCount(changed))
+		}
+		if hookFailures > 0 {
+			fmt.Fprintf(os.Stderr, "The -afterDelete command failed in %s deletion batches, see the warnings above.\n", FormatCount(hookFailures))
+		}
+
+		if deleted {
+			if opts.PruneEmptyDirs {
+				pruned, err := PruneEmptyDirs(targetDirInfo.BaseDir, files, opts.PruneAllEmptyDirs)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error pruning empty directories: %v\n", err)
+					exit(1)
+				}
+				fmt.Printf("Pruned %s empty directories.\n", FormatCount(pruned))
 			}
 		} else {
 			fmt.Println("File deletion aborted.")
-			printDeletionPlan(duplicates, refDirInfo, targetDirInfo)
+			outputDeletionPlan(duplicates, refDirInfo, targetDirInfo, opts)
+		}
+	} else {
+		outputDeletionPlan(duplicates, refDirInfo, targetDirInfo, opts)
+	}
+}
+
+// writeOutputs lets send write the results to every -output sink
+func writeOutputs(opts *options, send func(sink ResultSink) error) {
+	var sinks MultiSink
+	var files []*os.File
+	for _, spec := range opts.Outputs {
+		format, path, _ := strings.Cut(spec, ":")
+		out := os.Stdout
+		if path != "" && path != "-" {
+			file, err := os.Create(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating output: %v\n", err)
+				exit(1)
+			}
+			files = append(files, file)
+			out = file
+		}
+		sink, err := NewResultSink(format, out, opts.SI)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -output %s: %v\n", spec, err)
+			exit(1)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	err := send(sinks)
+	for _, file := range files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
 		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+		exit(1)
+	}
+}
+
+// machineOutputOnStdout tells whether -groupOutput or an -output writes to stdout, which must then carry nothing else
+func machineOutputOnStdout(opts *options) bool {
+	if opts.GroupOutput == "-" {
+		return true
+	}
+	for _, spec := range opts.Outputs {
+		if _, path, _ := strings.Cut(spec, ":"); path == "" || path == "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeGroupOutput writes the clusters of duplicates as JSON to path, or to stdout if path is -
+func writeGroupOutput(duplicates []Duplicate, path string) {
+	clusters := ClustersFromDuplicates(duplicates)
+	if path == "-" {
+		if err := WriteClusters(os.Stdout, clusters); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing duplicate clusters: %v\n", err)
+			exit(1)
+		}
+		return
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating duplicate clusters file: %v\n", err)
+		exit(1)
+	}
+	if err := WriteClusters(out, clusters); err != nil {
+		out.Close()
+		fmt.Fprintf(os.Stderr, "Error writing duplicate clusters: %v\n", err)
+		exit(1)
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing duplicate clusters: %v\n", err)
+		exit(1)
+	}
+}
+
+// reportHardlinkGroups lists the hardlink sets -preserveHardlinkGroups found on stderr
+func reportHardlinkGroups(groups []HardlinkGroup) {
+	for _, group := range groups {
+		action := "kept, not every path is a duplicate"
+		if group.Scheduled {
+			action = "deleted together"
+		}
+		fmt.Fprintf(os.Stderr, "Hardlink group of %s paths (%s):\n", FormatCount(len(group.Files)), action)
+		for _, file := range group.Files {
+			fmt.Fprintf(os.Stderr, "  %s\n", file.Path)
+		}
+	}
+}
+
+// reportContentMismatches warns on stderr about the groups -paranoid left out
+func reportContentMismatches(mismatches []ContentMismatch) {
+	for _, mismatch := range mismatches {
+		if mismatch.Err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not verify the %s duplicates of %s, leaving them out: %v\n", FormatCount(len(mismatch.Files)), mismatch.RefPath, mismatch.Err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "ANOMALY: hashes match but content differs from %s, leaving out all %s of its duplicates:\n", mismatch.RefPath, FormatCount(len(mismatch.Files)))
+		for _, path := range mismatch.Differing {
+			fmt.Fprintf(os.Stderr, "  %s\n", path)
+		}
+	}
+	if len(mismatches) > 0 {
+		fmt.Fprintf(os.Stderr, "%s duplicate groups failed content verification and will not be acted upon.\n", FormatCount(len(mismatches)))
+	}
+}
+
+// duplicateFiles returns the target files of duplicates
+func duplicateFiles(duplicates []Duplicate) []FileInfo {
+	files := make([]FileInfo, len(duplicates))
+	for i, duplicate := range duplicates {
+		files[i] = duplicate.File
+	}
+	return files
+}
+
+// outputDeletionPlan writes the plan to the -scriptOut path if given, otherwise prints it (or just its totals) to stdout
+func outputDeletionPlan(duplicates []Duplicate, refDir *DirectoryInfo, targetDir *DirectoryInfo, opts *options) {
+	scriptPath := opts.ScriptOut
+	if scriptPath == "" {
+		if opts.SummaryOnly {
+			printDeletionSummary(duplicateFiles(duplicates), targetDir, opts)
+		} else {
+			printDeletionPlan(duplicates, opts)
+		}
+		return
+	}
+	if err := WriteDeletionScript(scriptPath, duplicates, refDir, targetDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing deletion script: %v\n", err)
+		exit(1)
+	}
+	fmt.Printf("Deletion plan for %s files written to %s\n", FormatCount(len(duplicates)), scriptPath)
+}
+
+func printDeletionPlan(duplicates []Duplicate, opts *options) {
+	plan := newPlanPrinter(opts)
+	for _, duplicate := range duplicates {
+		plan.print(duplicate)
+	}
+	plan.finish(opts)
+}
+
+// planPrinter prints deletion plan lines up to limit (0 for no limit) while totalling every duplicate.
+// With preview set, the first line for each reference file is preceded by a preview of its content.
+type planPrinter struct {
+	limit     int
+	count     int
+	freed     freedSpace
+	preview   int
+	previewed map[string]bool
+	// reportOnly prints the duplicates as comments, for targets that are not present to be deleted from
+	reportOnly bool
+}
+
+func newPlanPrinter(opts *options) *planPrinter {
+	plan := &planPrinter{limit: opts.MaxReported, reportOnly: opts.ManifestsOnly}
+	if opts.Preview {
+		plan.preview = opts.PreviewBytes
+		plan.previewed = make(map[string]bool)
+	}
+	return plan
+}
+
+func (p *planPrinter) print(duplicate Duplicate) {
+	p.count++
+	p.freed.add(duplicate.File)
+	if p.limit <= 0 || p.count <= p.limit {
+		if p.preview > 0 && !p.previewed[duplicate.RefPath] {
+			p.previewed[duplicate.RefPath] = true
+			printPreview(duplicate, p.preview)
+		}
+		if p.reportOnly {
+			fmt.Printf("# duplicate: %s  # matches: %s\n", duplicate.File.Path, duplicate.RefPath)
+		} else {
+			printDeletionPlanLine(duplicate.File, duplicate.RefPath)
+		}
+	}
+}
+
+// printPreview prints a preview of the content of duplicate's reference file as a shell comment, reading the
+// duplicate itself if the reference file is not accessible, e.g. when it comes from a manifest
+func printPreview(duplicate Duplicate, n int) {
+	keeper := duplicate.File
+	keeper.Path = duplicate.RefPath
+	preview, err := ContentPreview(keeper, n)
+	if err != nil {
+		preview, err = ContentPreview(duplicate.File, n)
+	}
+	if err != nil {
+		fmt.Printf("# %s: no preview: %v\n", duplicate.RefPath, err)
+		return
+	}
+	fmt.Printf("# %s: %s\n", duplicate.RefPath, preview)
+}
+
+// finish prints how many lines were left out and the totals, as shell comments, if the limit was hit
+func (p *planPrinter) finish(opts *options) {
+	if p.reportOnly {
+		if p.limit > 0 && p.count > p.limit {
+			fmt.Printf("# ... and %s more (use -output for the full list)\n", FormatCount(p.count-p.limit))
+		}
+		fmt.Printf("# %s duplicate files in total, %s reclaimable\n", FormatCount(p.count), FormatBytes(p.freed.bytes, opts.SI))
+		fmt.Println("# Compared manifests only: deleting requires a live target, run dedup with -targetDir where the target is.")
+		return
+	}
+	if p.limit <= 0 || p.count <= p.limit {
+		return
+	}
+	fmt.Printf("# ... and %s more (use -scriptOut for the full list)\n", FormatCount(p.count-p.limit))
+	fmt.Printf("# %s duplicate files in total, %s reclaimable\n", FormatCount(p.count), FormatBytes(p.freed.bytes, opts.SI))
+}
+
+func printDeletionSummary(duplicates []FileInfo, targetDir *DirectoryInfo, opts *options) {
+	fmt.Printf("%s of %s target files are duplicates.\n", FormatCount(len(duplicates)), FormatCount(len(targetDir.Files)))
+	fmt.Printf("Reclaimable space: %s\n", FormatBytes(FreedBytes(duplicates), opts.SI))
+	fmt.Printf("Scanned with %d walk workers and %d hash workers.\n", opts.WalkWorkers, opts.HashWorkers)
+}
+
+// printExplanation prints each check of an explanation, colored when stdout is a terminal
+func printExplanation(explanation Explanation) {
+	color := func(code, s string) string { return s }
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		color = func(code, s string) string { return "\x1b[" + code + "m" + s + "\x1b[0m" }
+	}
+
+	fmt.Printf("Explaining %s:\n", explanation.Path)
+	for _, step := range explanation.Steps {
+		status := color("32", "pass")
+		if !step.Passed {
+			status = color("31", "FAIL")
+		}
+		fmt.Printf("  [%s] %s: %s\n", status, step.Check, step.Detail)
+	}
+	if explanation.Duplicate {
+		fmt.Printf("Result: %s, duplicated at %s\n", color("32", "duplicate"), explanation.RefPath)
 	} else {
-		printDeletionPlan(duplicates, refDirInfo, targetDirInfo)
+		fmt.Printf("Result: %s\n", color("31", "not a duplicate"))
+	}
+}
+
+// printSizeEstimate prints a -compareSizesOnly upper bound
+func printSizeEstimate(estimate SizeEstimate, targetDir *DirectoryInfo, opts *options) {
+	fmt.Printf("At most %s of %s target files (%s of %s) can be duplicates, judging by size alone.\n",
+		FormatCount(estimate.Files), FormatCount(len(targetDir.Files)), FormatBytes(estimate.Bytes, opts.SI), FormatBytes(totalSize(targetDir.Files), opts.SI))
+	fmt.Println("This is an upper bound, no file content was read: files of equal size are often different. Run a full scan to find the real duplicates.")
+}
+
+// printMetaOnlyMatches prints -metaOnly matches as shell comments, since they are only candidates for a content pass
+func printMetaOnlyMatches(refDir *DirectoryInfo, targetDir *DirectoryInfo, compareOpts CompareOptions, opts *options) {
+	fmt.Println("# UNVERIFIED: metadata-identical files (same size, modification time and name), file content was not read.")
+	fmt.Println("# Verify before acting, e.g. by running again without -metaOnly.")
+	duplicates := CompareDirectories(refDir, targetDir, compareOpts).Duplicates
+	if opts.SortBy != "" {
+		SortDuplicates(duplicates, opts.SortBy)
+	}
+	files := duplicateFiles(duplicates)
+	if !opts.SummaryOnly {
+		for _, duplicate := range duplicates {
+			fmt.Printf("# metadata-identical: %s  # matches: %s\n", duplicate.File.Path, duplicate.RefPath)
+		}
+	}
+	fmt.Printf("# %s of %s target files are metadata-identical (%s), unverified.\n", FormatCount(len(files)), FormatCount(len(targetDir.Files)), FormatBytes(totalSize(files), opts.SI))
+}
+
+// printCandidateMatches prints -edgesOnly or -dedupByNameAndSize matches as shell comments, since they are only
+// candidates for -confirm. heading says what the candidates have in common, and kind names one of them.
+func printCandidateMatches(candidates []Duplicate, targetDir *DirectoryInfo, opts *options, heading, kind string) {
+	fmt.Printf("# %s\n", heading)
+	fmt.Println("# Verify before acting, e.g. by running again with -confirm.")
+	var files []FileInfo
+	for _, duplicate := range candidates {
+		files = append(files, duplicate.File)
+		if !opts.SummaryOnly {
+			fmt.Printf("# %s: %s  # matches: %s\n", kind, duplicate.File.Path, duplicate.RefPath)
+		}
+	}
+	fmt.Printf("# %s of %s target files are %ses (%s), unverified.\n", FormatCount(len(files)), FormatCount(len(targetDir.Files)), kind, FormatBytes(totalSize(files), opts.SI))
+}
+
+// printRelatedByTimeWindow prints the heuristic clusters as shell comments so the plan stays runnable
+func printRelatedByTimeWindow(files []FileInfo, window time.Duration) {
+	clusters := FindRelatedByTimeWindow(files, window)
+	fmt.Printf("# %s groups of likely related files (same size, modified within %s), for manual review:\n", FormatCount(len(clusters)), window)
+	for _, cluster := range clusters {
+		fmt.Println("#")
+		for _, file := range cluster {
+			fmt.Printf("#   %s  %s  %s\n", file.ModTime.Format(time.RFC3339), file.Hash, file.Path)
+		}
+	}
+}
+
+// printConflicts prints the same-path/different-content pairs as shell comments so the plan stays runnable
+func printConflicts(conflicts []Conflict, opts *options) {
+	fmt.Printf("# %s files differ between reference and target at the same path:\n", FormatCount(len(conflicts)))
+	for _, conflict := range conflicts {
+		newer := ""
+		if side := conflict.Newer(); side != "" {
+			newer = fmt.Sprintf("  (%s is newer)", side)
+		}
+		fmt.Printf("#   %s  ref: %s %s  target: %s %s%s\n", conflict.RelPath,
+			FormatBytes(conflict.Ref.Size, opts.SI), formatModTime(conflict.Ref.ModTime),
+			FormatBytes(conflict.Target.Size, opts.SI), formatModTime(conflict.Target.ModTime), newer)
+	}
+}
+
+// printTruncatedFiles prints the prefix pairs as shell comments so the plan stays runnable
+func printTruncatedFiles(truncated []TruncatedFile, opts *options) {
+	fmt.Printf("# %s files are a prefix of a larger file, possibly partial downloads:\n", FormatCount(len(truncated)))
+	for _, pair := range truncated {
+		fmt.Printf("#   %s (%s) is the start of %s (%s)\n", pair.Partial.Path, FormatBytes(pair.Partial.Size, opts.SI), pair.Complete.Path, FormatBytes(pair.Complete.Size, opts.SI))
 	}
 }
 
-func printDeletionPlan(duplicates []FileInfo, refDir *DirectoryInfo, targetDir *DirectoryInfo) {
-	refFileMap := make(map[string]string)
-	for _, file := range refDir.Files {
-		refFileMap[file.Hash] = file.Path
+// printSimilarAudio prints the groups of probably identical recordings across refFiles and targetFiles, or
+// within targetFiles in selfMode, as shell comments
+func printSimilarAudio(refFiles, targetFiles []FileInfo, selfMode bool, opts *options) {
+	groups := FindSimilarAudio(refFiles, targetFiles, selfMode, FpcalcFingerprinter(opts.Fpcalc), opts.AudioSimilarity, opts.HashWorkers, func(path string, err error) {
+		fmt.Fprintf(os.Stderr, "Warning: cannot fingerprint %s: %v\n", path, err)
+	})
+	fmt.Printf("# %s groups of audio files probably hold the same recording (unverified, review before deleting):\n", FormatCount(len(groups)))
+	for _, group := range groups {
+		fmt.Printf("#   %.0f%% similar:\n", group.Similarity*100)
+		for i, file := range group.Files {
+			duration := time.Duration(group.Durations[i] * float64(time.Second)).Round(time.Second)
+			fmt.Printf("#     %s (%s, %s)\n", file.Path, duration, FormatBytes(file.Size, opts.SI))
+		}
+	}
+}
+
+// printTopByCount prints the -topDuplicatesByCount report as shell comments so the plan stays runnable
+func printTopByCount(counts []ContentCount, opts *options) {
+	fmt.Printf("# Top %s contents by number of copies:\n", FormatCount(len(counts)))
+	for _, count := range counts {
+		fmt.Printf("#   %s copies of %s (%s wasted), e.g. %s\n", FormatCount(count.Count), FormatBytes(count.Size, opts.SI), FormatBytes(int64(count.Count-1)*count.Size, opts.SI), count.SamplePath)
+	}
+}
+
+// printRenames prints the moved files as shell comments so the plan stays runnable
+func printRenames(renames []Rename) {
+	fmt.Printf("# %s files were moved or renamed between reference and target:\n", FormatCount(len(renames)))
+	for _, rename := range renames {
+		fmt.Printf("#   renamed: %s -> %s\n", rename.Ref.Path, rename.Target.Path)
+	}
+}
+
+func formatModTime(modTime time.Time) string {
+	if modTime.IsZero() {
+		return "(unknown mtime)"
+	}
+	return modTime.Format(time.RFC3339)
+}
+
+func printDeletionPlanLine(file FileInfo, refPath string) {
+	(&TextSink{w: os.Stdout}).Duplicate(Duplicate{File: file, RefPath: refPath})
+}
+
+// checkManifestPaths reports files of a loaded manifest that no longer match the disk, exiting if strict
+func checkManifestPaths(dirInfo *DirectoryInfo, manifestPath string, strict bool) {
+	stale, err := VerifyManifestPaths(dirInfo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error verifying manifest %s: %v\n", manifestPath, err)
+		exit(1)
 	}
+	if len(stale) == 0 {
+		return
+	}
+	for _, entry := range stale {
+		fmt.Fprintf(os.Stderr, "Stale file listed in %s: %s (%s)\n", manifestPath, entry.File.Path, entry.Problem)
+	}
+	if strict {
+		fmt.Fprintf(os.Stderr, "Error: %d of %d files listed in %s no longer match the disk\n", len(stale), len(dirInfo.Files), manifestPath)
+		exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %d of %d files listed in %s no longer match the disk\n", len(stale), len(dirInfo.Files), manifestPath)
+}
+
+// maxHashWarnings caps how many malformed hashes checkManifestHashes lists when only warning
+const maxHashWarnings = 10
 
-	for _, file := range duplicates {
-		refPath := refFileMap[file.Hash]
-		fmt.Printf("rm \"%s\"  # duplicated at: %s\n", file.Path, refPath)
+// checkManifestHashes reports malformed hashes in a loaded manifest, exiting on the first one if strict
+func checkManifestHashes(dirInfo *DirectoryInfo, manifestPath string, strict bool) {
+	errs := ValidateManifestHashes(dirInfo)
+	if len(errs) == 0 {
+		return
+	}
+	if strict {
+		fmt.Fprintf(os.Stderr, "Error: malformed hash in %s: %v\n", manifestPath, errs[0])
+		exit(1)
 	}
+	for i, err := range errs {
+		if i == maxHashWarnings {
+			fmt.Fprintf(os.Stderr, "Warning: ... and %s more\n", FormatCount(len(errs)-maxHashWarnings))
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Warning: malformed hash in %s: %v\n", manifestPath, err)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s of %s entries of %s have malformed hashes and will never match (see -strictHashLength)\n",
+		FormatCount(len(errs)), FormatCount(len(dirInfo.Files)), manifestPath)
 }
 
+// readDirectoryInfoFromYAML reads a YAML manifest, decompressing it on the fly if path ends in .zst
 func readDirectoryInfoFromYAML(path string) (*DirectoryInfo, error) {
-	data, err := os.ReadFile(path)
+	r, err := openManifest(path)
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
 
 	var dirInfo DirectoryInfo
-	err = yaml.Unmarshal(data, &dirInfo)
-	if err != nil {
+	err = yaml.NewDecoder(r).Decode(&dirInfo)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := CanonicalizePaths(&dirInfo, false); err != nil {
 		return nil, err
 	}
 
 	return &dirInfo, nil
 }
 
-func writeDirectoryInfoToYAML(dirInfo *DirectoryInfo, writer *os.File) error {
-	data, err := yaml.Marshal(dirInfo)
+// writeDirectoryInfoToYAML writes a YAML manifest to path, zstd compressed at level if path ends in .zst
+func writeDirectoryInfoToYAML(dirInfo *DirectoryInfo, path string, level int) error {
+	w, err := createManifest(path, level)
 	if err != nil {
 		return err
 	}
-
-	_, err = writer.Write(data)
-	return err
+	if err := WriteManifest(w, dirInfo); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
 }
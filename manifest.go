@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// StalePath is a file recorded in a manifest that no longer matches what is at its path
+type StalePath struct {
+	File FileInfo
+	// Problem says what is wrong: the file is missing, its size changed, or its path is outside the base directory
+	Problem string
+}
+
+// VerifyManifestPaths stats every file recorded in dirInfo, without hashing, and returns the ones that no longer
+// exist, whose size differs from the recorded one, or whose path escapes the base directory. Manifests leave out
+// the size of empty files, and older ones any size, so only recorded sizes are compared.
+func VerifyManifestPaths(dirInfo *DirectoryInfo) ([]StalePath, error) {
+	var stale []StalePath
+	for _, file := range dirInfo.Files {
+		if !isStrictlyUnder(file.Path, dirInfo.BaseDir) {
+			stale = append(stale, StalePath{File: file, Problem: fmt.Sprintf("outside %s", dirInfo.BaseDir)})
+			continue
+		}
+		info, err := os.Stat(longPath(file.Path))
+		if os.IsNotExist(err) {
+			stale = append(stale, StalePath{File: file, Problem: "missing"})
+			continue
+		} else if err != nil {
+			return stale, err
+		}
+		if file.Size != 0 && info.Mode().IsRegular() && info.Size() != file.Size {
+			stale = append(stale, StalePath{File: file, Problem: fmt.Sprintf("size changed from %d to %d bytes", file.Size, info.Size())})
+		}
+	}
+	return stale, nil
+}
+
+// ValidateManifestHashes checks the Hash and, where recorded, BodyHash of every file in dirInfo with ValidateHash.
+// A truncated or hand-edited hash would otherwise just silently never match. Each error names the file.
+func ValidateManifestHashes(dirInfo *DirectoryInfo) []error {
+	var errs []error
+	for _, file := range dirInfo.Files {
+		if err := ValidateHash(file.Hash, file.HashAlgo); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.Path, err))
+		}
+		if file.BodyHash == "" {
+			continue
+		}
+		if err := ValidateHash(file.BodyHash, file.HashAlgo); err != nil {
+			errs = append(errs, fmt.Errorf("%s: body %w", file.Path, err))
+		}
+	}
+	return errs
+}
+
+// CanonicalizePaths cleans BaseDir and every file path in place, so that forms like dir/./sub/../file.txt
+// compare equal to dir/file.txt. If resolveSymlinks is set, BaseDir is additionally resolved through
+// symlinks and the files under it are rebased onto the resolved directory; the files themselves are not
+// resolved, so symlinked files keep their link path.
+func CanonicalizePaths(dirInfo *DirectoryInfo, resolveSymlinks bool) error {
+	dirInfo.BaseDir = filepath.Clean(dirInfo.BaseDir)
+	for i := range dirInfo.Files {
+		dirInfo.Files[i].Path = filepath.Clean(dirInfo.Files[i].Path)
+	}
+	if !resolveSymlinks {
+		return nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(dirInfo.BaseDir)
+	if err != nil {
+		return err
+	}
+	for i := range dirInfo.Files {
+		if relPath, err := filepath.Rel(dirInfo.BaseDir, dirInfo.Files[i].Path); err == nil && isStrictlyUnder(dirInfo.Files[i].Path, dirInfo.BaseDir) {
+			dirInfo.Files[i].Path = filepath.Join(resolved, relPath)
+		}
+	}
+	dirInfo.BaseDir = resolved
+	return nil
+}
+
+// ManifestConflict is a path recorded with different hashes by different manifests
+type ManifestConflict struct {
+	Path    string
+	Kept    FileInfo
+	Dropped FileInfo
+}
+
+// MergeManifests combines manifests of overlapping directories into one, dropping entries with identical
+// path and hash. When manifests disagree about a path's hash the first manifest wins and the disagreement
+// is returned as a conflict. The merged base directory is the deepest directory containing all inputs.
+// The merged HashSpec is the one the inputs record, which CheckMergeableHashSpecs makes sure they agree on.
+func MergeManifests(manifests []*DirectoryInfo) (*DirectoryInfo, []ManifestConflict) {
+	merged := &DirectoryInfo{}
+	byPath := make(map[string]int)
+	var conflicts []ManifestConflict
+
+	for i, manifest := range manifests {
+		if i == 0 {
+			merged.BaseDir = manifest.BaseDir
+		} else {
+			merged.BaseDir = commonDir(merged.BaseDir, manifest.BaseDir)
+		}
+		if merged.HashSpec == nil {
+			merged.HashSpec = manifest.HashSpec
+		}
+		for _, file := range manifest.Files {
+			existing, ok := byPath[file.Path]
+			if !ok {
+				byPath[file.Path] = len(merged.Files)
+				merged.Files = append(merged.Files, file)
+				continue
+			}
+			if kept := merged.Files[existing]; kept.Hash != file.Hash {
+				conflicts = append(conflicts, ManifestConflict{Path: file.Path, Kept: kept, Dropped: file})
+			}
+		}
+	}
+
+	sort.Slice(merged.Files, func(i, j int) bool { return merged.Files[i].Path < merged.Files[j].Path })
+	return merged, conflicts
+}
+
+// commonDir returns the deepest directory that contains both a and b
+func commonDir(a, b string) string {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	for !isStrictlyUnder(b, a) && a != b {
+		parent := filepath.Dir(a)
+		if parent == a {
+			return a
+		}
+		a = parent
+	}
+	return a
+}
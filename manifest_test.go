@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeManifests(t *testing.T) {
+	a := &DirectoryInfo{BaseDir: "/data/photos", Files: []FileInfo{
+		{Path: "/data/photos/a.jpg", Hash: "1"},
+		{Path: "/data/photos/b.jpg", Hash: "2"},
+	}}
+	b := &DirectoryInfo{BaseDir: "/data/photos/2024", Files: []FileInfo{
+		{Path: "/data/photos/2024/c.jpg", Hash: "3"},
+	}}
+	c := &DirectoryInfo{BaseDir: "/data/docs", Files: []FileInfo{
+		{Path: "/data/photos/a.jpg", Hash: "1"}, // identical entry
+		{Path: "/data/photos/b.jpg", Hash: "9"}, // conflicting entry
+		{Path: "/data/docs/d.txt", Hash: "4"},
+	}}
+
+	merged, conflicts := MergeManifests([]*DirectoryInfo{a, b, c})
+	if merged.BaseDir != "/data" {
+		t.Errorf("Unexpected merged base directory: %s", merged.BaseDir)
+	}
+	if len(merged.Files) != 4 {
+		t.Errorf("Unexpected merged files: %v", merged.Files)
+	}
+	if len(conflicts) != 1 || conflicts[0].Path != "/data/photos/b.jpg" || conflicts[0].Kept.Hash != "2" || conflicts[0].Dropped.Hash != "9" {
+		t.Errorf("Unexpected conflicts: %+v", conflicts)
+	}
+}
+
+func TestValidateManifestHashes(t *testing.T) {
+	dirInfo := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/ok.txt", Hash: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{Path: "/ref/ok.mp4", Hash: "26c7827d889f6da3", HashAlgo: "xxhash"},
+		{Path: "/ref/truncated.txt", Hash: "2cf24dba5fb0a30e26e83b2ac5b9e29e"},
+		{Path: "/ref/upper.txt", Hash: "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824"},
+		{Path: "/ref/empty.txt"},
+		{Path: "/ref/unknown.txt", Hash: "abc", HashAlgo: "crc7"},
+	}}
+
+	errs := ValidateManifestHashes(dirInfo)
+	if len(errs) != 4 {
+		t.Fatalf("Unexpected number of invalid hashes: got %d, want 4: %v", len(errs), errs)
+	}
+	for i, path := range []string{"/ref/truncated.txt", "/ref/upper.txt", "/ref/empty.txt", "/ref/unknown.txt"} {
+		if !strings.HasPrefix(errs[i].Error(), path+": ") {
+			t.Errorf("Unexpected error %d: %v", i, errs[i])
+		}
+	}
+}
+
+func TestCompareManifestToManifest(t *testing.T) {
+	// neither tree exists here, as when the manifests come from two other machines
+	hash := func(c string) string { return strings.Repeat(c, 64) }
+	ref := &DirectoryInfo{BaseDir: "/machineA/photos", Files: []FileInfo{
+		{Path: "/machineA/photos/a.jpg", Hash: hash("1"), Size: 100},
+		{Path: "/machineA/photos/2024/b.jpg", Hash: hash("2"), Size: 200},
+		{Path: "/machineA/photos/c.jpg", Hash: hash("3"), Size: 300},
+	}}
+	target := &DirectoryInfo{BaseDir: "/machineB/backup", Files: []FileInfo{
+		{Path: "/machineB/backup/a.jpg", Hash: hash("1"), Size: 100},
+		{Path: "/machineB/backup/2024/b.jpg", Hash: hash("2"), Size: 200},
+		{Path: "/machineB/backup/c.jpg", Hash: hash("4"), Size: 300},      // changed on one machine
+		{Path: "/machineB/backup/2024/a.jpg", Hash: hash("1"), Size: 100}, // moved copy
+	}}
+
+	testDir, err := createTestFiles(nil)
+	if err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	refPath, targetPath := filepath.Join(testDir, "ref.yaml"), filepath.Join(testDir, "target.yaml.zst")
+	if err := writeDirectoryInfoToYAML(ref, refPath, DefaultCompressLevel); err != nil {
+		t.Fatalf("Error writing reference manifest: %v", err)
+	}
+	if err := writeDirectoryInfoToYAML(target, targetPath, DefaultCompressLevel); err != nil {
+		t.Fatalf("Error writing target manifest: %v", err)
+	}
+	loadedRef, err := readDirectoryInfoFromYAML(refPath)
+	if err != nil {
+		t.Fatalf("Error reading reference manifest: %v", err)
+	}
+	loadedTarget, err := readDirectoryInfoFromYAML(targetPath)
+	if err != nil {
+		t.Fatalf("Error reading target manifest: %v", err)
+	}
+
+	for _, test := range []struct {
+		exactPathMatch bool
+		want           []string
+	}{
+		{true, []string{"/machineB/backup/a.jpg", "/machineB/backup/2024/b.jpg"}},
+		{false, []string{"/machineB/backup/a.jpg", "/machineB/backup/2024/b.jpg", "/machineB/backup/2024/a.jpg"}},
+	} {
+		var got []string
+		for duplicate := range CompareFilesStream(loadedRef, loadedTarget, CompareOptions{ExactPathMatch: test.exactPathMatch}) {
+			got = append(got, duplicate.File.Path)
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("Unexpected duplicates with exactPathMatch %v: got %v, want %v", test.exactPathMatch, got, test.want)
+		}
+	}
+}
+
+func TestVerifyManifestPaths(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"same.txt", "same"},
+		{"grown.txt", "grown since"},
+		{"unsized.txt", "size not recorded"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	dirInfo := &DirectoryInfo{BaseDir: testDir, Files: []FileInfo{
+		{Path: filepath.Join(testDir, "same.txt"), Size: 4},
+		{Path: filepath.Join(testDir, "grown.txt"), Size: 5},
+		{Path: filepath.Join(testDir, "unsized.txt")},
+		{Path: filepath.Join(testDir, "deleted.txt"), Size: 7},
+		{Path: filepath.Join(testDir, "..", "escaped.txt"), Size: 1},
+	}}
+	stale, err := VerifyManifestPaths(dirInfo)
+	if err != nil {
+		t.Fatalf("Error verifying manifest paths: %v", err)
+	}
+	want := map[string]string{
+		"grown.txt":   "size changed from 5 to 11 bytes",
+		"deleted.txt": "missing",
+		"escaped.txt": "outside " + testDir,
+	}
+	if len(stale) != len(want) {
+		t.Errorf("Unexpected number of stale paths: got %+v, want %d", stale, len(want))
+	}
+	for _, entry := range stale {
+		if problem := want[filepath.Base(entry.File.Path)]; entry.Problem != problem {
+			t.Errorf("Unexpected problem for %s: got %q, want %q", entry.File.Path, entry.Problem, problem)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareManifestToManifestFromCLI(t *testing.T) {
+	testDir, err := createTestFiles(nil)
+	if err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	bin := buildCLI(t, testDir)
+
+	// neither tree exists here, as when the manifests come from other machines
+	ref := &DirectoryInfo{BaseDir: "/machineA/photos", Files: []FileInfo{
+		{Path: "/machineA/photos/a.jpg", Hash: strings.Repeat("1", 64), Size: 100},
+		{Path: "/machineA/photos/b.jpg", Hash: strings.Repeat("2", 64), Size: 200},
+	}}
+	target := &DirectoryInfo{BaseDir: "/machineB/backup", Files: []FileInfo{
+		{Path: "/machineB/backup/a.jpg", Hash: strings.Repeat("1", 64), Size: 100},
+		{Path: "/machineB/backup/c.jpg", Hash: strings.Repeat("3", 64), Size: 300},
+	}}
+	refPath, targetPath := filepath.Join(testDir, "ref.yaml"), filepath.Join(testDir, "target.yaml")
+	if err := writeDirectoryInfoToYAML(ref, refPath, DefaultCompressLevel); err != nil {
+		t.Fatalf("Error writing reference manifest: %v", err)
+	}
+	if err := writeDirectoryInfoToYAML(target, targetPath, DefaultCompressLevel); err != nil {
+		t.Fatalf("Error writing target manifest: %v", err)
+	}
+
+	out, err := exec.Command(bin, "-compareManifestToManifest", "-refYaml", refPath, "-targetYaml", targetPath).Output()
+	if err != nil {
+		t.Fatalf("Error comparing manifests: %v", err)
+	}
+	var report []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "#") {
+			report = append(report, line)
+		}
+	}
+	want := []string{
+		"# duplicate: /machineB/backup/a.jpg  # matches: /machineA/photos/a.jpg",
+		"# 1 duplicate files in total, 100 B reclaimable",
+		"# Compared manifests only: deleting requires a live target, run dedup with -targetDir where the target is.",
+	}
+	if strings.Join(report, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected report:\n%s\nwant:\n%s", strings.Join(report, "\n"), strings.Join(want, "\n"))
+	}
+	if strings.Contains(string(out), "rm ") {
+		t.Errorf("Unexpected deletion commands in a manifest comparison:\n%s", out)
+	}
+
+	for _, flag := range []string{"-deleteFiles", "-paranoid"} {
+		out, err := exec.Command(bin, "-compareManifestToManifest", "-refYaml", refPath, "-targetYaml", targetPath, flag).CombinedOutput()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || !strings.Contains(string(out), "requires a live target") {
+			t.Errorf("Unexpected result with %s: %v\n%s", flag, err, out)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Report is a duplicate report as written by -output json, one JSON object per line. Sharded runs each write one
+// for their part of the target against the same reference, and MergeReports combines them:
+//
+//	{"type":"duplicate","path":"/t/b","refPath":"/r/b","size":50,"hash":"bb"}
+//	{"type":"error","path":"/t/c","error":"permission denied"}
+//	{"type":"summary","summary":{"files":2,"bytes":150,"duplicates":1,"duplicateBytes":50}}
+//
+// The summary counts the files and bytes the shard scanned, and comes last. Duplicates whose link counts were
+// recorded also carry their "device", "inode" and "links".
+type Report struct {
+	Duplicates []Duplicate
+	Errors     []ReportError
+	Summary    Summary
+}
+
+// ReportError is an error recorded in a report
+type ReportError struct {
+	Path  string
+	Error string
+}
+
+// ReadReport reads a report written by -output json. A report without a summary is refused, since it comes from
+// a run that did not finish and its totals would be missing.
+func ReadReport(r io.Reader) (Report, error) {
+	var report Report
+	hasSummary := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var result jsonResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return Report{}, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch result.Type {
+		case "duplicate":
+			file := FileInfo{Path: result.Path, Size: result.Size, Hash: result.Hash, Device: result.Device, Inode: result.Inode, Links: result.Links}
+			report.Duplicates = append(report.Duplicates, Duplicate{File: file, RefPath: result.RefPath})
+		case "error":
+			report.Errors = append(report.Errors, ReportError{Path: result.Path, Error: result.Error})
+		case "summary":
+			if result.Summary == nil {
+				return Report{}, fmt.Errorf("line %d: summary record without a summary", line)
+			}
+			if hasSummary {
+				return Report{}, fmt.Errorf("line %d: more than one summary", line)
+			}
+			report.Summary, hasSummary = *result.Summary, true
+		default:
+			return Report{}, fmt.Errorf("line %d: unknown record type %q", line, result.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Report{}, err
+	}
+	if !hasSummary {
+		return Report{}, errors.New("no summary, the run that wrote it may not have finished")
+	}
+	return report, nil
+}
+
+// MergeReports combines the reports of shards into one. A file reported by several shards is counted once, with
+// the first report's reference, and the duplicate totals are recomputed from the merged duplicates, counting
+// hardlinks as FreedBytes does. The merged errors and duplicates are sorted by path. The scanned
+// files and bytes are summed, so they are only right if the shards covered separate parts of the target.
+func MergeReports(reports []Report) Report {
+	var merged Report
+	seenDuplicates := make(map[string]bool)
+	seenErrors := make(map[ReportError]bool)
+	seenBusy := make(map[string]bool)
+	var freed freedSpace
+	for _, report := range reports {
+		for _, duplicate := range report.Duplicates {
+			if seenDuplicates[duplicate.File.Path] {
+				continue
+			}
+			seenDuplicates[duplicate.File.Path] = true
+			merged.Duplicates = append(merged.Duplicates, duplicate)
+			merged.Summary.Duplicates++
+			freed.add(duplicate.File)
+		}
+		for _, reportErr := range report.Errors {
+			if !seenErrors[reportErr] {
+				seenErrors[reportErr] = true
+				merged.Errors = append(merged.Errors, reportErr)
+			}
+		}
+		for _, path := range report.Summary.Busy {
+			if !seenBusy[path] {
+				seenBusy[path] = true
+				merged.Summary.Busy = append(merged.Summary.Busy, path)
+			}
+		}
+		merged.Summary.Files += report.Summary.Files
+		merged.Summary.Bytes += report.Summary.Bytes
+	}
+	merged.Summary.DuplicateBytes = freed.bytes
+
+	sort.Slice(merged.Duplicates, func(i, j int) bool { return merged.Duplicates[i].File.Path < merged.Duplicates[j].File.Path })
+	sort.Slice(merged.Errors, func(i, j int) bool {
+		if merged.Errors[i].Path != merged.Errors[j].Path {
+			return merged.Errors[i].Path < merged.Errors[j].Path
+		}
+		return merged.Errors[i].Error < merged.Errors[j].Error
+	})
+	sort.Strings(merged.Summary.Busy)
+	return merged
+}
+
+// SendReport writes a report to sink: its errors, then its duplicates in the order they are listed, then its summary
+func SendReport(sink ResultSink, report Report) error {
+	for _, reportErr := range report.Errors {
+		if err := sink.Error(reportErr.Path, errors.New(reportErr.Error)); err != nil {
+			return err
+		}
+	}
+	for _, duplicate := range report.Duplicates {
+		if err := sink.Duplicate(duplicate); err != nil {
+			return err
+		}
+	}
+	if err := sink.Summary(report.Summary); err != nil {
+		return err
+	}
+	return sink.Flush()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeReports(t *testing.T) {
+	shards := []struct {
+		targetDir  *DirectoryInfo
+		duplicates []Duplicate
+		errPath    string
+	}{
+		{
+			targetDir:  &DirectoryInfo{BaseDir: "/t", Files: []FileInfo{{Path: "/t/a", Hash: "aa", Size: 100}, {Path: "/t/b", Hash: "bb", Size: 50}}},
+			duplicates: []Duplicate{{File: FileInfo{Path: "/t/b", Hash: "bb", Size: 50}, RefPath: "/r/b"}},
+			errPath:    "/t/unreadable",
+		},
+		{
+			targetDir: &DirectoryInfo{BaseDir: "/t", Files: []FileInfo{{Path: "/t/c", Hash: "cc", Size: 30}, {Path: "/t/d", Hash: "aa", Size: 100}}},
+			duplicates: []Duplicate{
+				{File: FileInfo{Path: "/t/d", Hash: "aa", Size: 100}, RefPath: "/r/a"},
+				{File: FileInfo{Path: "/t/b", Hash: "bb", Size: 50}, RefPath: "/r/b2"},
+			},
+			errPath: "/t/unreadable",
+		},
+	}
+
+	var reports []Report
+	for _, shard := range shards {
+		var buf bytes.Buffer
+		sink, _ := NewResultSink("json", &buf, false)
+		errs := []ReportError{{Path: shard.errPath, Error: "permission denied"}}
+		if err := SendResults(sink, shard.duplicates, shard.targetDir, errs, nil); err != nil {
+			t.Fatalf("Unexpected error writing a shard: %v", err)
+		}
+		report, err := ReadReport(&buf)
+		if err != nil {
+			t.Fatalf("Unexpected error reading a shard: %v", err)
+		}
+		reports = append(reports, report)
+	}
+
+	merged := MergeReports(reports)
+	wantDuplicates := []Duplicate{
+		{File: FileInfo{Path: "/t/b", Hash: "bb", Size: 50}, RefPath: "/r/b"},
+		{File: FileInfo{Path: "/t/d", Hash: "aa", Size: 100}, RefPath: "/r/a"},
+	}
+	if !reflect.DeepEqual(merged.Duplicates, wantDuplicates) {
+		t.Errorf("Unexpected merged duplicates: got %+v, want %+v", merged.Duplicates, wantDuplicates)
+	}
+	if want := []ReportError{{Path: "/t/unreadable", Error: "permission denied"}}; !reflect.DeepEqual(merged.Errors, want) {
+		t.Errorf("Unexpected merged errors: got %+v, want %+v", merged.Errors, want)
+	}
+	if want := (Summary{Files: 4, Bytes: 280, Duplicates: 2, DuplicateBytes: 150}); !reflect.DeepEqual(merged.Summary, want) {
+		t.Errorf("Unexpected merged summary: got %+v, want %+v", merged.Summary, want)
+	}
+
+	// the merged report is itself mergeable
+	var buf bytes.Buffer
+	sink, _ := NewResultSink("json", &buf, false)
+	if err := SendReport(sink, merged); err != nil {
+		t.Fatalf("Unexpected error writing the merged report: %v", err)
+	}
+	again, err := ReadReport(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error reading the merged report: %v", err)
+	}
+	if !reflect.DeepEqual(again, merged) {
+		t.Errorf("Unexpected report after a round trip: got %+v, want %+v", again, merged)
+	}
+}
+
+func TestMergeReportsHardlinks(t *testing.T) {
+	// a and its second link a2 are in different shards, and only merging sees both deleted
+	a := FileInfo{Path: "/t/1/a", Hash: "aa", Size: 100, Device: 1, Inode: 10, Links: 2}
+	a2 := FileInfo{Path: "/t/2/a", Hash: "aa", Size: 100, Device: 1, Inode: 10, Links: 2}
+	var reports []Report
+	for _, file := range []FileInfo{a, a2} {
+		var buf bytes.Buffer
+		sink, _ := NewResultSink("json", &buf, false)
+		targetDir := &DirectoryInfo{BaseDir: "/t", Files: []FileInfo{file}}
+		if err := SendResults(sink, []Duplicate{{File: file, RefPath: "/r/a"}}, targetDir, nil, nil); err != nil {
+			t.Fatalf("Unexpected error writing a shard: %v", err)
+		}
+		report, err := ReadReport(&buf)
+		if err != nil {
+			t.Fatalf("Unexpected error reading a shard: %v", err)
+		}
+		if report.Summary.DuplicateBytes != 0 {
+			t.Errorf("Unexpected shard duplicate bytes: got %d, want 0", report.Summary.DuplicateBytes)
+		}
+		reports = append(reports, report)
+	}
+
+	merged := MergeReports(reports)
+	if merged.Summary.DuplicateBytes != 100 {
+		t.Errorf("Unexpected merged duplicate bytes: got %d, want 100", merged.Summary.DuplicateBytes)
+	}
+	if !reflect.DeepEqual(merged.Duplicates[0].File, a) {
+		t.Errorf("Unexpected merged file: got %+v, want %+v", merged.Duplicates[0].File, a)
+	}
+}
+
+func TestReadReportErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"unfinished":    `{"type":"duplicate","path":"/t/b","refPath":"/r/b","size":50,"hash":"bb"}` + "\n",
+		"unknown type":  `{"type":"conflict","path":"/t/b"}` + "\n" + `{"type":"summary","summary":{"files":1}}` + "\n",
+		"two summaries": `{"type":"summary","summary":{"files":1}}` + "\n" + `{"type":"summary","summary":{"files":1}}` + "\n",
+		"not json":      "rm -- '/t/b'  # duplicated at: /r/b\n",
+	} {
+		if _, err := ReadReport(strings.NewReader(input)); err == nil {
+			t.Errorf("Unexpected success reading a report that is %s", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildCLI builds the deduplicator binary into dir, skipping the test where no Go toolchain is at hand
+func buildCLI(t *testing.T, dir string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping the CLI build in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("No go tool to build the CLI with")
+	}
+	bin := filepath.Join(dir, "deduplicator")
+	if out, err := exec.Command(goTool, "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build the CLI: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestMergeReportsFromCLI(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref/a.txt", "a"},
+		{"ref/b.txt", "b"},
+		{"shard1/a.txt", "a"},
+		{"shard1/x.txt", "x"},
+		{"shard1/locked.txt", "locked"},
+		{"shard2/b.txt", "b"},
+		{"shard2/unreadable.txt", "unreadable"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	bin := buildCLI(t, testDir)
+
+	locked := holdLock(t, filepath.Join(testDir, "shard1/locked.txt"))
+	defer locked.Close()
+	unreadable := filepath.Join(testDir, "shard2/unreadable.txt")
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatalf("Failed to make %s unreadable: %v", unreadable, err)
+	}
+	// root reads the file regardless of its mode
+	wantErrors := os.Geteuid() != 0
+
+	run := func(args ...string) string {
+		out, err := exec.Command(bin, args...).Output()
+		if err != nil {
+			t.Fatalf("Error running %v: %v", args, err)
+		}
+		return string(out)
+	}
+	var shards []string
+	for _, shard := range []string{"shard1", "shard2"} {
+		report := filepath.Join(testDir, shard+".jsonl")
+		run("-refDir", filepath.Join(testDir, "ref"), "-targetDir", filepath.Join(testDir, shard), "-exactPathMatch=false",
+			"-skipBusy", "-errorLog", filepath.Join(testDir, shard+".errors"), "-output", "json:"+report)
+		shards = append(shards, "-mergeReports", report)
+	}
+
+	merged, err := ReadReport(strings.NewReader(run(append(shards, "-output", "json")...)))
+	if err != nil {
+		t.Fatalf("Merged report does not parse: %v", err)
+	}
+	var duplicates []string
+	for _, duplicate := range merged.Duplicates {
+		duplicates = append(duplicates, filepath.Base(duplicate.File.Path))
+	}
+	if want := []string{"a.txt", "b.txt"}; !reflect.DeepEqual(duplicates, want) {
+		t.Errorf("Unexpected merged duplicates: got %v, want %v", duplicates, want)
+	}
+	if want := []string{filepath.Join(testDir, "shard1/locked.txt")}; !reflect.DeepEqual(merged.Summary.Busy, want) {
+		t.Errorf("Unexpected merged busy files: got %v, want %v", merged.Summary.Busy, want)
+	}
+	if wantErrors && (len(merged.Errors) != 1 || merged.Errors[0].Path != unreadable) {
+		t.Errorf("Unexpected merged errors: got %+v, want one for %s", merged.Errors, unreadable)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import "fmt"
+
+// MatchPolicy decides how a target file compared against several references becomes a duplicate
+type MatchPolicy string
+
+const (
+	// MatchAny makes a target file a duplicate if any reference has it
+	MatchAny MatchPolicy = "any"
+	// MatchAll makes a target file a duplicate only if every reference has it, e.g. every backup
+	MatchAll MatchPolicy = "all"
+)
+
+// ParseMatchPolicy checks a -matchPolicy value
+func ParseMatchPolicy(value string) (MatchPolicy, error) {
+	switch policy := MatchPolicy(value); policy {
+	case MatchAny, MatchAll:
+		return policy, nil
+	}
+	return "", fmt.Errorf("expected any or all, got %q", value)
+}
+
+// MultiDuplicate is a target file found in several references. Its RefPath is the first match.
+type MultiDuplicate struct {
+	Duplicate
+	// Matches holds the matching path in each reference, in the order the references were given,
+	// or "" for the references without a match
+	Matches []string
+}
+
+// CompareAgainstReferences compares the target against each of refs as configured by opts, and returns the
+// target files that are duplicates under policy, in target order
+func CompareAgainstReferences(refs []*DirectoryInfo, targetDir *DirectoryInfo, opts CompareOptions, policy MatchPolicy) []MultiDuplicate {
+	// the per-reference events would not describe the combined result
+	opts.Hooks = nil
+	matches := make(map[string][]string) // map[target path][]reference path
+	for i, refDir := range refs {
+		compareFiles(refDir, targetDir, opts, func(duplicate Duplicate) {
+			if matches[duplicate.File.Path] == nil {
+				matches[duplicate.File.Path] = make([]string, len(refs))
+			}
+			matches[duplicate.File.Path][i] = duplicate.RefPath
+		})
+	}
+
+	var duplicates []MultiDuplicate
+	for _, file := range targetDir.Files {
+		refPaths := matches[file.Path]
+		if refPaths == nil {
+			continue
+		}
+		duplicate := MultiDuplicate{Duplicate: Duplicate{File: file}, Matches: refPaths}
+		found := 0
+		for _, refPath := range refPaths {
+			if refPath == "" {
+				continue
+			}
+			if duplicate.RefPath == "" {
+				duplicate.RefPath = refPath
+			}
+			found++
+		}
+		if policy == MatchAll && found < len(refs) {
+			continue
+		}
+		duplicates = append(duplicates, duplicate)
+	}
+	return duplicates
+}
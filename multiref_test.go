@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareAgainstReferences(t *testing.T) {
+	backup1 := &DirectoryInfo{BaseDir: "/b1", Files: []FileInfo{{Path: "/b1/a", Hash: "aa"}, {Path: "/b1/b", Hash: "bb"}}}
+	backup2 := &DirectoryInfo{BaseDir: "/b2", Files: []FileInfo{{Path: "/b2/a", Hash: "aa"}}}
+	backup3 := &DirectoryInfo{BaseDir: "/b3", Files: []FileInfo{{Path: "/b3/a", Hash: "aa"}, {Path: "/b3/c", Hash: "cc"}}}
+	target := &DirectoryInfo{BaseDir: "/t", Files: []FileInfo{
+		{Path: "/t/a", Hash: "aa"},
+		{Path: "/t/b", Hash: "bb"},
+		{Path: "/t/c", Hash: "cc"},
+		{Path: "/t/d", Hash: "dd"},
+	}}
+	refs := []*DirectoryInfo{backup1, backup2, backup3}
+	opts := CompareOptions{ExactPathMatch: true}
+
+	anyMatch := CompareAgainstReferences(refs, target, opts, MatchAny)
+	want := []MultiDuplicate{
+		{Duplicate: Duplicate{File: target.Files[0], RefPath: "/b1/a"}, Matches: []string{"/b1/a", "/b2/a", "/b3/a"}},
+		{Duplicate: Duplicate{File: target.Files[1], RefPath: "/b1/b"}, Matches: []string{"/b1/b", "", ""}},
+		{Duplicate: Duplicate{File: target.Files[2], RefPath: "/b3/c"}, Matches: []string{"", "", "/b3/c"}},
+	}
+	if !reflect.DeepEqual(anyMatch, want) {
+		t.Errorf("Unexpected duplicates matching any reference: got %+v, want %+v", anyMatch, want)
+	}
+
+	allMatch := CompareAgainstReferences(refs, target, opts, MatchAll)
+	if !reflect.DeepEqual(allMatch, want[:1]) {
+		t.Errorf("Unexpected duplicates matching all references: got %+v, want %+v", allMatch, want[:1])
+	}
+
+	if _, err := ParseMatchPolicy("most"); err == nil {
+		t.Errorf("Expected an error for an unknown match policy")
+	}
+}
@@ -0,0 +1,55 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDedupMultipleRefsJSONOnStdout(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref1/a.txt", "a"},
+		{"ref2/a.txt", "a"},
+		{"target/a.txt", "a"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	bin := buildCLI(t, testDir)
+
+	var refYamls []string
+	for _, ref := range []string{"ref1", "ref2"} {
+		dirInfo, err := WalkDirectoryWithOptions(filepath.Join(testDir, ref), WalkOptions{})
+		if err != nil {
+			t.Fatalf("Error walking %s: %v", ref, err)
+		}
+		path := filepath.Join(testDir, ref+".yaml")
+		if err := writeDirectoryInfoToYAML(dirInfo, path, DefaultCompressLevel); err != nil {
+			t.Fatalf("Error writing %s: %v", path, err)
+		}
+		refYamls = append(refYamls, "-refYaml", path)
+	}
+
+	args := append(refYamls, "-targetDir", filepath.Join(testDir, "target"), "-output", "json")
+	out, err := exec.Command(bin, args...).Output()
+	if err != nil {
+		t.Fatalf("Error running %v: %v", args, err)
+	}
+	// the counts of matching manifests are comments, which would break the JSON stream
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		var result jsonResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("Unexpected non-JSON line %q: %v\n%s", line, err, out)
+		}
+		types = append(types, result.Type)
+	}
+	if got := strings.Join(types, ","); got != "duplicate,summary" {
+		t.Errorf("Unexpected records: got %s, want duplicate,summary", got)
+	}
+}
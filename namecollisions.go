@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NameCollision is a set of paths of one tree that are distinct as stored but the same once case is folded and
+// Unicode is normalized to NFC. Copied to a case-insensitive or normalizing file system, such as from Linux to
+// macOS or back, they would end up at the same path.
+type NameCollision struct {
+	// Normalized is the path they share after normalization
+	Normalized string
+	// Paths are the stored paths relative to the tree root, sorted
+	Paths []string
+	// Dir tells whether the paths are all directories, whose contents would merge rather than overwrite each other
+	Dir bool
+	// Identical tells whether the paths are files with the same recorded hash, so keeping one loses nothing.
+	// It is false when hashes were not recorded.
+	Identical bool
+}
+
+// foldName returns path case-folded and in NFC, the form in which names collide
+func foldName(name string) string {
+	return norm.NFC.String(cases.Fold().String(name))
+}
+
+// FindNameCollisions returns the paths of dirInfo that collide after case folding and Unicode normalization,
+// directories included, ordered by normalized path. Paths that are equal as stored, such as two manifest
+// entries for the same file, do not collide.
+func FindNameCollisions(dirInfo *DirectoryInfo) []NameCollision {
+	type entry struct {
+		dir     bool
+		content string
+	}
+	entries := make(map[string]entry) // map[relative path]entry
+	for _, file := range dirInfo.Files {
+		relPath, err := filepath.Rel(dirInfo.BaseDir, file.Path)
+		if err != nil {
+			continue
+		}
+		relPath = filepath.ToSlash(relPath)
+		entries[relPath] = entry{content: contentKey(file, CompareOptions{})}
+		for dir := path.Dir(relPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			entries[dir] = entry{dir: true}
+		}
+	}
+
+	byNormalized := make(map[string][]string)
+	for relPath := range entries {
+		normalized := foldName(relPath)
+		byNormalized[normalized] = append(byNormalized[normalized], relPath)
+	}
+
+	var collisions []NameCollision
+	for normalized, paths := range byNormalized {
+		if len(paths) < 2 {
+			continue
+		}
+		sort.Strings(paths)
+		collision := NameCollision{Normalized: normalized, Paths: paths, Dir: true, Identical: true}
+		for _, relPath := range paths {
+			e := entries[relPath]
+			collision.Dir = collision.Dir && e.dir
+			collision.Identical = collision.Identical && !e.dir && e.content != "" && e.content == entries[paths[0]].content
+		}
+		collisions = append(collisions, collision)
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].Normalized < collisions[j].Normalized })
+	return collisions
+}
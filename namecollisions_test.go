@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindNameCollisions(t *testing.T) {
+	dirInfo := &DirectoryInfo{BaseDir: "/t", Files: []FileInfo{
+		{Path: "/t/Photos/a.jpg", Hash: "aa"},
+		{Path: "/t/photos/b.jpg", Hash: "bb"},
+		{Path: "/t/caf\u00e9.txt", Hash: "cc"},  // NFC, as Linux stores it
+		{Path: "/t/cafe\u0301.txt", Hash: "dd"}, // NFD, as HFS+ stores it
+		{Path: "/t/README", Hash: "ee"},
+		{Path: "/t/readme", Hash: "ee"},
+		{Path: "/t/Straße", Hash: "ff"},
+		{Path: "/t/STRASSE"},
+		{Path: "/t/unique.txt", Hash: "gg"},
+	}}
+
+	want := []NameCollision{
+		{Normalized: "caf\u00e9.txt", Paths: []string{"cafe\u0301.txt", "caf\u00e9.txt"}},
+		{Normalized: "photos", Paths: []string{"Photos", "photos"}, Dir: true},
+		{Normalized: "readme", Paths: []string{"README", "readme"}, Identical: true},
+		{Normalized: "strasse", Paths: []string{"STRASSE", "Straße"}},
+	}
+	if got := FindNameCollisions(dirInfo); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected collisions: got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// OwnerFilter selects files by owning user and group (Unix only); the zero value selects every file
+type OwnerFilter struct {
+	// UID and GID, if not nil, are the numeric user and group ID a file must have
+	UID *uint32
+	GID *uint32
+}
+
+// IsZero reports whether the filter selects every file
+func (f OwnerFilter) IsZero() bool {
+	return f.UID == nil && f.GID == nil
+}
+
+// Matches reports whether the file described by info has the filter's owner and group.
+// Files whose owner cannot be read never match a non-zero filter.
+func (f OwnerFilter) Matches(info os.FileInfo) bool {
+	if f.IsZero() {
+		return true
+	}
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return false
+	}
+	return (f.UID == nil || *f.UID == uid) && (f.GID == nil || *f.GID == gid)
+}
+
+// FilterRecordedOwner drops the files of a manifest whose recorded owner and group do not match the filter.
+// It fails if a file lacks the owner or group the filter needs, as in manifests made without -captureOwner.
+func FilterRecordedOwner(dirInfo *DirectoryInfo, filter OwnerFilter) error {
+	if filter.IsZero() {
+		return nil
+	}
+	kept := dirInfo.Files[:0]
+	for _, file := range dirInfo.Files {
+		uid, uidOK := parseID(file.Owner)
+		gid, gidOK := parseID(file.Group)
+		if (filter.UID != nil && !uidOK) || (filter.GID != nil && !gidOK) {
+			return fmt.Errorf("%s has no recorded owner and group; make the manifest with -captureOwner", file.Path)
+		}
+		if (filter.UID == nil || *filter.UID == uid) && (filter.GID == nil || *filter.GID == gid) {
+			kept = append(kept, file)
+		}
+	}
+	dirInfo.Files = kept
+	return nil
+}
+
+// ParseOwnerFilter builds an OwnerFilter from a user and a group, each a name or a numeric ID, or empty for any.
+// It fails on platforms without file ownership.
+func ParseOwnerFilter(owner, group string) (OwnerFilter, error) {
+	var filter OwnerFilter
+	if owner == "" && group == "" {
+		return filter, nil
+	}
+	if owner != "" {
+		uid, err := lookupUID(owner)
+		if err != nil {
+			return filter, err
+		}
+		filter.UID = &uid
+	}
+	if group != "" {
+		gid, err := lookupGID(group)
+		if err != nil {
+			return filter, err
+		}
+		filter.GID = &gid
+	}
+	return filter, nil
+}
+
+// parseID parses a numeric user or group ID
+func parseID(id string) (uint32, bool) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	return uint32(n), err == nil
+}
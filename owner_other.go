@@ -0,0 +1,29 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errOwnershipUnsupported is returned for owner and group filters where files have no Unix owner
+var errOwnershipUnsupported = errors.New("filtering by owner or group is only supported on Unix")
+
+// fileOwner is not supported on this platform
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
+
+func lookupUID(owner string) (uint32, error) {
+	return 0, errOwnershipUnsupported
+}
+
+func lookupGID(group string) (uint32, error) {
+	return 0, errOwnershipUnsupported
+}
+
+// ownerStrings records no owner on this platform
+func ownerStrings(info os.FileInfo) (owner, group string) {
+	return "", ""
+}
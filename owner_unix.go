@@ -0,0 +1,61 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner returns the numeric user and group ID owning the file described by info
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
+
+// lookupUID resolves a user name or numeric ID
+func lookupUID(owner string) (uint32, error) {
+	if uid, ok := parseID(owner); ok {
+		return uid, nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return 0, err
+	}
+	uid, ok := parseID(u.Uid)
+	if !ok {
+		return 0, fmt.Errorf("user %s has a non-numeric ID %q", owner, u.Uid)
+	}
+	return uid, nil
+}
+
+// lookupGID resolves a group name or numeric ID
+func lookupGID(group string) (uint32, error) {
+	if gid, ok := parseID(group); ok {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	gid, ok := parseID(g.Gid)
+	if !ok {
+		return 0, fmt.Errorf("group %s has a non-numeric ID %q", group, g.Gid)
+	}
+	return gid, nil
+}
+
+// ownerStrings formats the owner of info for FileInfo.Owner and FileInfo.Group
+func ownerStrings(info os.FileInfo) (owner, group string) {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return "", ""
+	}
+	return strconv.FormatUint(uint64(uid), 10), strconv.FormatUint(uint64(gid), 10)
+}
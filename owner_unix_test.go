@@ -0,0 +1,95 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestParseOwnerFilter(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot look up the current user: %v", err)
+	}
+
+	filter, err := ParseOwnerFilter(current.Username, current.Gid)
+	if err != nil {
+		t.Fatalf("Error parsing owner filter: %v", err)
+	}
+	if filter.UID == nil || strconv.FormatUint(uint64(*filter.UID), 10) != current.Uid {
+		t.Errorf("Unexpected UID for %s: got %v, want %s", current.Username, filter.UID, current.Uid)
+	}
+	if filter.GID == nil || strconv.FormatUint(uint64(*filter.GID), 10) != current.Gid {
+		t.Errorf("Unexpected GID: got %v, want %s", filter.GID, current.Gid)
+	}
+
+	if filter, err := ParseOwnerFilter("", ""); err != nil || !filter.IsZero() {
+		t.Errorf("Unexpected filter for no owner or group: %+v, %v", filter, err)
+	}
+	if _, err := ParseOwnerFilter("no-such-user-hopefully", ""); err == nil {
+		t.Error("Expected an error for an unknown user")
+	}
+}
+
+func TestWalkFiltersByOwner(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "a"},
+		{"sub/b.txt", "b"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{Owner: OwnerFilter{UID: &uid, GID: &gid}, CaptureOwner: true})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if len(dirInfo.Files) != 2 {
+		t.Fatalf("Unexpected number of own files: got %d, want 2", len(dirInfo.Files))
+	}
+	for _, file := range dirInfo.Files {
+		if file.Owner != strconv.Itoa(os.Getuid()) || file.Group != strconv.Itoa(os.Getgid()) {
+			t.Errorf("Unexpected owner of %s: got %s:%s, want %d:%d", file.Path, file.Owner, file.Group, os.Getuid(), os.Getgid())
+		}
+	}
+
+	other := uid + 1
+	dirInfo, err = WalkDirectoryWithOptions(testDir, WalkOptions{Owner: OwnerFilter{UID: &other}})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if len(dirInfo.Files) != 0 {
+		t.Errorf("Unexpected files of another user: got %d, want 0", len(dirInfo.Files))
+	}
+}
+
+func TestFilterRecordedOwner(t *testing.T) {
+	uid, gid := uint32(1000), uint32(100)
+	dirInfo := &DirectoryInfo{Files: []FileInfo{
+		{Path: "mine.txt", Owner: "1000", Group: "100"},
+		{Path: "theirs.txt", Owner: "1001", Group: "100"},
+		{Path: "other-group.txt", Owner: "1000", Group: "200"},
+	}}
+	if err := FilterRecordedOwner(dirInfo, OwnerFilter{UID: &uid, GID: &gid}); err != nil {
+		t.Fatalf("Error filtering by recorded owner: %v", err)
+	}
+	if len(dirInfo.Files) != 1 || dirInfo.Files[0].Path != "mine.txt" {
+		t.Errorf("Unexpected files after filtering: got %+v, want only mine.txt", dirInfo.Files)
+	}
+
+	// a manifest made without -captureOwner cannot be filtered
+	legacy := &DirectoryInfo{Files: []FileInfo{{Path: "a.txt"}}}
+	if err := FilterRecordedOwner(legacy, OwnerFilter{UID: &uid}); err == nil {
+		t.Error("Expected an error for a manifest without recorded owners")
+	}
+	// a group filter only needs the group
+	groupOnly := &DirectoryInfo{Files: []FileInfo{{Path: "a.txt", Group: "100"}}}
+	if err := FilterRecordedOwner(groupOnly, OwnerFilter{GID: &gid}); err != nil || len(groupOnly.Files) != 1 {
+		t.Errorf("Unexpected result filtering by group only: %+v, %v", groupOnly.Files, err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// ContentMismatch is a group of duplicates of one reference file that cannot be trusted: their hashes match, but
+// their content differs or could not be read to check
+type ContentMismatch struct {
+	RefPath string
+	Files   []FileInfo
+	// Differing are the files of Files whose content differs from the reference file
+	Differing []string
+	// Err is the error that prevented the comparison, if any
+	Err error
+}
+
+// VerifyDuplicateContent compares every duplicate byte for byte with its reference file before anything is acted
+// upon, and returns the duplicates whose whole group (the reference file and all its duplicates) is identical.
+// Since every file is compared with the same reference file, this proves all pairs of the group equal.
+// Groups with any difference or read error are left out entirely and returned as mismatches, ordered by RefPath.
+func VerifyDuplicateContent(duplicates []Duplicate) ([]Duplicate, []ContentMismatch) {
+	var refPaths []string
+	groups := make(map[string][]FileInfo) // map[reference path][]duplicate
+	for _, duplicate := range duplicates {
+		if _, ok := groups[duplicate.RefPath]; !ok {
+			refPaths = append(refPaths, duplicate.RefPath)
+		}
+		groups[duplicate.RefPath] = append(groups[duplicate.RefPath], duplicate.File)
+	}
+
+	trusted := make(map[string]bool)
+	var mismatches []ContentMismatch
+	for _, refPath := range refPaths {
+		mismatch := ContentMismatch{RefPath: refPath, Files: groups[refPath]}
+		for _, file := range mismatch.Files {
+			equal, err := sameContent(refPath, file.Path)
+			if err != nil {
+				mismatch.Err = err
+				break
+			}
+			if !equal {
+				mismatch.Differing = append(mismatch.Differing, file.Path)
+			}
+		}
+		if mismatch.Err == nil && len(mismatch.Differing) == 0 {
+			trusted[refPath] = true
+			continue
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	var verified []Duplicate
+	for _, duplicate := range duplicates {
+		if trusted[duplicate.RefPath] {
+			verified = append(verified, duplicate)
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].RefPath < mismatches[j].RefPath })
+	return verified, mismatches
+}
+
+// sameContent reports whether the files at the two paths have identical content
+func sameContent(pathA, pathB string) (bool, error) {
+	fileA, err := os.Open(longPath(pathA))
+	if err != nil {
+		return false, err
+	}
+	defer fileA.Close()
+	fileB, err := os.Open(longPath(pathB))
+	if err != nil {
+		return false, err
+	}
+	defer fileB.Close()
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, len(bufA))
+	for {
+		nA, errA := io.ReadFull(fileA, bufA)
+		nB, errB := io.ReadFull(fileB, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		endA, endB := errA == io.EOF || errA == io.ErrUnexpectedEOF, errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, fmt.Errorf("%s: %w", pathA, errA)
+		}
+		if errB != nil && !endB {
+			return false, fmt.Errorf("%s: %w", pathB, errB)
+		}
+		if endA || endB {
+			return endA && endB, nil
+		}
+	}
+}
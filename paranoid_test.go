@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyDuplicateContent(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref/a.txt", "same content"},
+		{"ref/b.txt", "original"},
+		{"target/a1.txt", "same content"},
+		{"target/a2.txt", "same content"},
+		{"target/b1.txt", "original"},
+		{"target/b2.txt", "collision"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	path := func(name string) string { return filepath.Join(testDir, name) }
+
+	duplicates := []Duplicate{
+		{File: FileInfo{Path: path("target/a1.txt")}, RefPath: path("ref/a.txt")},
+		{File: FileInfo{Path: path("target/b1.txt")}, RefPath: path("ref/b.txt")},
+		{File: FileInfo{Path: path("target/a2.txt")}, RefPath: path("ref/a.txt")},
+		{File: FileInfo{Path: path("target/b2.txt")}, RefPath: path("ref/b.txt")},
+		{File: FileInfo{Path: path("target/c.txt")}, RefPath: path("ref/missing.txt")},
+	}
+	verified, mismatches := VerifyDuplicateContent(duplicates)
+
+	if len(verified) != 2 || verified[0].File.Path != path("target/a1.txt") || verified[1].File.Path != path("target/a2.txt") {
+		t.Errorf("Unexpected verified duplicates: %v", verified)
+	}
+	if len(mismatches) != 2 {
+		t.Fatalf("Unexpected number of mismatches: got %d, want %d", len(mismatches), 2)
+	}
+	if mismatches[0].RefPath != path("ref/b.txt") || mismatches[0].Err != nil || len(mismatches[0].Files) != 2 ||
+		len(mismatches[0].Differing) != 1 || mismatches[0].Differing[0] != path("target/b2.txt") {
+		t.Errorf("Unexpected mismatch: %+v", mismatches[0])
+	}
+	if mismatches[1].RefPath != path("ref/missing.txt") || mismatches[1].Err == nil {
+		t.Errorf("Unexpected mismatch for an unreadable reference: %+v", mismatches[1])
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a", "abc"},
+		{"b", "abc"},
+		{"c", "abcd"},
+		{"d", ""},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	for _, test := range []struct {
+		a, b string
+		want bool
+	}{{"a", "b", true}, {"a", "c", false}, {"c", "a", false}, {"d", "d", true}, {"d", "a", false}} {
+		equal, err := sameContent(filepath.Join(testDir, test.a), filepath.Join(testDir, test.b))
+		if err != nil || equal != test.want {
+			t.Errorf("Unexpected result comparing %s and %s: got %v (%v), want %v", test.a, test.b, equal, err, test.want)
+		}
+	}
+}
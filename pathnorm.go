@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// PathNormalizer rewrites the relative path or file name a file is matched by. Reference and target can be
+// normalized differently, e.g. lowercasing only the target when the reference archive is already lowercase.
+type PathNormalizer func(path string) string
+
+// pathNormalizers are the normalizers ParsePathNormalizer accepts
+var pathNormalizers = map[string]PathNormalizer{
+	// lower ignores case
+	"lower": strings.ToLower,
+	// nfc unifies the Unicode normalization form, which differs for accented names between macOS and Linux
+	"nfc": norm.NFC.String,
+	// slash uses forward slashes as separator, for manifests made on Windows
+	"slash": func(path string) string {
+		return strings.ReplaceAll(filepath.ToSlash(path), `\`, "/")
+	},
+}
+
+// ParsePathNormalizer combines the normalizers named in a comma-separated spec like "lower,nfc", applied in
+// order. An empty spec returns nil, which leaves paths unchanged.
+func ParsePathNormalizer(spec string) (PathNormalizer, error) {
+	var steps []PathNormalizer
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		step, ok := pathNormalizers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown path normalization %q, expected lower, nfc or slash", name)
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	return func(path string) string {
+		for _, step := range steps {
+			path = step(path)
+		}
+		return path
+	}, nil
+}
+
+// apply returns path normalized by n, or unchanged if n is nil
+func (n PathNormalizer) apply(path string) string {
+	if n == nil {
+		return path
+	}
+	return n(path)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePathNormalizer(t *testing.T) {
+	normalize, err := ParsePathNormalizer("lower, nfc,slash")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// an e followed by a combining acute accent is the decomposed form of é that macOS file systems use
+	if got, want := normalize(`Photos\Cafe`+"\u0301"+`/IMG.JPG`), "photos/caf\u00e9/img.jpg"; got != want {
+		t.Errorf("Unexpected normalized path: got %q, want %q", got, want)
+	}
+	if normalize, err := ParsePathNormalizer(""); err != nil || normalize != nil {
+		t.Errorf("Unexpected result for an empty spec: %v, %v", normalize != nil, err)
+	}
+	if _, err := ParsePathNormalizer("lower,upper"); err == nil {
+		t.Errorf("Expected an error for an unknown normalization")
+	}
+}
+
+func TestCompareFilesNormalizedPaths(t *testing.T) {
+	refDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"photos/img_1.jpg", "one"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(refDir)
+	targetDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"Photos/IMG_1.jpg", "one"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(targetDir)
+
+	refDirInfo, err := WalkDirectory(refDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking reference directory: %v", err)
+	}
+	targetDirInfo, err := WalkDirectory(targetDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking target directory: %v", err)
+	}
+
+	lower, _ := ParsePathNormalizer("lower")
+	for _, test := range []struct {
+		name       string
+		opts       CompareOptions
+		duplicates int
+	}{
+		{"no normalization", CompareOptions{ExactPathMatch: true}, 0},
+		{"reference only", CompareOptions{ExactPathMatch: true, NormalizeRefPath: lower}, 0},
+		{"target only", CompareOptions{ExactPathMatch: true, NormalizeTargetPath: lower}, 1},
+		{"file names", CompareOptions{NormalizeTargetPath: lower}, 1},
+	} {
+		duplicates := CompareFilesWithOptions(refDirInfo, targetDirInfo, test.opts)
+		if len(duplicates) != test.duplicates {
+			t.Errorf("Unexpected duplicates with %s: got %d, want %d", test.name, len(duplicates), test.duplicates)
+		}
+	}
+
+	fileMap := GetFileMapWithNormalizer(targetDirInfo, true, lower)
+	for _, paths := range fileMap {
+		if !paths[filepath.Join("photos", "img_1.jpg")] {
+			t.Errorf("Unexpected normalized file map: %v", fileMap)
+		}
+	}
+}
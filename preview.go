@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultPreviewBytes is how much of a file ContentPreview shows unless configured otherwise
+const DefaultPreviewBytes = 200
+
+// ContentPreview returns the first n bytes of file as a single line of text, with control characters replaced,
+// or a hex summary if the content looks binary
+func ContentPreview(file FileInfo, n int) (string, error) {
+	head, err := readAt(file.Path, 0, n)
+	if err != nil {
+		return "", err
+	}
+	if looksBinary(head) {
+		sample := head
+		if len(sample) > 16 {
+			sample = sample[:16]
+		}
+		return fmt.Sprintf("binary, %d bytes: %s...", file.Size, hex.EncodeToString(sample)), nil
+	}
+
+	truncated := file.Size > int64(len(head))
+	for !utf8.Valid(head) {
+		// drop the partial character at the end, see looksBinary
+		head = head[:len(head)-1]
+	}
+	preview := strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case r == utf8.RuneError || !unicode.IsPrint(r):
+			return '.'
+		}
+		return r
+	}, string(head))
+	if truncated {
+		preview += "..."
+	}
+	return preview, nil
+}
+
+// looksBinary reports whether data, a prefix of a file, has a NUL byte, is not UTF-8 or is mostly control characters
+func looksBinary(data []byte) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	// the prefix may end within a multi-byte character
+	valid := false
+	for trim := 0; trim < utf8.UTFMax && trim <= len(data); trim++ {
+		if utf8.Valid(data[:len(data)-trim]) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return true
+	}
+	control := 0
+	for _, b := range data {
+		if b < ' ' && b != '\n' && b != '\r' && b != '\t' {
+			control++
+		}
+	}
+	return control*10 > len(data)
+}
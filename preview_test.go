@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestContentPreview(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"text.txt", "first line\nsecond\tline\x1b[0m"},
+		{"binary.bin", "\x7fELF\x00\x01\x02"},
+		{"utf8.txt", "héllo wörld"},
+		{"empty.txt", ""},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	for _, test := range []struct {
+		name string
+		size int64
+		n    int
+		want string
+	}{
+		{"text.txt", 26, 200, "first line second line.[0m"},
+		{"text.txt", 26, 10, "first line..."},
+		{"binary.bin", 7, 200, "binary, 7 bytes: 7f454c46000102..."},
+		// the prefix ends within the ö
+		{"utf8.txt", 13, 9, "héllo w..."},
+		{"empty.txt", 0, 200, ""},
+	} {
+		preview, err := ContentPreview(FileInfo{Path: filepath.Join(testDir, test.name), Size: test.size}, test.n)
+		if err != nil {
+			t.Errorf("Unexpected error previewing %s: %v", test.name, err)
+			continue
+		}
+		if preview != test.want {
+			t.Errorf("Unexpected preview of %s: got %q, want %q", test.name, preview, test.want)
+		}
+	}
+}
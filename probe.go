@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Sampler deterministically selects a fraction of files by their match key (relative path or file name),
+// so the same key is picked in both the reference and the target, and counts everything it sees
+type Sampler struct {
+	Rate           float64
+	Seed           int64
+	ExactPathMatch bool
+
+	mu         sync.Mutex
+	totalFiles int
+	totalBytes int64
+}
+
+// Filter returns a WalkOptions.Filter for a walk of root that keeps only sampled files
+func (s *Sampler) Filter(root string) func(path string, info os.FileInfo) bool {
+	return func(path string, info os.FileInfo) bool {
+		s.mu.Lock()
+		s.totalFiles++
+		s.totalBytes += info.Size()
+		s.mu.Unlock()
+
+		key := filepath.Base(path)
+		if s.ExactPathMatch {
+			key, _ = filepath.Rel(root, path)
+		}
+		return s.picks(key)
+	}
+}
+
+func (s *Sampler) picks(key string) bool {
+	hasher := fnv.New64a()
+	binary.Write(hasher, binary.LittleEndian, s.Seed)
+	hasher.Write([]byte(key))
+	return float64(mix64(hasher.Sum64())) < s.Rate*math.MaxUint64
+}
+
+// mix64 is the splitmix64 finalizer; FNV alone barely spreads keys that differ only in their last bytes
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Totals returns the number and size of all files seen by the filters, sampled or not
+func (s *Sampler) Totals() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totalFiles, s.totalBytes
+}
+
+// ProbeEstimate extrapolates the duplicates found in a sample to the whole tree
+type ProbeEstimate struct {
+	SampledDuplicates int
+	SampledBytes      int64
+	Duplicates        float64
+	DuplicatesMargin  float64 // half-width of an approximate 95% confidence interval for Duplicates
+	Bytes             float64
+}
+
+// EstimateDuplicates scales the duplicates found among files sampled at rate up to the full population.
+// Because sampling is keyed, each duplicate pair is found with probability rate, so the count is binomial.
+func EstimateDuplicates(sampled []FileInfo, rate float64) ProbeEstimate {
+	estimate := ProbeEstimate{SampledDuplicates: len(sampled), SampledBytes: totalSize(sampled)}
+	if rate <= 0 {
+		return estimate
+	}
+	k := float64(len(sampled))
+	estimate.Duplicates = k / rate
+	estimate.DuplicatesMargin = 1.96 * math.Sqrt(k*(1-rate)) / rate
+	estimate.Bytes = float64(estimate.SampledBytes) / rate
+	return estimate
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSamplerDeterministic(t *testing.T) {
+	a := &Sampler{Rate: 0.3, Seed: 42, ExactPathMatch: true}
+	b := &Sampler{Rate: 0.3, Seed: 42, ExactPathMatch: true}
+	c := &Sampler{Rate: 0.3, Seed: 7, ExactPathMatch: true}
+
+	picked, differs := 0, false
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("dir%d/file%d.txt", i%10, i)
+		if a.picks(key) != b.picks(key) {
+			t.Fatalf("Sampling of %s is not deterministic", key)
+		}
+		if a.picks(key) != c.picks(key) {
+			differs = true
+		}
+		if a.picks(key) {
+			picked++
+		}
+	}
+	if picked < 2700 || picked > 3300 {
+		t.Errorf("Sample size far from the rate: picked %d of 10000 at 30%%", picked)
+	}
+	if !differs {
+		t.Errorf("Different seeds picked the same sample")
+	}
+}
+
+func TestEstimateDuplicates(t *testing.T) {
+	sampled := []FileInfo{{Size: 10}, {Size: 30}}
+	estimate := EstimateDuplicates(sampled, 0.1)
+	if estimate.Duplicates != 20 || estimate.Bytes != 400 {
+		t.Errorf("Unexpected estimate: %+v", estimate)
+	}
+	if estimate.DuplicatesMargin <= 0 {
+		t.Errorf("Expected a positive margin: %+v", estimate)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+// staleLocksDetected tells whether lockFile takes over the lock of a process that no longer runs
+const staleLocksDetected = false
+
+// processAlive cannot tell whether a process runs on this platform, where os.FindProcess never fails
+func processAlive(pid int) (alive, known bool) {
+	return false, false
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// staleLocksDetected tells whether lockFile takes over the lock of a process that no longer runs
+const staleLocksDetected = true
+
+// stillActive is the exit code GetExitCodeProcess reports for a running process (STILL_ACTIVE)
+const stillActive = 259
+
+// processAlive tells whether a process with pid is running; known is always true on Windows
+func processAlive(pid int) (alive, known bool) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err == windows.ERROR_ACCESS_DENIED {
+		// it exists, but belongs to someone else
+		return true, true
+	}
+	if err != nil {
+		return false, true
+	}
+	defer windows.CloseHandle(handle)
+	var code uint32
+	if err := windows.GetExitCodeProcess(handle, &code); err != nil {
+		return true, true
+	}
+	return code == stillActive, true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// cleanupFuncs run before the process exits, including on error paths that call exit
+var cleanupFuncs []func()
+
+// exit runs the registered cleanup functions and then terminates the process with code
+func exit(code int) {
+	runCleanup()
+	os.Exit(code)
+}
+
+func runCleanup() {
+	for i := len(cleanupFuncs) - 1; i >= 0; i-- {
+		cleanupFuncs[i]()
+	}
+	cleanupFuncs = nil
+}
+
+// startProfiling starts CPU profiling into cpuProfilePath and arranges for a heap profile to be
+// written to memProfilePath at exit. Either path may be empty to skip that profile.
+func startProfiling(cpuProfilePath, memProfilePath string) error {
+	if cpuProfilePath != "" {
+		cpuFile, err := os.Create(cpuProfilePath)
+		if err != nil {
+			return err
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return err
+		}
+		var once sync.Once
+		cleanupFuncs = append(cleanupFuncs, func() {
+			once.Do(func() {
+				pprof.StopCPUProfile()
+				cpuFile.Close()
+			})
+		})
+	}
+
+	if memProfilePath != "" {
+		cleanupFuncs = append(cleanupFuncs, func() {
+			memFile, err := os.Create(memProfilePath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating memory profile: %v\n", err)
+				return
+			}
+			defer memFile.Close()
+			runtime.GC() // get up-to-date statistics
+			if err := pprof.WriteHeapProfile(memFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing memory profile: %v\n", err)
+			}
+		})
+	}
+	return nil
+}
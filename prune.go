@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PruneEmptyDirs removes directories under baseDir that became empty after the given files were removed,
+// deepest first, never removing baseDir itself. Directories that did not contain any of the removed files
+// are left alone unless includePreexisting is set, in which case every empty directory under baseDir is pruned.
+// It returns the number of directories removed.
+func PruneEmptyDirs(baseDir string, removed []FileInfo, includePreexisting bool) (int, error) {
+	baseDir = filepath.Clean(baseDir)
+
+	candidates := make(map[string]bool)
+	for _, file := range removed {
+		for dir := filepath.Dir(filepath.Clean(file.Path)); isStrictlyUnder(dir, baseDir); dir = filepath.Dir(dir) {
+			candidates[dir] = true
+		}
+	}
+	if includePreexisting {
+		err := filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != baseDir {
+				candidates[path] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	dirs := make([]string, 0, len(candidates))
+	for dir := range candidates {
+		dirs = append(dirs, dir)
+	}
+	// deepest first, so parents are only checked after their children had a chance to go
+	sort.Slice(dirs, func(i, j int) bool {
+		di, dj := strings.Count(dirs[i], string(filepath.Separator)), strings.Count(dirs[j], string(filepath.Separator))
+		if di != dj {
+			return di > dj
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	pruned := 0
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return pruned, err
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if err := os.Remove(dir); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// isStrictlyUnder reports whether path is inside baseDir and not baseDir itself
+func isStrictlyUnder(path, baseDir string) bool {
+	relPath, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return false
+	}
+	return relPath != "." && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneEmptyDirs(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a/b/dupe.txt", "dupe"},
+		{"a/keep.txt", "keep"},
+		{"c/d/dupe.txt", "dupe"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	os.MkdirAll(filepath.Join(testDir, "preexisting/empty"), 0755)
+
+	removed := []FileInfo{
+		{Path: filepath.Join(testDir, "a/b/dupe.txt")},
+		{Path: filepath.Join(testDir, "c/d/dupe.txt")},
+	}
+	if err := DeleteFiles(removed); err != nil {
+		t.Fatalf("Error deleting files: %v", err)
+	}
+
+	pruned, err := PruneEmptyDirs(testDir, removed, false)
+	if err != nil {
+		t.Fatalf("Error pruning empty directories: %v", err)
+	}
+	if pruned != 3 {
+		t.Errorf("Unexpected number of pruned directories: got %d, want 3", pruned)
+	}
+	for relPath, shouldExist := range map[string]bool{
+		"a":                 true,
+		"a/b":               false,
+		"c":                 false,
+		"preexisting/empty": true,
+	} {
+		_, err := os.Stat(filepath.Join(testDir, relPath))
+		if exists := err == nil; exists != shouldExist {
+			t.Errorf("Unexpected existence of %s: got %v, want %v", relPath, exists, shouldExist)
+		}
+	}
+	if _, err := os.Stat(testDir); err != nil {
+		t.Errorf("Base directory was removed: %v", err)
+	}
+
+	pruned, err = PruneEmptyDirs(testDir, nil, true)
+	if err != nil {
+		t.Fatalf("Error pruning preexisting empty directories: %v", err)
+	}
+	if pruned != 2 {
+		t.Errorf("Unexpected number of pruned preexisting directories: got %d, want 2", pruned)
+	}
+}
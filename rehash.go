@@ -0,0 +1,39 @@
+package main
+
+import "fmt"
+
+// RehashVerifier returns a DeleteOptions.Verify check that re-hashes each duplicate and the reference file it
+// duplicates from disk, and fails if either no longer has the hash the duplicate was found by (the body hash if
+// body is not zero). refPaths maps the path of each duplicate to its reference file, which must be readable.
+func RehashVerifier(refPaths map[string]string, body BodyRange) func(file FileInfo) error {
+	return func(file FileInfo) error {
+		refPath, ok := refPaths[file.Path]
+		if !ok {
+			return fmt.Errorf("no reference file known for %s", file.Path)
+		}
+		recorded := file.Hash
+		if !body.IsZero() {
+			recorded = file.BodyHash
+		}
+		for _, path := range []string{file.Path, refPath} {
+			current := FileInfo{Path: path, HashAlgo: file.HashAlgo}
+			var err error
+			if body.IsZero() {
+				err = current.CalculateHash()
+			} else {
+				err = current.CalculateHashes(body)
+			}
+			if err != nil {
+				return fmt.Errorf("re-hashing %s: %w", path, err)
+			}
+			hash := current.Hash
+			if !body.IsZero() {
+				hash = current.BodyHash
+			}
+			if hash != recorded {
+				return fmt.Errorf("%s changed since it was scanned", path)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRehashVerifier(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref/a.txt", "same"},
+		{"ref/b.txt", "same"},
+		{"target/a.txt", "same"},
+		{"target/b.txt", "same"},
+		{"target/c.txt", "same"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	path := func(name string) string { return filepath.Join(testDir, name) }
+
+	var files []FileInfo
+	for _, name := range []string{"target/a.txt", "target/b.txt", "target/c.txt"} {
+		file := FileInfo{Path: path(name)}
+		if err := file.CalculateHash(); err != nil {
+			t.Fatalf("Failed to hash %s: %v", name, err)
+		}
+		files = append(files, file)
+	}
+	refPaths := map[string]string{
+		path("target/a.txt"): path("ref/a.txt"),
+		path("target/b.txt"): path("ref/b.txt"),
+		path("target/c.txt"): path("ref/a.txt"),
+	}
+
+	// the reference of b and the target c change after the scan
+	if err := os.WriteFile(path("ref/b.txt"), []byte("edited"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	if err := os.WriteFile(path("target/c.txt"), []byte("edited"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+
+	var skipped []string
+	_, err = ConfirmAndDelete(files, DeleteOptions{
+		Confirm: AlwaysConfirm,
+		Verify:  RehashVerifier(refPaths, BodyRange{}),
+		Skipped: func(file FileInfo, err error) { skipped = append(skipped, file.Path) },
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error deleting files: %v", err)
+	}
+	if len(skipped) != 2 || skipped[0] != path("target/b.txt") || skipped[1] != path("target/c.txt") {
+		t.Errorf("Unexpected skipped files: %v", skipped)
+	}
+	for name, wantExists := range map[string]bool{"target/a.txt": false, "target/b.txt": true, "target/c.txt": true} {
+		if _, err := os.Stat(path(name)); (err == nil) != wantExists {
+			t.Errorf("Unexpected state of %s: exists %v, want %v", name, err == nil, wantExists)
+		}
+	}
+}
+
+func TestRehashVerifierRightBeforeRemove(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref/a.txt", "same"},
+		{"target/a.txt", "same"},
+		{"target/b.txt", "same"},
+		{"target/c.txt", "same"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	path := func(name string) string { return filepath.Join(testDir, name) }
+
+	var files []FileInfo
+	refPaths := make(map[string]string)
+	for _, name := range []string{"target/a.txt", "target/b.txt", "target/c.txt"} {
+		file := FileInfo{Path: path(name)}
+		if err := file.CalculateHash(); err != nil {
+			t.Fatalf("Failed to hash %s: %v", name, err)
+		}
+		files = append(files, file)
+		refPaths[file.Path] = path("ref/a.txt")
+	}
+
+	verify := RehashVerifier(refPaths, BodyRange{})
+	keep := func(file FileInfo) bool { return verify(file) != nil }
+	// c changes once its neighbours a and b are verified and a is already gone, all in one batch
+	removed := func(file FileInfo) {
+		if file.Path == path("target/a.txt") {
+			if err := os.WriteFile(path("target/c.txt"), []byte("edited"), 0644); err != nil {
+				t.Fatalf("Failed to modify file: %v", err)
+			}
+		}
+	}
+	var deleted []FileInfo
+	err = deleteInBatches(files, 0, 0, keep, removed, func(batch []FileInfo, processed int) error {
+		deleted = append(deleted, batch...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error deleting files: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("Unexpected deleted files: %v", deleted)
+	}
+	if _, err := os.Stat(path("target/c.txt")); err != nil {
+		t.Errorf("Expected target/c.txt, changed after the others were verified, to be kept: %v", err)
+	}
+}
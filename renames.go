@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Rename is a reference file whose content appears in the target only at a different relative path
+type Rename struct {
+	Ref    FileInfo
+	Target FileInfo
+}
+
+// FindRenames pairs reference and target files that have the same size and content but different
+// relative paths, where the reference path no longer holds that content in the target. Such pairs are
+// moves rather than redundant copies, which exact-path comparison would otherwise simply not report.
+// Each file is part of at most one rename; within a group of identical files, paths are paired in sorted order.
+func FindRenames(refDir *DirectoryInfo, targetDir *DirectoryInfo, opts CompareOptions) []Rename {
+	// movedFiles groups the files of dirInfo by size and content, leaving out those whose content
+	// is found at the same relative path on the other side
+	movedFiles := func(dirInfo *DirectoryInfo, otherKeys map[string]bool) map[string][]FileInfo {
+		moved := make(map[string][]FileInfo) // map[size+content][]file
+		for _, file := range dirInfo.Files {
+			content := contentKey(file, opts)
+			if content == "" {
+				continue
+			}
+			if otherKeys[content+"\x00"+matchKey(dirInfo, file, true)] {
+				continue
+			}
+			key := fmt.Sprintf("%d\x00%s", file.Size, content)
+			moved[key] = append(moved[key], file)
+		}
+		return moved
+	}
+	// keys returns the content and relative path of every file of dirInfo
+	keys := func(dirInfo *DirectoryInfo) map[string]bool {
+		keys := make(map[string]bool)
+		for _, file := range dirInfo.Files {
+			if content := contentKey(file, opts); content != "" {
+				keys[content+"\x00"+matchKey(dirInfo, file, true)] = true
+			}
+		}
+		return keys
+	}
+
+	movedFromRef := movedFiles(refDir, keys(targetDir))
+	movedInTarget := movedFiles(targetDir, keys(refDir))
+
+	var renames []Rename
+	for key, refFiles := range movedFromRef {
+		targetFiles := movedInTarget[key]
+		if len(targetFiles) == 0 {
+			continue
+		}
+		sort.Slice(refFiles, func(i, j int) bool { return refFiles[i].Path < refFiles[j].Path })
+		sort.Slice(targetFiles, func(i, j int) bool { return targetFiles[i].Path < targetFiles[j].Path })
+		for i := 0; i < len(refFiles) && i < len(targetFiles); i++ {
+			renames = append(renames, Rename{Ref: refFiles[i], Target: targetFiles[i]})
+		}
+	}
+	sort.Slice(renames, func(i, j int) bool { return renames[i].Target.Path < renames[j].Target.Path })
+	return renames
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestFindRenames(t *testing.T) {
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/old/a.txt", Hash: "aaa", Size: 3},
+		{Path: "/ref/kept.txt", Hash: "kkk", Size: 3},
+		{Path: "/ref/copied.txt", Hash: "ccc", Size: 3},
+	}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{
+		{Path: "/target/new/a.txt", Hash: "aaa", Size: 3},
+		{Path: "/target/kept.txt", Hash: "kkk", Size: 3},
+		{Path: "/target/copied.txt", Hash: "ccc", Size: 3},
+		{Path: "/target/copy-of-copied.txt", Hash: "ccc", Size: 3}, // the original is still in place, so not a rename
+		{Path: "/target/new.txt", Hash: "nnn", Size: 3},
+	}}
+
+	renames := FindRenames(refDir, targetDir, CompareOptions{ExactPathMatch: true})
+	if len(renames) != 1 {
+		t.Fatalf("Unexpected number of renames: got %d, want 1: %+v", len(renames), renames)
+	}
+	if renames[0].Ref.Path != "/ref/old/a.txt" || renames[0].Target.Path != "/target/new/a.txt" {
+		t.Errorf("Unexpected rename: %s -> %s", renames[0].Ref.Path, renames[0].Target.Path)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// DirectoryDigest returns a digest of everything recorded about dirInfo: its base directory and every field of
+// every file, independent of the order of the files. Any change to a file's path, content hash, size or
+// modification time changes it.
+func DirectoryDigest(dirInfo *DirectoryInfo) (string, error) {
+	files := append([]FileInfo(nil), dirInfo.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%q\n", dirInfo.BaseDir)
+	encoder := json.NewEncoder(hasher)
+	for _, file := range files {
+		if err := encoder.Encode(file); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// ComparisonKey digests everything CompareFilesWithOptions depends on: both trees and every field of the options.
+// Path normalizers are functions and cannot be digested, so callers using them describe them in settings,
+// along with anything else that changes their results. Hooks do not change the results and are left out.
+func ComparisonKey(ref, target *DirectoryInfo, opts CompareOptions, settings string) (string, error) {
+	refDigest, err := DirectoryDigest(ref)
+	if err != nil {
+		return "", err
+	}
+	targetDigest, err := DirectoryDigest(target)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\n%s\n", refDigest, targetDigest)
+	// by field rather than by a list of options, so a new option cannot be forgotten here
+	value := reflect.ValueOf(opts)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if kind := field.Type.Kind(); kind == reflect.Func || kind == reflect.Ptr {
+			continue
+		}
+		fmt.Fprintf(hasher, "%s %v\n", field.Name, value.Field(i))
+	}
+	fmt.Fprintf(hasher, "%q\n", settings)
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// resultCacheFile is the content of a result cache: the duplicates of the last comparison and its key
+type resultCacheFile struct {
+	Key        string      `json:"key"`
+	Duplicates []Duplicate `json:"duplicates"`
+}
+
+// LoadCachedResult returns the duplicates stored in the cache file at path if they were stored under key, that
+// is for the same inputs. A missing cache, or one for other inputs, is a miss rather than an error.
+func LoadCachedResult(path, key string) ([]Duplicate, bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	var cached resultCacheFile
+	if err := json.NewDecoder(file).Decode(&cached); err != nil && err != io.EOF {
+		return nil, false, fmt.Errorf("reading result cache %s: %w", path, err)
+	}
+	if cached.Key != key {
+		return nil, false, nil
+	}
+	return cached.Duplicates, true, nil
+}
+
+// StoreCachedResult replaces the cache file at path with duplicates stored under key. The file is written in
+// full before it replaces the previous one, so an interrupted run never leaves a truncated cache behind.
+func StoreCachedResult(path, key string, duplicates []Duplicate) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if err := json.NewEncoder(temp).Encode(resultCacheFile{Key: key, Duplicates: duplicates}); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
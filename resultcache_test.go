@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirectoryDigest(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	dirInfo := &DirectoryInfo{BaseDir: "/data", Files: []FileInfo{
+		{Path: "/data/a", Hash: "1", Size: 1, ModTime: modTime},
+		{Path: "/data/b", Hash: "2", Size: 2, ModTime: modTime},
+	}}
+	digest, err := DirectoryDigest(dirInfo)
+	if err != nil {
+		t.Fatalf("Error digesting: %v", err)
+	}
+
+	reordered := &DirectoryInfo{BaseDir: "/data", Files: []FileInfo{dirInfo.Files[1], dirInfo.Files[0]}}
+	if got, _ := DirectoryDigest(reordered); got != digest {
+		t.Errorf("Unexpected digest change from the file order: got %s, want %s", got, digest)
+	}
+
+	for name, change := range map[string]func(file *FileInfo){
+		"hash":    func(file *FileInfo) { file.Hash = "3" },
+		"size":    func(file *FileInfo) { file.Size = 3 },
+		"modTime": func(file *FileInfo) { file.ModTime = modTime.Add(time.Second) },
+		"path":    func(file *FileInfo) { file.Path = "/data/c" },
+	} {
+		changed := &DirectoryInfo{BaseDir: "/data", Files: append([]FileInfo(nil), dirInfo.Files...)}
+		change(&changed.Files[0])
+		if got, _ := DirectoryDigest(changed); got == digest {
+			t.Errorf("Digest unchanged after changing the %s of a file", name)
+		}
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	testDir, err := createTestFiles(nil)
+	if err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	cachePath := filepath.Join(testDir, "results.json")
+
+	ref := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{{Path: "/ref/a", Hash: "1"}}}
+	target := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{{Path: "/target/a", Hash: "1"}, {Path: "/target/b", Hash: "2"}}}
+	key, err := ComparisonKey(ref, target, CompareOptions{ExactPathMatch: true}, "")
+	if err != nil {
+		t.Fatalf("Error computing key: %v", err)
+	}
+	if other, _ := ComparisonKey(ref, target, CompareOptions{}, ""); other == key {
+		t.Error("Key unchanged by different options")
+	}
+	if other, _ := ComparisonKey(ref, target, CompareOptions{ExactPathMatch: true, NameAndSize: true}, ""); other == key {
+		t.Error("Key unchanged by matching by name and size")
+	}
+	if other, _ := ComparisonKey(ref, target, CompareOptions{ExactPathMatch: true, Hooks: &Hooks{}}, ""); other != key {
+		t.Error("Key changed by hooks")
+	}
+	if other, _ := ComparisonKey(ref, target, CompareOptions{ExactPathMatch: true}, "lower"); other == key {
+		t.Error("Key unchanged by different settings")
+	}
+	if other, _ := ComparisonKey(target, ref, CompareOptions{ExactPathMatch: true}, ""); other == key {
+		t.Error("Key unchanged by swapping reference and target")
+	}
+
+	if _, hit, err := LoadCachedResult(cachePath, key); hit || err != nil {
+		t.Errorf("Unexpected result for a missing cache: %v, %v", hit, err)
+	}
+	duplicates := CompareFilesStream(ref, target, CompareOptions{ExactPathMatch: true})
+	var found []Duplicate
+	for duplicate := range duplicates {
+		found = append(found, duplicate)
+	}
+	if err := StoreCachedResult(cachePath, key, found); err != nil {
+		t.Fatalf("Error storing result: %v", err)
+	}
+
+	cached, hit, err := LoadCachedResult(cachePath, key)
+	if err != nil || !hit {
+		t.Fatalf("Unexpected cache miss: %v", err)
+	}
+	if len(cached) != 1 || cached[0].File.Path != "/target/a" || cached[0].RefPath != "/ref/a" || cached[0].File.Hash != "1" {
+		t.Errorf("Unexpected cached duplicates: %+v", cached)
+	}
+	if _, hit, _ := LoadCachedResult(cachePath, "another key"); hit {
+		t.Error("Unexpected cache hit for other inputs")
+	}
+
+	if err := os.WriteFile(cachePath, []byte("{broken"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt cache: %v", err)
+	}
+	if _, hit, err := LoadCachedResult(cachePath, key); hit || err == nil {
+		t.Errorf("Unexpected result for a corrupt cache: %v, %v", hit, err)
+	}
+}
+
+// BenchmarkResultCache compares a cache hit, which digests both trees to find its key, with comparing them
+func BenchmarkResultCache(b *testing.B) {
+	testDir, err := os.MkdirTemp("", "resultcachebench")
+	if err != nil {
+		b.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	ref := &DirectoryInfo{BaseDir: "/ref", Files: make([]FileInfo, benchmarkManifestEntries)}
+	target := &DirectoryInfo{BaseDir: "/target", Files: make([]FileInfo, benchmarkManifestEntries)}
+	for i := range ref.Files {
+		ref.Files[i] = FileInfo{Path: fmt.Sprintf("/ref/dir%d/file%d.txt", i%1000, i), Hash: fmt.Sprintf("%064x", i), Size: int64(i)}
+		target.Files[i] = FileInfo{Path: fmt.Sprintf("/target/dir%d/file%d.txt", i%1000, i), Hash: fmt.Sprintf("%064x", i%2), Size: int64(i)}
+	}
+	opts := CompareOptions{ExactPathMatch: true}
+	cachePath := filepath.Join(testDir, "results.json")
+	key, err := ComparisonKey(ref, target, opts, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := StoreCachedResult(cachePath, key, nil); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("compare", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			CompareFilesWithOptions(ref, target, opts)
+		}
+	})
+	b.Run("cacheHit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			key, err := ComparisonKey(ref, target, opts, "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, hit, err := LoadCachedResult(cachePath, key); !hit || err != nil {
+				b.Fatalf("Unexpected cache miss: %v", err)
+			}
+		}
+	})
+}
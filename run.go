@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// Options configures Run, a plain comparison of a reference and a target tree as the command line does it
+type Options struct {
+	// RefDir is walked with RefWalk unless RefIndex or RefYaml name a binary index or YAML manifest of it
+	RefDir   string
+	RefYaml  string
+	RefIndex string
+	// TargetDir is walked with TargetWalk unless TargetYaml names a YAML manifest of it
+	TargetDir  string
+	TargetYaml string
+
+	RefWalk    WalkOptions
+	TargetWalk WalkOptions
+	Compare    CompareOptions
+
+	// LoadRef and LoadTarget, if set, load a side in place of Run, e.g. to check a manifest or lock the target
+	// on the way. They get RefWalk or TargetWalk with the hooks Run collects errors and busy files through.
+	// TargetYaml still tells Run whether the target is walked, and so whether to check hashes before loading it.
+	LoadRef    func(walkOpts WalkOptions) (*DirectoryInfo, error)
+	LoadTarget func(walkOpts WalkOptions) (*DirectoryInfo, error)
+	// BeforeCompare, if set, is called once both trees are loaded and their hashes checked. Returning false ends
+	// Run there, with the trees but no duplicates, for callers that report on the trees some other way.
+	BeforeCompare func(ref, target *DirectoryInfo) bool
+}
+
+// FileError is a file or directory a walk skipped because of Err (see WalkOptions.SkipErrors)
+type FileError struct {
+	Path string
+	Err  error
+}
+
+// DedupResult is everything a comparison found
+type DedupResult struct {
+	Ref    *DirectoryInfo
+	Target *DirectoryInfo
+	// Duplicates are the target files duplicating a reference file, in target order
+	Duplicates []Duplicate
+	// Clusters group Duplicates by the reference file they duplicate
+	Clusters []DuplicateCluster
+	// Summary counts the target files and their duplicates, and lists the files skipped as busy by either walk
+	Summary Summary
+	// Errors are the paths either walk skipped, ordered by when they were hit
+	Errors []FileError
+}
+
+// Run loads or walks the reference and the target, refusing hashes that cannot be compared (see
+// CheckHashSpecs), and compares them. Hooks set in opts still receive their events. A walk failure is returned
+// as an error, unless the walk skips errors, which then end up in DedupResult.Errors.
+func Run(opts Options) (*DedupResult, error) {
+	var mu sync.Mutex
+	var fileErrors []FileError
+	var busy []string
+	collect := func(walkOpts WalkOptions) WalkOptions {
+		hooks := walkOpts.Hooks.clone()
+		onError, onComplete := hooks.OnError, hooks.OnComplete
+		hooks.OnError = func(path string, err error) {
+			mu.Lock()
+			fileErrors = append(fileErrors, FileError{Path: path, Err: err})
+			mu.Unlock()
+			if onError != nil {
+				onError(path, err)
+			}
+		}
+		hooks.OnComplete = func(summary Summary) {
+			mu.Lock()
+			busy = append(busy, summary.Busy...)
+			mu.Unlock()
+			if onComplete != nil {
+				onComplete(summary)
+			}
+		}
+		walkOpts.Hooks = hooks
+		return walkOpts
+	}
+
+	loadRef, loadTarget := opts.LoadRef, opts.LoadTarget
+	if loadRef == nil {
+		loadRef = func(walkOpts WalkOptions) (*DirectoryInfo, error) {
+			return loadDirectory(opts.RefDir, opts.RefYaml, opts.RefIndex, walkOpts)
+		}
+	}
+	if loadTarget == nil {
+		loadTarget = func(walkOpts WalkOptions) (*DirectoryInfo, error) {
+			return loadDirectory(opts.TargetDir, opts.TargetYaml, "", walkOpts)
+		}
+	}
+
+	ref, err := loadRef(collect(opts.RefWalk))
+	if err != nil {
+		return nil, err
+	}
+	targetWalk := collect(opts.TargetWalk)
+	if opts.TargetYaml == "" {
+		// checked before the target is hashed rather than after
+		if err := CheckHashSpecs(ref.HashSpec, targetWalk.hashSpec(), opts.Compare); err != nil {
+			return nil, err
+		}
+	}
+	target, err := loadTarget(targetWalk)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TargetYaml != "" {
+		if err := CheckHashSpecs(ref.HashSpec, target.HashSpec, opts.Compare); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &DedupResult{Ref: ref, Target: target}
+	if opts.BeforeCompare == nil || opts.BeforeCompare(ref, target) {
+		result = CompareDirectories(ref, target, opts.Compare)
+	}
+	result.Errors = fileErrors
+	result.Summary.Busy = busy
+	return result, nil
+}
+
+// loadDirectory reads the index at indexPath, or else the YAML manifest at yamlPath, or else walks dir
+func loadDirectory(dir, yamlPath, indexPath string, walkOpts WalkOptions) (*DirectoryInfo, error) {
+	switch {
+	case indexPath != "":
+		return ReadIndex(indexPath)
+	case yamlPath != "":
+		return readDirectoryInfoFromYAML(yamlPath)
+	case dir != "":
+		return WalkDirectoryWithOptions(dir, walkOpts)
+	}
+	return nil, errors.New("a directory, YAML manifest or index must be given")
+}
+
+// CompareDirectories compares already loaded trees, the part of Run after loading them
+func CompareDirectories(ref, target *DirectoryInfo, opts CompareOptions) *DedupResult {
+	result := &DedupResult{Ref: ref, Target: target}
+	hooks := opts.Hooks.clone()
+	onComplete := hooks.OnComplete
+	hooks.OnComplete = func(summary Summary) {
+		result.Summary = summary
+		if onComplete != nil {
+			onComplete(summary)
+		}
+	}
+	opts.Hooks = hooks
+
+	compareFiles(ref, target, opts, func(duplicate Duplicate) {
+		result.Duplicates = append(result.Duplicates, duplicate)
+	})
+	result.Clusters = ClustersFromDuplicates(result.Duplicates)
+	return result
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref/a.txt", "aaaa"},
+		{"ref/b.txt", "bbbb"},
+		{"target/a.txt", "aaaa"},
+		{"target/copy/a.txt", "aaaa"},
+		{"target/b.txt", "changed"},
+		{"target/vanishing.txt", "gone before it is hashed"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	vanishing := filepath.Join(testDir, "target", "vanishing.txt")
+	var hooked []string
+	result, err := Run(Options{
+		RefDir:    filepath.Join(testDir, "ref"),
+		TargetDir: filepath.Join(testDir, "target"),
+		TargetWalk: WalkOptions{
+			SkipErrors: true,
+			Hooks:      &Hooks{OnError: func(path string, err error) { hooked = append(hooked, path) }},
+			// deleting the file once it is found makes hashing it fail
+			Filter: func(path string, info os.FileInfo) bool {
+				if path == vanishing {
+					os.Remove(path)
+				}
+				return true
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(result.Ref.Files) != 2 || len(result.Target.Files) != 3 {
+		t.Errorf("Unexpected trees: %d reference and %d target files, want 2 and 3", len(result.Ref.Files), len(result.Target.Files))
+	}
+	if len(result.Duplicates) != 2 {
+		t.Errorf("Unexpected duplicates: %v", result.Duplicates)
+	}
+	if len(result.Clusters) != 1 || len(result.Clusters[0].Members) != 3 || result.Clusters[0].Keeper != filepath.Join(testDir, "ref", "a.txt") {
+		t.Errorf("Unexpected clusters: %+v", result.Clusters)
+	}
+	if want := (Summary{Files: 3, Bytes: 15, Duplicates: 2, DuplicateBytes: 8}); result.Summary.Files != want.Files || result.Summary.Bytes != want.Bytes ||
+		result.Summary.Duplicates != want.Duplicates || result.Summary.DuplicateBytes != want.DuplicateBytes {
+		t.Errorf("Unexpected summary: got %+v, want %+v", result.Summary, want)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Path != vanishing || !errors.Is(result.Errors[0].Err, fs.ErrNotExist) {
+		t.Errorf("Unexpected errors: %+v", result.Errors)
+	}
+	if len(hooked) != 1 || hooked[0] != vanishing {
+		t.Errorf("Unexpected errors passed to the hook: %v", hooked)
+	}
+
+	// hashes that cannot be compared fail before the target is walked
+	walked := false
+	_, err = Run(Options{
+		RefDir:     filepath.Join(testDir, "ref"),
+		TargetDir:  filepath.Join(testDir, "target"),
+		TargetWalk: WalkOptions{Hash: HashPolicy{Default: "xxhash"}, Hooks: &Hooks{OnFileHashed: func(FileInfo) { walked = true }}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "xxhash") || walked {
+		t.Errorf("Unexpected result comparing different algorithms: %v, walked %v", err, walked)
+	}
+
+	if _, err := Run(Options{RefDir: filepath.Join(testDir, "ref")}); err == nil {
+		t.Error("Unexpected success without a target")
+	}
+}
+
+func TestRunLoadersAndHooks(t *testing.T) {
+	ref := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{{Path: "/ref/a.txt", Hash: "aaa", Size: 3}}}
+	target := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{{Path: "/target/a.txt", Hash: "aaa", Size: 3}}}
+	load := func(dirInfo *DirectoryInfo) func(walkOpts WalkOptions) (*DirectoryInfo, error) {
+		return func(walkOpts WalkOptions) (*DirectoryInfo, error) {
+			// a loader reports through the hooks Run collects from
+			walkOpts.Hooks.error(dirInfo.BaseDir+"/unreadable", errors.New("permission denied"))
+			return dirInfo, nil
+		}
+	}
+
+	var found []string
+	var completed bool
+	result, err := Run(Options{
+		LoadRef:    load(ref),
+		LoadTarget: load(target),
+		Compare: CompareOptions{ExactPathMatch: true, Hooks: &Hooks{
+			OnDuplicateFound: func(file FileInfo, refPath string) { found = append(found, file.Path) },
+			OnComplete:       func(Summary) { completed = true },
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Duplicates) != 1 || len(found) != 1 || found[0] != "/target/a.txt" || !completed {
+		t.Errorf("Unexpected duplicates: %v, passed to the hook: %v, completed %v", result.Duplicates, found, completed)
+	}
+	if len(result.Errors) != 2 {
+		t.Errorf("Unexpected errors from the loaders: %+v", result.Errors)
+	}
+
+	// stopping before the comparison keeps the trees but finds nothing
+	found = nil
+	result, err = Run(Options{
+		LoadRef:       load(ref),
+		LoadTarget:    load(target),
+		Compare:       CompareOptions{ExactPathMatch: true, Hooks: &Hooks{OnDuplicateFound: func(file FileInfo, refPath string) { found = append(found, file.Path) }}},
+		BeforeCompare: func(ref, target *DirectoryInfo) bool { return false },
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Ref != ref || result.Target != target || len(result.Duplicates) != 0 || len(found) != 0 || len(result.Errors) != 2 {
+		t.Errorf("Unexpected result stopping before the comparison: %+v, found %v", result, found)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ScanStats counts the progress of walks for live monitoring, see EmitScanStats. The walk updates it with
+// atomics only, so watching a scan never slows it down. One ScanStats can be shared by consecutive walks,
+// e.g. of the reference and then the target.
+type ScanStats struct {
+	start        time.Time
+	filesFound   atomic.Int64
+	filesStarted atomic.Int64
+	filesDone    atomic.Int64
+	bytesDone    atomic.Int64
+	errors       atomic.Int64
+	busyWorkers  atomic.Int64
+	workers      atomic.Int64
+}
+
+// NewScanStats returns a ScanStats whose elapsed time starts now
+func NewScanStats() *ScanStats {
+	return &ScanStats{start: time.Now()}
+}
+
+// StatsSnapshot is the state of a ScanStats at one moment, as written by EmitScanStats
+type StatsSnapshot struct {
+	Time           time.Time `json:"time"`
+	ElapsedSeconds float64   `json:"elapsedSeconds"`
+	// FilesFound are the files the walk selected for hashing; FilesHashed and BytesHashed count those done,
+	// including ones that failed
+	FilesFound  int64 `json:"filesFound"`
+	FilesHashed int64 `json:"filesHashed"`
+	BytesHashed int64 `json:"bytesHashed"`
+	Errors      int64 `json:"errors"`
+	// FilesPerSecond and BytesPerSecond are rates since the previous snapshot, or since the start for the first
+	FilesPerSecond float64 `json:"filesPerSecond"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	// QueueDepth are the files found but not yet picked up by a hash worker
+	QueueDepth int64 `json:"queueDepth"`
+	// BusyWorkers of Workers hash workers are hashing a file; Utilization is their ratio
+	BusyWorkers int64   `json:"busyWorkers"`
+	Workers     int64   `json:"workers"`
+	Utilization float64 `json:"utilization"`
+	// Final marks the last snapshot, written when the emitter stops
+	Final bool `json:"final,omitempty"`
+}
+
+// Snapshot returns the current counters, with rates averaged since the start
+func (s *ScanStats) Snapshot() StatsSnapshot {
+	return s.snapshotSince(StatsSnapshot{Time: s.start})
+}
+
+// snapshotSince returns the current counters, with rates over the time since previous
+func (s *ScanStats) snapshotSince(previous StatsSnapshot) StatsSnapshot {
+	now := time.Now()
+	snapshot := StatsSnapshot{
+		Time:           now,
+		ElapsedSeconds: now.Sub(s.start).Seconds(),
+		FilesFound:     s.filesFound.Load(),
+		FilesHashed:    s.filesDone.Load(),
+		BytesHashed:    s.bytesDone.Load(),
+		Errors:         s.errors.Load(),
+		QueueDepth:     s.filesFound.Load() - s.filesStarted.Load(),
+		BusyWorkers:    s.busyWorkers.Load(),
+		Workers:        s.workers.Load(),
+	}
+	if seconds := now.Sub(previous.Time).Seconds(); seconds > 0 {
+		snapshot.FilesPerSecond = float64(snapshot.FilesHashed-previous.FilesHashed) / seconds
+		snapshot.BytesPerSecond = float64(snapshot.BytesHashed-previous.BytesHashed) / seconds
+	}
+	if snapshot.Workers > 0 {
+		snapshot.Utilization = float64(snapshot.BusyWorkers) / float64(snapshot.Workers)
+	}
+	return snapshot
+}
+
+// The walk reports through these methods, which accept a nil ScanStats
+
+func (s *ScanStats) walkStarted(workers int) {
+	if s != nil {
+		s.workers.Add(int64(workers))
+	}
+}
+
+func (s *ScanStats) walkFinished(workers int) {
+	if s != nil {
+		s.workers.Add(-int64(workers))
+	}
+}
+
+func (s *ScanStats) fileFound() {
+	if s != nil {
+		s.filesFound.Add(1)
+	}
+}
+
+func (s *ScanStats) fileStarted() {
+	if s != nil {
+		s.filesStarted.Add(1)
+		s.busyWorkers.Add(1)
+	}
+}
+
+func (s *ScanStats) fileDone(size int64, err error) {
+	if s == nil {
+		return
+	}
+	s.busyWorkers.Add(-1)
+	s.filesDone.Add(1)
+	s.bytesDone.Add(size)
+	if err != nil {
+		s.errors.Add(1)
+	}
+}
+
+// walkError counts a path the walk could not read
+func (s *ScanStats) walkError() {
+	if s != nil {
+		s.errors.Add(1)
+	}
+}
+
+// EmitScanStats writes a StatsSnapshot of stats to w as a JSON line every interval, until the returned stop
+// function is called, which writes a last snapshot marked final. Writing stops at the first error, which stop
+// returns, so a monitoring reader that goes away never disturbs the scan.
+func EmitScanStats(stats *ScanStats, w io.Writer, interval time.Duration) (stop func() error) {
+	encoder := json.NewEncoder(w)
+	previous := StatsSnapshot{Time: stats.start}
+	var writeErr error
+	emit := func(final bool) {
+		if writeErr != nil {
+			return
+		}
+		snapshot := stats.snapshotSince(previous)
+		if final {
+			// the last line sums up the whole run
+			snapshot = stats.Snapshot()
+			snapshot.Final = true
+		}
+		writeErr = encoder.Encode(snapshot)
+		previous = snapshot
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				emit(false)
+			case <-done:
+				emit(true)
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() error {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+		return writeErr
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScanStatsCountsWalk(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "12345"},
+		{"sub/b.txt", "1234567890"},
+		{"sub/c.txt", ""},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	stats := NewScanStats()
+	if _, err := WalkDirectoryWithOptions(testDir, WalkOptions{HashWorkers: 2, Stats: stats}); err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	snapshot := stats.Snapshot()
+	if snapshot.FilesFound != 3 || snapshot.FilesHashed != 3 || snapshot.BytesHashed != 15 {
+		t.Errorf("Unexpected counts: got %d found, %d hashed, %d bytes, want 3, 3, 15", snapshot.FilesFound, snapshot.FilesHashed, snapshot.BytesHashed)
+	}
+	if snapshot.QueueDepth != 0 || snapshot.BusyWorkers != 0 || snapshot.Workers != 0 || snapshot.Errors != 0 {
+		t.Errorf("Unexpected state after the walk: %+v", snapshot)
+	}
+	if snapshot.FilesPerSecond <= 0 {
+		t.Errorf("Unexpected rate: got %v files per second", snapshot.FilesPerSecond)
+	}
+
+	// a second walk adds to the same counters
+	if _, err := WalkDirectoryWithOptions(testDir, WalkOptions{Stats: stats}); err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if got := stats.Snapshot().FilesHashed; got != 6 {
+		t.Errorf("Unexpected files hashed after two walks: got %d, want 6", got)
+	}
+}
+
+func TestEmitScanStats(t *testing.T) {
+	stats := NewScanStats()
+	stats.walkStarted(4)
+	stats.fileFound()
+	stats.fileFound()
+	stats.fileStarted()
+
+	var out bytes.Buffer
+	stop := EmitScanStats(stats, &out, 5*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	stats.fileDone(100, nil)
+	if err := stop(); err != nil {
+		t.Fatalf("Unexpected error from stop: %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("Unexpected error from a second stop: %v", err)
+	}
+
+	var snapshots []StatsSnapshot
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var snapshot StatsSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snapshot); err != nil {
+			t.Fatalf("Invalid line %q: %v", scanner.Text(), err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if len(snapshots) < 2 {
+		t.Fatalf("Unexpected number of lines: got %d, want at least 2", len(snapshots))
+	}
+	first := snapshots[0]
+	if first.Final || first.QueueDepth != 1 || first.BusyWorkers != 1 || first.Workers != 4 || first.Utilization != 0.25 {
+		t.Errorf("Unexpected first snapshot: %+v", first)
+	}
+	last := snapshots[len(snapshots)-1]
+	if !last.Final || last.FilesHashed != 1 || last.BytesHashed != 100 || last.BusyWorkers != 0 {
+		t.Errorf("Unexpected final snapshot: %+v", last)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("reader went away")
+}
+
+func TestEmitScanStatsWriteError(t *testing.T) {
+	stop := EmitScanStats(NewScanStats(), failingWriter{}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if err := stop(); err == nil {
+		t.Error("Expected the write error from stop")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// outputSchema describes one machine-readable output by the Go type its encoder writes
+type outputSchema struct {
+	// value is a zero value of the type written, per line for Lines outputs
+	value       interface{}
+	tag         string
+	lines       bool
+	description string
+	// enums lists the values of string properties that only take a few, by property name
+	enums map[string][]string
+}
+
+// outputSchemas are the outputs OutputSchema describes, by the name -printSchema takes
+var outputSchemas = map[string]outputSchema{
+	"json": {
+		value: jsonResult{}, tag: "json", lines: true,
+		description: "A record of -output json: a duplicate, a file that could not be read, or the summary, which comes last",
+		enums:       map[string][]string{"type": {"duplicate", "error", "summary"}},
+	},
+	"groups": {
+		value: []DuplicateCluster{}, tag: "json",
+		description: "The duplicate clusters written by -groupOutput",
+	},
+	"stats": {
+		value: StatsSnapshot{}, tag: "json", lines: true,
+		description: "A line of live scan statistics written to -statsFd or -statsSocket",
+	},
+	"errorLog": {
+		value: errorLogEntry{}, tag: "json", lines: true,
+		description: "A line of -errorLog: a file or directory that could not be read",
+	},
+	"transactionLog": {
+		value: TransactionEntry{}, tag: "json", lines: true,
+		description: "A line of -dedupTransactionLog",
+		enums:       map[string][]string{"op": {TxBegin, TxIntent, TxDone, TxSkipped, TxCommit, TxAbort}},
+	},
+	"dumpIndex": {
+		value: FileMap{}, tag: "json",
+		description: "The hash to paths map written by -dumpIndex, as JSON or as YAML of the same structure",
+	},
+	"manifest": {
+		value: DirectoryInfo{}, tag: "yaml",
+		description: "A YAML manifest as written by -mode scan, described as the JSON equivalent of its YAML",
+	},
+}
+
+// SchemaFormats returns the names of the outputs OutputSchema describes, sorted
+func SchemaFormats() []string {
+	names := make([]string, 0, len(outputSchemas))
+	for name := range outputSchemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OutputSchema returns a JSON Schema of the output named format, generated from the type its encoder writes so
+// that it cannot drift from the output. For outputs of JSON lines it describes a single line.
+func OutputSchema(format string) (map[string]interface{}, error) {
+	output, ok := outputSchemas[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(SchemaFormats(), ", "))
+	}
+	schema := typeSchema(reflect.TypeOf(output.value), output.tag)
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		for name, values := range output.enums {
+			properties[name].(map[string]interface{})["enum"] = values
+		}
+	}
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = format
+	schema["description"] = output.description
+	if output.lines {
+		schema["description"] = output.description + ". The output has one such JSON object per line."
+	}
+	return schema, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema returns the JSON Schema of t as encoded with the struct tag named tag ("json" or "yaml")
+func typeSchema(t reflect.Type, tag string) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), tag)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), tag)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), tag)}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, options, _ := strings.Cut(field.Tag.Get(tag), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				// the default names of encoding/json and yaml.v2
+				name = field.Name
+				if tag == "yaml" {
+					name = strings.ToLower(name)
+				}
+			}
+			properties[name] = typeSchema(field.Type, tag)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	panic(fmt.Sprintf("no JSON Schema for %s", t))
+}
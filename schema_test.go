@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOutputSchema(t *testing.T) {
+	for _, format := range SchemaFormats() {
+		schema, err := OutputSchema(format)
+		if err != nil {
+			t.Fatalf("Error generating the schema of %s: %v", format, err)
+		}
+		if _, err := json.Marshal(schema); err != nil {
+			t.Errorf("Error encoding the schema of %s: %v", format, err)
+		}
+	}
+
+	schema, _ := OutputSchema("json")
+	if got := schema["required"]; !reflect.DeepEqual(got, []string{"type"}) {
+		t.Errorf("Unexpected required properties of json: got %v, want [type]", got)
+	}
+	typeSchema := schema["properties"].(map[string]interface{})["type"].(map[string]interface{})
+	if got := typeSchema["enum"]; !reflect.DeepEqual(got, []string{"duplicate", "error", "summary"}) {
+		t.Errorf("Unexpected enum of type: got %v", got)
+	}
+
+	schema, _ = OutputSchema("manifest")
+	files := schema["properties"].(map[string]interface{})["files"].(map[string]interface{})
+	file := files["items"].(map[string]interface{})
+	properties := file["properties"].(map[string]interface{})
+	for _, name := range []string{"path", "size", "modTime", "links"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Expected property %s in the manifest files", name)
+		}
+	}
+	for _, name := range file["required"].([]string) {
+		if name == "links" {
+			t.Error("Expected links to be optional in the manifest files")
+		}
+	}
+
+	if _, err := OutputSchema("xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// shellQuote wraps s in single quotes so it can be pasted into a POSIX shell verbatim
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// commentText replaces the control characters of s with spaces, so a path written after a # stays a comment
+// on its line and cannot inject commands
+func commentText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// WriteDeletionScript writes the deletion plan as an executable shell script at path
+func WriteDeletionScript(path string, duplicates []Duplicate, refDir *DirectoryInfo, targetDir *DirectoryInfo) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintln(w, "# deletion plan generated by deduplicator")
+	fmt.Fprintf(w, "# reference: %s\n", commentText(refDir.BaseDir))
+	fmt.Fprintf(w, "# target: %s\n", commentText(targetDir.BaseDir))
+	reclaimable := totalSize(duplicateFiles(duplicates))
+	fmt.Fprintf(w, "# duplicate files: %s\n", FormatCount(len(duplicates)))
+	fmt.Fprintf(w, "# reclaimable space: %s (%d bytes)\n", FormatBytes(reclaimable, false), reclaimable)
+	fmt.Fprintln(w, "set -e")
+	fmt.Fprintln(w)
+	for _, duplicate := range duplicates {
+		fmt.Fprintf(w, "rm -- %s  # duplicated at: %s\n", shellQuote(duplicate.File.Path), commentText(duplicate.RefPath))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// OpenFile only applies the mode on creation, so make sure an existing file is executable too
+	return out.Chmod(0755)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDeletionScript(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "scriptdir")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{{Path: "/ref/it's.txt", Hash: "abc", Size: 3}}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{{Path: "/target/it's.txt", Hash: "abc", Size: 3}}}
+
+	scriptPath := filepath.Join(testDir, "plan.sh")
+	duplicates := []Duplicate{{File: targetDir.Files[0], RefPath: refDir.Files[0].Path}}
+	if err := WriteDeletionScript(scriptPath, duplicates, refDir, targetDir); err != nil {
+		t.Fatalf("Error writing deletion script: %v", err)
+	}
+
+	info, err := os.Stat(scriptPath)
+	if err != nil {
+		t.Fatalf("Error stating deletion script: %v", err)
+	}
+	if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("Deletion script is not executable: %v", info.Mode())
+	}
+
+	data, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatalf("Error reading deletion script: %v", err)
+	}
+	script := string(data)
+	for _, want := range []string{"#!/bin/sh\n", "set -e\n", "# duplicate files: 1\n", "# reclaimable space: 3 B (3 bytes)\n", `rm -- '/target/it'\''s.txt'  # duplicated at: /ref/it's.txt`} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Deletion script missing %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestWriteDeletionScriptNewlineInBaseDir(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "scriptdir")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	refDir := &DirectoryInfo{BaseDir: "/ref\nrm -rf /home\n"}
+	targetDir := &DirectoryInfo{BaseDir: "/target\r\ntouch /tmp/pwned"}
+	scriptPath := filepath.Join(testDir, "plan.sh")
+	if err := WriteDeletionScript(scriptPath, nil, refDir, targetDir); err != nil {
+		t.Fatalf("Error writing deletion script: %v", err)
+	}
+	data, err := os.ReadFile(scriptPath)
+	if err != nil {
+		t.Fatalf("Error reading deletion script: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") && line != "set -e" {
+			t.Errorf("Unexpected command in deletion script without duplicates: %q", line)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ResultSink receives the results of a comparison, separating their presentation from finding them.
+// Flush must be called once all results are written.
+type ResultSink interface {
+	Duplicate(duplicate Duplicate) error
+	Error(path string, err error) error
+	Summary(summary Summary) error
+	Flush() error
+}
+
+// NewResultSink returns a sink writing format "text", "json" (one object per line) or "csv" to w.
+// si selects powers of 1000 for the sizes the text format prints.
+func NewResultSink(format string, w io.Writer, si bool) (ResultSink, error) {
+	switch format {
+	case "text":
+		return &TextSink{w: w, si: si}, nil
+	case "json":
+		return &JSONSink{encoder: json.NewEncoder(w)}, nil
+	case "csv":
+		return &CSVSink{w: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected text, json or csv", format)
+}
+
+// SendResults writes the errors of the run, the duplicates, and a summary of them among the files of targetDir
+// to sink. The duplicate bytes are the space deleting the duplicates frees, as FreedBytes counts it. busy lists the files the run left out under WalkOptions.SkipBusy.
+func SendResults(sink ResultSink, duplicates []Duplicate, targetDir *DirectoryInfo, errs []ReportError, busy []string) error {
+	report := Report{Duplicates: duplicates, Errors: errs, Summary: Summary{Files: len(targetDir.Files), Busy: busy}}
+	for _, file := range targetDir.Files {
+		report.Summary.Bytes += file.Size
+	}
+	var freed freedSpace
+	for _, duplicate := range duplicates {
+		report.Summary.Duplicates++
+		freed.add(duplicate.File)
+	}
+	report.Summary.DuplicateBytes = freed.bytes
+	return SendReport(sink, report)
+}
+
+// TextSink writes the deletion plan as shell commands quoted like WriteDeletionScript's, with errors and the summary as comments
+type TextSink struct {
+	w  io.Writer
+	si bool
+}
+
+func (s *TextSink) Duplicate(duplicate Duplicate) error {
+	_, err := fmt.Fprintf(s.w, "rm -- %s  # duplicated at: %s\n", shellQuote(duplicate.File.Path), commentText(duplicate.RefPath))
+	return err
+}
+
+func (s *TextSink) Error(path string, err error) error {
+	_, writeErr := fmt.Fprintf(s.w, "# error: %s: %v\n", path, err)
+	return writeErr
+}
+
+func (s *TextSink) Summary(summary Summary) error {
+	_, err := fmt.Fprintf(s.w, "# %s of %s files are duplicates, %s of %s reclaimable\n", FormatCount(summary.Duplicates), FormatCount(summary.Files), FormatBytes(summary.DuplicateBytes, s.si), FormatBytes(summary.Bytes, s.si))
+	return err
+}
+
+func (s *TextSink) Flush() error {
+	return nil
+}
+
+// JSONSink writes one JSON object per result, told apart by their "type"
+type JSONSink struct {
+	encoder *json.Encoder
+}
+
+type jsonResult struct {
+	Type    string   `json:"type"`
+	Path    string   `json:"path,omitempty"`
+	RefPath string   `json:"refPath,omitempty"`
+	Size    int64    `json:"size,omitempty"`
+	Hash    string   `json:"hash,omitempty"`
+	Device  uint64   `json:"device,omitempty"`
+	Inode   uint64   `json:"inode,omitempty"`
+	Links   uint64   `json:"links,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Summary *Summary `json:"summary,omitempty"`
+}
+
+func (s *JSONSink) Duplicate(duplicate Duplicate) error {
+	file := duplicate.File
+	return s.encoder.Encode(jsonResult{Type: "duplicate", Path: file.Path, RefPath: duplicate.RefPath, Size: file.Size, Hash: file.Hash, Device: file.Device, Inode: file.Inode, Links: file.Links})
+}
+
+func (s *JSONSink) Error(path string, err error) error {
+	return s.encoder.Encode(jsonResult{Type: "error", Path: path, Error: err.Error()})
+}
+
+func (s *JSONSink) Summary(summary Summary) error {
+	return s.encoder.Encode(jsonResult{Type: "summary", Summary: &summary})
+}
+
+func (s *JSONSink) Flush() error {
+	return nil
+}
+
+// CSVSink writes a header and one row per result. The record column tells rows apart; summary rows carry the
+// duplicate count and bytes in the detail and size columns.
+type CSVSink struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (s *CSVSink) write(row ...string) error {
+	if !s.headerWritten {
+		s.headerWritten = true
+		if err := s.w.Write([]string{"record", "path", "ref_path", "size", "hash", "detail"}); err != nil {
+			return err
+		}
+	}
+	return s.w.Write(row)
+}
+
+func (s *CSVSink) Duplicate(duplicate Duplicate) error {
+	return s.write("duplicate", duplicate.File.Path, duplicate.RefPath, strconv.FormatInt(duplicate.File.Size, 10), duplicate.File.Hash, "")
+}
+
+func (s *CSVSink) Error(path string, err error) error {
+	return s.write("error", path, "", "", "", err.Error())
+}
+
+func (s *CSVSink) Summary(summary Summary) error {
+	return s.write("summary", "", "", strconv.FormatInt(summary.DuplicateBytes, 10), "", strconv.Itoa(summary.Duplicates))
+}
+
+func (s *CSVSink) Flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// MultiSink fans every result out to several sinks, writing to all of them even if some fail
+type MultiSink []ResultSink
+
+func (m MultiSink) Duplicate(duplicate Duplicate) error {
+	return m.each(func(sink ResultSink) error { return sink.Duplicate(duplicate) })
+}
+
+func (m MultiSink) Error(path string, err error) error {
+	return m.each(func(sink ResultSink) error { return sink.Error(path, err) })
+}
+
+func (m MultiSink) Summary(summary Summary) error {
+	return m.each(func(sink ResultSink) error { return sink.Summary(summary) })
+}
+
+func (m MultiSink) Flush() error {
+	return m.each(ResultSink.Flush)
+}
+
+func (m MultiSink) each(call func(sink ResultSink) error) error {
+	var errs []error
+	for _, sink := range m {
+		if err := call(sink); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestResultSinks(t *testing.T) {
+	targetDir := &DirectoryInfo{BaseDir: "/t", Files: []FileInfo{
+		{Path: "/t/a", Hash: "aa", Size: 100},
+		{Path: "/t/b, copy", Hash: "bb", Size: 50},
+	}}
+	duplicates := []Duplicate{{File: targetDir.Files[1], RefPath: "/r/b"}}
+
+	var text, jsonLines, csvRows bytes.Buffer
+	var sinks MultiSink
+	for format, w := range map[string]*bytes.Buffer{"text": &text, "json": &jsonLines, "csv": &csvRows} {
+		sink, err := NewResultSink(format, w, false)
+		if err != nil {
+			t.Fatalf("Unexpected error creating a %s sink: %v", format, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	errs := []ReportError{{Path: "/t/unreadable", Error: "permission denied"}}
+	if err := SendResults(sinks, duplicates, targetDir, errs, []string{"/t/locked"}); err != nil {
+		t.Fatalf("Unexpected error writing results: %v", err)
+	}
+
+	wantText := "# error: /t/unreadable: permission denied\n" +
+		"rm -- '/t/b, copy'  # duplicated at: /r/b\n" +
+		"# 1 of 2 files are duplicates, 50 B of 150 B reclaimable\n"
+	if text.String() != wantText {
+		t.Errorf("Unexpected text output: got %q, want %q", text.String(), wantText)
+	}
+	wantJSON := `{"type":"error","path":"/t/unreadable","error":"permission denied"}` + "\n" +
+		`{"type":"duplicate","path":"/t/b, copy","refPath":"/r/b","size":50,"hash":"bb"}` + "\n" +
+		`{"type":"summary","summary":{"files":2,"bytes":150,"duplicates":1,"duplicateBytes":50,"busy":["/t/locked"]}}` + "\n"
+	if jsonLines.String() != wantJSON {
+		t.Errorf("Unexpected JSON output: got %q, want %q", jsonLines.String(), wantJSON)
+	}
+	wantCSV := "record,path,ref_path,size,hash,detail\n" +
+		"error,/t/unreadable,,,,permission denied\n" +
+		"duplicate,\"/t/b, copy\",/r/b,50,bb,\n" +
+		"summary,,,50,,1\n"
+	if csvRows.String() != wantCSV {
+		t.Errorf("Unexpected CSV output: got %q, want %q", csvRows.String(), wantCSV)
+	}
+
+	if _, err := NewResultSink("xml", &text, false); err == nil || !strings.Contains(err.Error(), "xml") {
+		t.Errorf("Unexpected error for an unknown format: %v", err)
+	}
+}
+
+func TestSendResultsHardlinks(t *testing.T) {
+	targetDir := &DirectoryInfo{BaseDir: "/t", Files: []FileInfo{
+		{Path: "/t/a", Hash: "aa", Size: 100, Device: 1, Inode: 10, Links: 2},
+		{Path: "/t/a-link", Hash: "aa", Size: 100, Device: 1, Inode: 10, Links: 2},
+		{Path: "/t/b", Hash: "bb", Size: 50, Device: 1, Inode: 20, Links: 2},
+	}}
+	var duplicates []Duplicate
+	for _, file := range targetDir.Files {
+		duplicates = append(duplicates, Duplicate{File: file, RefPath: "/r/" + file.Hash})
+	}
+
+	var text bytes.Buffer
+	sink, err := NewResultSink("text", &text, false)
+	if err != nil {
+		t.Fatalf("Unexpected error creating a text sink: %v", err)
+	}
+	if err := SendResults(sink, duplicates, targetDir, nil, nil); err != nil {
+		t.Fatalf("Unexpected error writing results: %v", err)
+	}
+	// both links of a are deleted, but b keeps a link outside the target
+	want := "# 3 of 3 files are duplicates, 100 B of 250 B reclaimable\n"
+	if !strings.HasSuffix(text.String(), want) {
+		t.Errorf("Unexpected summary: got %q, want it to end in %q", text.String(), want)
+	}
+}
+
+func TestTextSinkQuotesPaths(t *testing.T) {
+	var text bytes.Buffer
+	sink := &TextSink{w: &text}
+	duplicate := Duplicate{File: FileInfo{Path: "/t/$(touch pwned) `id` \"it's\"\nrm -rf ~"}, RefPath: "/r/a\nrm -rf ~"}
+	if err := sink.Duplicate(duplicate); err != nil {
+		t.Fatalf("Unexpected error writing a duplicate: %v", err)
+	}
+	want := "rm -- '/t/$(touch pwned) `id` \"it'\\''s\"\nrm -rf ~'  # duplicated at: /r/a rm -rf ~\n"
+	if text.String() != want {
+		t.Errorf("Unexpected text output: got %q, want %q", text.String(), want)
+	}
+}
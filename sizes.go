@@ -0,0 +1,46 @@
+package main
+
+// SizeEstimate is an upper bound on duplication derived from file sizes alone
+type SizeEstimate struct {
+	// Files is the number of files that share their size with another file, so could be duplicates
+	Files int
+	// Bytes is the space those files would free if every one of them were a duplicate
+	Bytes int64
+}
+
+// EstimateBySize counts the target files whose size occurs in the reference. Nothing is hashed, so this
+// over-estimates: every real duplicate is counted, but so is every unrelated file that happens to share a size.
+// Empty files are ignored.
+func EstimateBySize(refDir *DirectoryInfo, targetDir *DirectoryInfo) SizeEstimate {
+	refSizes := make(map[int64]bool)
+	for _, file := range refDir.Files {
+		refSizes[file.Size] = true
+	}
+	var estimate SizeEstimate
+	for _, file := range targetDir.Files {
+		if file.Size > 0 && refSizes[file.Size] {
+			estimate.Files++
+			estimate.Bytes += file.Size
+		}
+	}
+	return estimate
+}
+
+// EstimateSelfBySize is EstimateBySize within one tree: for every size shared by several files, all but one
+// of them are counted. Empty files are ignored.
+func EstimateSelfBySize(dirInfo *DirectoryInfo) SizeEstimate {
+	counts := make(map[int64]int)
+	for _, file := range dirInfo.Files {
+		if file.Size > 0 {
+			counts[file.Size]++
+		}
+	}
+	var estimate SizeEstimate
+	for size, count := range counts {
+		if count > 1 {
+			estimate.Files += count - 1
+			estimate.Bytes += int64(count-1) * size
+		}
+	}
+	return estimate
+}
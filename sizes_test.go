@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestEstimateBySize(t *testing.T) {
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/a", Size: 10},
+		{Path: "/ref/b", Size: 20},
+		{Path: "/ref/empty"},
+	}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{
+		{Path: "/target/a", Size: 10},
+		{Path: "/target/a2", Size: 10},
+		{Path: "/target/c", Size: 30},
+		{Path: "/target/d", Size: 30},
+		{Path: "/target/e", Size: 30},
+		{Path: "/target/empty"},
+		{Path: "/target/empty2"},
+	}}
+
+	if got := EstimateBySize(refDir, targetDir); got != (SizeEstimate{Files: 2, Bytes: 20}) {
+		t.Errorf("Unexpected estimate against the reference: %+v", got)
+	}
+	if got := EstimateSelfBySize(targetDir); got != (SizeEstimate{Files: 3, Bytes: 70}) {
+		t.Errorf("Unexpected estimate within the target: %+v", got)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SortKey orders the duplicates of a plan or report
+type SortKey string
+
+const (
+	// SortByPath orders duplicates by target path, for output that diffs well between runs
+	SortByPath SortKey = "path"
+	// SortBySize puts the largest duplicates first
+	SortBySize SortKey = "size"
+	// SortByWastedSpace puts first the reference files whose duplicates take the most space together, keeping
+	// the duplicates of each reference file together
+	SortByWastedSpace SortKey = "wastedSpace"
+	// SortByGroupSize puts first the reference files with the most duplicates, keeping the duplicates of each
+	// reference file together
+	SortByGroupSize SortKey = "groupSize"
+	// SortByModTime puts the least recently modified duplicates first
+	SortByModTime SortKey = "mtime"
+)
+
+// ParseSortKey parses the value of -sortBy
+func ParseSortKey(value string) (SortKey, error) {
+	switch key := SortKey(value); key {
+	case SortByPath, SortBySize, SortByWastedSpace, SortByGroupSize, SortByModTime:
+		return key, nil
+	}
+	return "", fmt.Errorf("expected path, size, wastedSpace, groupSize or mtime, got %q", value)
+}
+
+// SortDuplicates sorts duplicates in place by key. Ties are broken by reference path and then by target path,
+// so the order does not depend on the order the walk found the files in.
+func SortDuplicates(duplicates []Duplicate, key SortKey) {
+	// a group is the duplicates of one reference file
+	groupBytes := make(map[string]int64)
+	groupFiles := make(map[string]int)
+	for _, duplicate := range duplicates {
+		groupBytes[duplicate.RefPath] += duplicate.File.Size
+		groupFiles[duplicate.RefPath]++
+	}
+
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		a, b := duplicates[i], duplicates[j]
+		switch key {
+		case SortBySize:
+			if a.File.Size != b.File.Size {
+				return a.File.Size > b.File.Size
+			}
+		case SortByWastedSpace:
+			if groupBytes[a.RefPath] != groupBytes[b.RefPath] {
+				return groupBytes[a.RefPath] > groupBytes[b.RefPath]
+			}
+		case SortByGroupSize:
+			if groupFiles[a.RefPath] != groupFiles[b.RefPath] {
+				return groupFiles[a.RefPath] > groupFiles[b.RefPath]
+			}
+		case SortByModTime:
+			if !a.File.ModTime.Equal(b.File.ModTime) {
+				return a.File.ModTime.Before(b.File.ModTime)
+			}
+		case SortByPath:
+			return a.File.Path < b.File.Path
+		}
+		if a.RefPath != b.RefPath {
+			return a.RefPath < b.RefPath
+		}
+		return a.File.Path < b.File.Path
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortDuplicates(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2024, 1, n, 0, 0, 0, 0, time.UTC) }
+	found := []Duplicate{
+		{File: FileInfo{Path: "/t/c", Size: 10, ModTime: day(3)}, RefPath: "/r/small"},
+		{File: FileInfo{Path: "/t/a", Size: 10, ModTime: day(2)}, RefPath: "/r/small"},
+		{File: FileInfo{Path: "/t/e", Size: 10, ModTime: day(5)}, RefPath: "/r/small"},
+		{File: FileInfo{Path: "/t/b", Size: 100, ModTime: day(4)}, RefPath: "/r/big"},
+		{File: FileInfo{Path: "/t/d", Size: 25, ModTime: day(1)}, RefPath: "/r/mid"},
+	}
+
+	for _, tc := range []struct {
+		key  SortKey
+		want string
+	}{
+		{SortByPath, "/t/a /t/b /t/c /t/d /t/e"},
+		{SortBySize, "/t/b /t/d /t/a /t/c /t/e"},
+		{SortByWastedSpace, "/t/b /t/a /t/c /t/e /t/d"},
+		{SortByGroupSize, "/t/a /t/c /t/e /t/b /t/d"},
+		{SortByModTime, "/t/d /t/a /t/c /t/b /t/e"},
+	} {
+		duplicates := append([]Duplicate(nil), found...)
+		SortDuplicates(duplicates, tc.key)
+		var got []string
+		for _, duplicate := range duplicates {
+			got = append(got, duplicate.File.Path)
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("Unexpected order by %s: got %v, want %v", tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestParseSortKey(t *testing.T) {
+	if key, err := ParseSortKey("wastedSpace"); err != nil || key != SortByWastedSpace {
+		t.Errorf("Unexpected result: got %q, %v", key, err)
+	}
+	if _, err := ParseSortKey("name"); err == nil {
+		t.Error("Expected an error for an unknown sort key")
+	}
+}
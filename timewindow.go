@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// FindRelatedByTimeWindow clusters same-size files whose modification times are chained together by gaps of at most window.
+// It is a heuristic for reports only: files saved seconds apart under different names are likely related,
+// but nothing here proves they are interchangeable. Clusters whose files all share one hash are exact
+// duplicates already covered by hash matching and are left out, as are files without a modification time.
+func FindRelatedByTimeWindow(files []FileInfo, window time.Duration) [][]FileInfo {
+	bySize := make(map[int64][]FileInfo)
+	for _, file := range files {
+		if file.ModTime.IsZero() {
+			continue
+		}
+		bySize[file.Size] = append(bySize[file.Size], file)
+	}
+
+	var clusters [][]FileInfo
+	for _, sameSize := range bySize {
+		if len(sameSize) < 2 {
+			continue
+		}
+		sort.Slice(sameSize, func(i, j int) bool { return sameSize[i].ModTime.Before(sameSize[j].ModTime) })
+
+		// chain files whose mtime is within window of the previous one
+		cluster := []FileInfo{sameSize[0]}
+		for _, file := range sameSize[1:] {
+			if file.ModTime.Sub(cluster[len(cluster)-1].ModTime) <= window {
+				cluster = append(cluster, file)
+				continue
+			}
+			if isRelatedCluster(cluster) {
+				clusters = append(clusters, cluster)
+			}
+			cluster = []FileInfo{file}
+		}
+		if isRelatedCluster(cluster) {
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0].ModTime.Before(clusters[j][0].ModTime) })
+	return clusters
+}
+
+func isRelatedCluster(cluster []FileInfo) bool {
+	if len(cluster) < 2 {
+		return false
+	}
+	for _, file := range cluster[1:] {
+		if file.Hash != cluster[0].Hash {
+			return true
+		}
+	}
+	return false
+}
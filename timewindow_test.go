@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindRelatedByTimeWindow(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := []FileInfo{
+		{Path: "IMG_0001.jpg", Hash: "a", Size: 100, ModTime: base},
+		{Path: "IMG_0001 (1).jpg", Hash: "b", Size: 100, ModTime: base.Add(3 * time.Second)},
+		{Path: "IMG_0002.jpg", Hash: "c", Size: 100, ModTime: base.Add(time.Hour)},
+		{Path: "copy.jpg", Hash: "c", Size: 100, ModTime: base.Add(time.Hour + time.Second)}, // exact duplicate only
+		{Path: "other.jpg", Hash: "d", Size: 200, ModTime: base.Add(time.Second)},            // different size
+		{Path: "unknown.jpg", Hash: "e", Size: 100},                                          // no mtime
+	}
+
+	clusters := FindRelatedByTimeWindow(files, 5*time.Second)
+	if len(clusters) != 1 {
+		t.Fatalf("Unexpected number of clusters: got %d, want 1: %v", len(clusters), clusters)
+	}
+	if len(clusters[0]) != 2 || clusters[0][0].Path != "IMG_0001.jpg" || clusters[0][1].Path != "IMG_0001 (1).jpg" {
+		t.Errorf("Unexpected cluster: %v", clusters[0])
+	}
+}
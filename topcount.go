@@ -0,0 +1,55 @@
+package main
+
+import "sort"
+
+// ContentCount is one piece of content and how often it occurs
+type ContentCount struct {
+	Hash  string
+	Size  int64
+	Count int
+	// SamplePath is the first path holding the content, in path order
+	SamplePath string
+}
+
+// TopDuplicatesByCount ranks the contents occurring more than once among files by their number of copies and
+// returns the first n, or all if n is not positive. Ties are ordered by the space the extra copies take, then by
+// hash. Files without a hash are left out, and hashes of different algorithms never count together.
+func TopDuplicatesByCount(files []FileInfo, n int) []ContentCount {
+	byHash := make(map[string]*ContentCount)
+	for _, file := range files {
+		key := contentKey(file, CompareOptions{})
+		if key == "" {
+			continue
+		}
+		count, ok := byHash[key]
+		if !ok {
+			count = &ContentCount{Hash: file.Hash, Size: file.Size, SamplePath: file.Path}
+			byHash[key] = count
+		}
+		count.Count++
+		if file.Path < count.SamplePath {
+			count.SamplePath = file.Path
+		}
+	}
+
+	var counts []ContentCount
+	for _, count := range byHash {
+		if count.Count > 1 {
+			counts = append(counts, *count)
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		wastedI, wastedJ := int64(counts[i].Count-1)*counts[i].Size, int64(counts[j].Count-1)*counts[j].Size
+		if wastedI != wastedJ {
+			return wastedI > wastedJ
+		}
+		return counts[i].Hash < counts[j].Hash
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestTopDuplicatesByCount(t *testing.T) {
+	files := []FileInfo{
+		{Path: "site/a/logo.png", Hash: "logo", Size: 10},
+		{Path: "site/b/logo.png", Hash: "logo", Size: 10},
+		{Path: "site/c/logo.png", Hash: "logo", Size: 10},
+		{Path: "site/0/logo.png", Hash: "logo", Size: 10},
+		{Path: "video/a.mp4", Hash: "video", Size: 1000},
+		{Path: "video/b.mp4", Hash: "video", Size: 1000},
+		{Path: "notes/a.txt", Hash: "notes", Size: 5},
+		{Path: "notes/b.txt", Hash: "notes", Size: 5},
+		{Path: "unique.txt", Hash: "unique", Size: 50},
+		{Path: "unhashed.txt", Size: 50},
+		{Path: "other/logo.png", Hash: "logo", HashAlgo: "md5", Size: 10},
+	}
+
+	top := TopDuplicatesByCount(files, 0)
+	if len(top) != 3 {
+		t.Fatalf("Unexpected number of duplicated contents: got %d, want 3", len(top))
+	}
+	want := []ContentCount{
+		{Hash: "logo", Size: 10, Count: 4, SamplePath: "site/0/logo.png"},
+		{Hash: "video", Size: 1000, Count: 2, SamplePath: "video/a.mp4"}, // ties on count rank by wasted space
+		{Hash: "notes", Size: 5, Count: 2, SamplePath: "notes/a.txt"},
+	}
+	for i := range want {
+		if top[i] != want[i] {
+			t.Errorf("Unexpected entry %d: got %+v, want %+v", i, top[i], want[i])
+		}
+	}
+
+	if top := TopDuplicatesByCount(files, 1); len(top) != 1 || top[0].Hash != "logo" {
+		t.Errorf("Unexpected top 1: %+v", top)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Transaction log operations, in the order they appear in a log
+const (
+	TxBegin   = "begin"
+	TxIntent  = "intent"
+	TxDone    = "done"
+	TxSkipped = "skipped"
+	TxCommit  = "commit"
+	TxAbort   = "abort"
+)
+
+// TransactionEntry is one JSON line of a TransactionLog
+type TransactionEntry struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	// Action is what an intent plans to do to Path; the only action so far is "delete"
+	Action  string `json:"action,omitempty"`
+	Path    string `json:"path,omitempty"`
+	RefPath string `json:"refPath,omitempty"`
+	Count   int    `json:"count,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// TransactionLog is an append-only JSON lines account of a destructive run. Every planned action is written as
+// an intent, and synced to disk if the writer supports it, before anything is changed; then each outcome is
+// written as it happens, and finally a commit or an abort. A run that dies midway thus leaves an exact record of
+// what was done and what was still pending.
+//
+// Deletions cannot be rolled back, so they must be the last phase of a run; reversible actions logged alongside
+// them can be undone from their intents.
+type TransactionLog struct {
+	mu       sync.Mutex
+	w        io.Writer
+	encoder  *json.Encoder
+	writeErr error
+}
+
+// NewTransactionLog returns a TransactionLog writing to w
+func NewTransactionLog(w io.Writer) *TransactionLog {
+	return &TransactionLog{w: w, encoder: json.NewEncoder(w)}
+}
+
+// Begin records the deletion of every duplicate as an intent and syncs the log
+func (l *TransactionLog) Begin(duplicates []Duplicate) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(TransactionEntry{Op: TxBegin, Count: len(duplicates)})
+	for _, duplicate := range duplicates {
+		l.write(TransactionEntry{Op: TxIntent, Action: "delete", Path: duplicate.File.Path, RefPath: duplicate.RefPath})
+	}
+	return l.sync()
+}
+
+// Done records that file was deleted
+func (l *TransactionLog) Done(file FileInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(TransactionEntry{Op: TxDone, Path: file.Path})
+}
+
+// Skipped records that file was deliberately kept, with the reason
+func (l *TransactionLog) Skipped(file FileInfo, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(TransactionEntry{Op: TxSkipped, Path: file.Path, Error: err.Error()})
+}
+
+// Commit records that the run finished and syncs the log, returning the first error writing it
+func (l *TransactionLog) Commit() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(TransactionEntry{Op: TxCommit})
+	return l.sync()
+}
+
+// Abort records that the run stopped early because of err, leaving the intents without an outcome undone, and
+// syncs the log, returning the first error writing it
+func (l *TransactionLog) Abort(err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.write(TransactionEntry{Op: TxAbort, Error: err.Error()})
+	return l.sync()
+}
+
+// write encodes entry, keeping the first error; callers hold mu
+func (l *TransactionLog) write(entry TransactionEntry) {
+	if l.writeErr != nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	l.writeErr = l.encoder.Encode(entry)
+}
+
+// sync flushes the log to stable storage if the writer can, returning the first error writing it; callers hold mu
+func (l *TransactionLog) sync() error {
+	if syncer, ok := l.w.(interface{ Sync() error }); ok && l.writeErr == nil {
+		l.writeErr = syncer.Sync()
+	}
+	return l.writeErr
+}
+
+// TransactionState is what a TransactionLog says about a run
+type TransactionState struct {
+	// Pending are the paths of intents without an outcome, which the run did not get to or was acting on when it
+	// stopped
+	Pending   []string
+	Done      []string
+	Skipped   []string
+	Committed bool
+	Aborted   bool
+}
+
+// ReadTransactionLog reads a log written by TransactionLog, e.g. to see what an interrupted run left undone.
+// A log appended to by several runs describes the last one.
+func ReadTransactionLog(r io.Reader) (TransactionState, error) {
+	var state TransactionState
+	var intents []string
+	outcome := make(map[string]bool)
+	decoder := json.NewDecoder(r)
+	for {
+		var entry TransactionEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return state, err
+		}
+		switch entry.Op {
+		case TxBegin:
+			state, intents, outcome = TransactionState{}, nil, make(map[string]bool)
+		case TxIntent:
+			intents = append(intents, entry.Path)
+		case TxDone:
+			state.Done = append(state.Done, entry.Path)
+			outcome[entry.Path] = true
+		case TxSkipped:
+			state.Skipped = append(state.Skipped, entry.Path)
+			outcome[entry.Path] = true
+		case TxCommit:
+			state.Committed = true
+		case TxAbort:
+			state.Aborted = true
+		}
+	}
+	for _, path := range intents {
+		if !outcome[path] {
+			state.Pending = append(state.Pending, path)
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransactionLogDeletion(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"ref/a.txt", "a"},
+		{"target/a1.txt", "a"},
+		{"target/a2.txt", "a"},
+		{"target/a3.txt", "a"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	path := func(name string) string { return filepath.Join(testDir, name) }
+
+	var duplicates []Duplicate
+	for _, name := range []string{"target/a1.txt", "target/a2.txt", "target/a3.txt"} {
+		duplicates = append(duplicates, Duplicate{File: FileInfo{Path: path(name)}, RefPath: path("ref/a.txt")})
+	}
+
+	var out bytes.Buffer
+	txLog := NewTransactionLog(&out)
+	if err := txLog.Begin(duplicates); err != nil {
+		t.Fatalf("Error beginning transaction: %v", err)
+	}
+	deleted, err := ConfirmAndDelete(duplicateFiles(duplicates), DeleteOptions{
+		Confirm: AlwaysConfirm,
+		Verify: func(file FileInfo) error {
+			if file.Path == path("target/a2.txt") {
+				return errors.New("changed since it was scanned")
+			}
+			return nil
+		},
+		Skipped: txLog.Skipped,
+		Removed: txLog.Done,
+	})
+	if !deleted || err != nil {
+		t.Fatalf("Unexpected deletion result: %v, %v", deleted, err)
+	}
+	if err := txLog.Commit(); err != nil {
+		t.Fatalf("Error committing transaction: %v", err)
+	}
+
+	state, err := ReadTransactionLog(&out)
+	if err != nil {
+		t.Fatalf("Error reading transaction log: %v", err)
+	}
+	if !state.Committed || state.Aborted || len(state.Pending) != 0 {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+	if len(state.Done) != 2 || state.Done[0] != path("target/a1.txt") || state.Done[1] != path("target/a3.txt") {
+		t.Errorf("Unexpected deleted files: %v", state.Done)
+	}
+	if len(state.Skipped) != 1 || state.Skipped[0] != path("target/a2.txt") {
+		t.Errorf("Unexpected skipped files: %v", state.Skipped)
+	}
+}
+
+func TestReadTransactionLogInterrupted(t *testing.T) {
+	var out bytes.Buffer
+	txLog := NewTransactionLog(&out)
+	files := []FileInfo{{Path: "a"}, {Path: "b"}, {Path: "c"}}
+
+	// a finished run followed by one that died after its first deletion
+	txLog.Begin([]Duplicate{{File: files[0], RefPath: "ref"}})
+	txLog.Done(files[0])
+	txLog.Commit()
+	txLog.Begin([]Duplicate{{File: files[1], RefPath: "ref"}, {File: files[2], RefPath: "ref"}})
+	txLog.Done(files[1])
+
+	if lines := strings.Count(out.String(), "\n"); lines != 8 {
+		t.Errorf("Unexpected number of log lines: got %d, want 8", lines)
+	}
+	state, err := ReadTransactionLog(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("Error reading transaction log: %v", err)
+	}
+	if state.Committed || state.Aborted {
+		t.Errorf("Unexpected state of the interrupted run: %+v", state)
+	}
+	if len(state.Pending) != 1 || state.Pending[0] != "c" || len(state.Done) != 1 || state.Done[0] != "b" {
+		t.Errorf("Unexpected outcome of the interrupted run: %+v", state)
+	}
+
+	txLog.Abort(errors.New("disk on fire"))
+	state, err = ReadTransactionLog(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatalf("Error reading transaction log: %v", err)
+	}
+	if !state.Aborted || len(state.Pending) != 1 {
+		t.Errorf("Unexpected state of the aborted run: %+v", state)
+	}
+
+	if _, err := ReadTransactionLog(strings.NewReader("not json")); err == nil {
+		t.Error("Expected an error for a corrupt log")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// TruncatedFile is a file whose entire content is a strict prefix of a larger file, such as an incomplete download
+type TruncatedFile struct {
+	Partial  FileInfo
+	Complete FileInfo
+}
+
+// truncatedProbeBytes is the length of the head and tail samples used to rule out pairs before hashing
+const truncatedProbeBytes = 64
+
+// FindTruncatedFiles reports every pair of files where the smaller one, of at least minSize bytes, is a prefix of
+// the larger one. Files need their Hash, and are read from disk: candidates are bucketed by their first bytes,
+// pairs are ruled out by comparing the last bytes of the smaller file at the same offset in the larger one, and
+// the remaining pairs are confirmed by hashing the larger file's prefix, keyed on (prefix length, prefix hash).
+func FindTruncatedFiles(files []FileInfo, minSize int64) ([]TruncatedFile, error) {
+	if minSize < truncatedProbeBytes {
+		minSize = truncatedProbeBytes
+	}
+
+	buckets := make(map[string][]FileInfo) // map[first bytes][]file
+	for _, file := range files {
+		if file.Size < minSize || file.Hash == "" {
+			continue
+		}
+		head, err := readAt(file.Path, 0, truncatedProbeBytes)
+		if err != nil {
+			return nil, err
+		}
+		buckets[string(head)] = append(buckets[string(head)], file)
+	}
+
+	var truncated []TruncatedFile
+	for _, bucket := range buckets {
+		if len(bucket) < 2 {
+			continue
+		}
+		sort.Slice(bucket, func(i, j int) bool {
+			if bucket[i].Size != bucket[j].Size {
+				return bucket[i].Size < bucket[j].Size
+			}
+			return bucket[i].Path < bucket[j].Path
+		})
+
+		prefixHashes := make(map[string]string) // map[path, prefix length, algorithm]prefix hash
+		for i, partial := range bucket {
+			tail, err := readAt(partial.Path, partial.Size-truncatedProbeBytes, truncatedProbeBytes)
+			if err != nil {
+				return nil, err
+			}
+			for _, complete := range bucket[i+1:] {
+				if complete.Size == partial.Size {
+					continue
+				}
+				sample, err := readAt(complete.Path, partial.Size-truncatedProbeBytes, truncatedProbeBytes)
+				if err != nil {
+					return nil, err
+				}
+				if !bytes.Equal(sample, tail) {
+					continue
+				}
+
+				cacheKey := fmt.Sprintf("%s\x00%d\x00%s", complete.Path, partial.Size, partial.HashAlgo)
+				prefixHash, ok := prefixHashes[cacheKey]
+				if !ok {
+					if prefixHash, err = hashPrefix(complete.Path, partial.Size, partial.HashAlgo); err != nil {
+						return nil, err
+					}
+					prefixHashes[cacheKey] = prefixHash
+				}
+				if prefixHash == partial.Hash {
+					truncated = append(truncated, TruncatedFile{Partial: partial, Complete: complete})
+				}
+			}
+		}
+	}
+
+	sort.Slice(truncated, func(i, j int) bool {
+		if truncated[i].Partial.Path != truncated[j].Partial.Path {
+			return truncated[i].Partial.Path < truncated[j].Partial.Path
+		}
+		return truncated[i].Complete.Path < truncated[j].Complete.Path
+	})
+	return truncated, nil
+}
+
+// readAt reads length bytes of the file at path starting at offset
+func readAt(path string, offset int64, length int) ([]byte, error) {
+	file, err := os.Open(longPath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, length)
+	n, err := file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// hashPrefix hashes the first length bytes of the file at path with the named algorithm
+func hashPrefix(path string, length int64, algo string) (string, error) {
+	file, err := os.Open(longPath(path))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return HashReaderWith(io.LimitReader(file, length), algo)
+}
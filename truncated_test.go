@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindTruncatedFiles(t *testing.T) {
+	complete := strings.Repeat("0123456789", 50)
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"complete.bin", complete},
+		{"partial.bin.part", complete[:300]},
+		{"copy.bin", complete},                                      // same size, an exact duplicate rather than truncated
+		{"diverged.bin", complete[:299] + "x"},                      // differs in its last byte
+		{"samehead.bin", complete[:100] + strings.Repeat("y", 200)}, // same head, different middle
+		{"tiny.bin", complete[:10]},                                 // below the minimum size
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	dirInfo, err := WalkDirectory(testDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	truncated, err := FindTruncatedFiles(dirInfo.Files, 0)
+	if err != nil {
+		t.Fatalf("Error finding truncated files: %v", err)
+	}
+
+	var pairs []string
+	for _, pair := range truncated {
+		pairs = append(pairs, filepath.Base(pair.Partial.Path)+" < "+filepath.Base(pair.Complete.Path))
+	}
+	want := []string{
+		"partial.bin.part < complete.bin",
+		"partial.bin.part < copy.bin",
+	}
+	if strings.Join(pairs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected truncated files:\ngot  %v\nwant %v", pairs, want)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// ValidationResult describes how a directory differs from a manifest of it, matched by relative path
+type ValidationResult struct {
+	Matched []FileInfo // in both, same hash
+	Missing []FileInfo // in the manifest only
+	Extra   []FileInfo // in the directory only
+	Changed []FileInfo // in both, different hash; entries are from the directory
+}
+
+// OK reports whether the directory still matches the manifest exactly
+func (r *ValidationResult) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Changed) == 0
+}
+
+// ValidateDirectory compares a freshly walked directory against a manifest previously recorded for it
+func ValidateDirectory(manifest *DirectoryInfo, current *DirectoryInfo) *ValidationResult {
+	manifestFiles := make(map[string]FileInfo)
+	for _, file := range manifest.Files {
+		relPath, _ := filepath.Rel(manifest.BaseDir, file.Path)
+		manifestFiles[relPath] = file
+	}
+
+	result := &ValidationResult{}
+	for _, file := range current.Files {
+		relPath, _ := filepath.Rel(current.BaseDir, file.Path)
+		recorded, ok := manifestFiles[relPath]
+		switch {
+		case !ok:
+			result.Extra = append(result.Extra, file)
+		case recorded.Hash != file.Hash:
+			result.Changed = append(result.Changed, file)
+		default:
+			result.Matched = append(result.Matched, file)
+		}
+		delete(manifestFiles, relPath)
+	}
+	for _, file := range manifestFiles {
+		result.Missing = append(result.Missing, file)
+	}
+
+	for _, files := range [][]FileInfo{result.Matched, result.Missing, result.Extra, result.Changed} {
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	}
+	return result
+}
+
+// ValidationStatus classifies a file in a streaming validation, like the lists of ValidationResult
+type ValidationStatus int
+
+const (
+	ValidationMatched ValidationStatus = iota
+	ValidationMissing
+	ValidationExtra
+	ValidationChanged
+)
+
+// ValidationEvent reports one file of a streaming validation. File is the walked file, or the manifest entry
+// for ValidationMissing.
+type ValidationEvent struct {
+	Status ValidationStatus
+	File   FileInfo
+}
+
+// ValidationCounts totals the events of a streaming validation
+type ValidationCounts struct {
+	Matched, Missing, Extra, Changed int
+}
+
+// OK reports whether the directory still matches the manifest exactly
+func (c ValidationCounts) OK() bool {
+	return c.Missing == 0 && c.Extra == 0 && c.Changed == 0
+}
+
+// ValidateDirectoryStream is ValidateDirectory walking dir itself and calling emit for every file as soon as it
+// is hashed, without keeping the walked files: only the manifest stays in memory. Missing files are emitted last,
+// ordered by path. emit is never called concurrently. opts.Hooks, if set, still receives the walk events.
+// It fails before walking if opts would hash files differently from the manifest.
+func ValidateDirectoryStream(manifest *DirectoryInfo, dir string, opts WalkOptions, emit func(ValidationEvent)) (ValidationCounts, error) {
+	if err := checkHashSpecs("manifest", manifest.HashSpec, "directory", opts.hashSpec(), CompareOptions{}); err != nil {
+		return ValidationCounts{}, fmt.Errorf("cannot compare the hashes: %w", err)
+	}
+	manifestFiles := make(map[string]FileInfo)
+	for _, file := range manifest.Files {
+		relPath, _ := filepath.Rel(manifest.BaseDir, file.Path)
+		manifestFiles[relPath] = file
+	}
+
+	var counts ValidationCounts
+	root := filepath.Clean(dir)
+	hooks := &Hooks{
+		OnFileHashed: func(file FileInfo) {
+			relPath, _ := filepath.Rel(root, file.Path)
+			recorded, ok := manifestFiles[relPath]
+			event := ValidationEvent{Status: ValidationMatched, File: file}
+			switch {
+			case !ok:
+				event.Status = ValidationExtra
+				counts.Extra++
+			case recorded.Hash != file.Hash:
+				event.Status = ValidationChanged
+				counts.Changed++
+			default:
+				counts.Matched++
+			}
+			delete(manifestFiles, relPath)
+			emit(event)
+		},
+	}
+	if opts.Hooks != nil {
+		hooks.OnError = opts.Hooks.OnError
+		hooks.OnComplete = opts.Hooks.OnComplete
+		if onFileHashed := opts.Hooks.OnFileHashed; onFileHashed != nil {
+			validate := hooks.OnFileHashed
+			hooks.OnFileHashed = func(file FileInfo) {
+				onFileHashed(file)
+				validate(file)
+			}
+		}
+	}
+	opts.Hooks = hooks
+	opts.DiscardFiles = true
+	if _, err := WalkDirectoryWithOptions(root, opts); err != nil {
+		return counts, err
+	}
+
+	missing := make([]FileInfo, 0, len(manifestFiles))
+	for _, file := range manifestFiles {
+		missing = append(missing, file)
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Path < missing[j].Path })
+	for _, file := range missing {
+		counts.Missing++
+		emit(ValidationEvent{Status: ValidationMissing, File: file})
+	}
+	return counts, nil
+}
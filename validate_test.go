@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateDirectory(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"same.txt", "same"},
+		{"changed.txt", "before"},
+		{"removed.txt", "removed"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	manifest, err := WalkDirectory(testDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(testDir, "changed.txt"), []byte("after"), 0644)
+	os.Remove(filepath.Join(testDir, "removed.txt"))
+	os.WriteFile(filepath.Join(testDir, "added.txt"), []byte("added"), 0644)
+
+	current, err := WalkDirectory(testDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+
+	result := ValidateDirectory(manifest, current)
+	if result.OK() {
+		t.Errorf("Expected validation to fail")
+	}
+	for name, files := range map[string][]FileInfo{
+		"same.txt":    result.Matched,
+		"removed.txt": result.Missing,
+		"added.txt":   result.Extra,
+		"changed.txt": result.Changed,
+	} {
+		if len(files) != 1 || files[0].Path != filepath.Join(testDir, name) {
+			t.Errorf("Unexpected validation entries for %s: %v", name, files)
+		}
+	}
+
+	if result := ValidateDirectory(current, current); !result.OK() || len(result.Matched) != 3 {
+		t.Errorf("Expected a directory to validate against itself: %+v", result)
+	}
+}
+
+func TestValidateDirectoryStream(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"same.txt", "same"},
+		{"changed.txt", "before"},
+		{"removed.txt", "removed"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	manifest, err := WalkDirectory(testDir, 1, false)
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(testDir, "changed.txt"), []byte("after"), 0644)
+	os.Remove(filepath.Join(testDir, "removed.txt"))
+	os.WriteFile(filepath.Join(testDir, "added.txt"), []byte("added"), 0644)
+
+	statuses := make(map[string]ValidationStatus)
+	var last ValidationStatus
+	counts, err := ValidateDirectoryStream(manifest, testDir, WalkOptions{HashWorkers: 4}, func(event ValidationEvent) {
+		statuses[filepath.Base(event.File.Path)] = event.Status
+		last = event.Status
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := map[string]ValidationStatus{
+		"same.txt":    ValidationMatched,
+		"removed.txt": ValidationMissing,
+		"added.txt":   ValidationExtra,
+		"changed.txt": ValidationChanged,
+	}
+	for name, status := range want {
+		if statuses[name] != status {
+			t.Errorf("Unexpected status of %s: got %d, want %d", name, statuses[name], status)
+		}
+	}
+	if last != ValidationMissing {
+		t.Errorf("Expected missing files to be reported last")
+	}
+	if counts.OK() || counts != (ValidationCounts{Matched: 1, Missing: 1, Extra: 1, Changed: 1}) {
+		t.Errorf("Unexpected counts: %+v", counts)
+	}
+}
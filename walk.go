@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// walkTree calls visit for every non-directory entry under root, reading directories with up to
+// workers goroutines at once. visit receives the Lstat info of the entry and may be called concurrently.
+// The walk stops at the first error returned by visit, and returns it. Directories and entries that cannot
+// be read are passed to onError: they are skipped if it returns nil, otherwise the walk stops with its error.
+// Without onError, or if root itself cannot be read, the walk stops at the first such error.
+func walkTree(root string, workers int, visit func(path string, info os.FileInfo) error, onError func(path string, err error) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	rootInfo, err := os.Lstat(root)
+	if err != nil {
+		if onError != nil {
+			onError(root, err)
+		}
+		return err
+	}
+	if !rootInfo.IsDir() {
+		return visit(root, rootInfo)
+	}
+
+	q := &dirQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	q.push(root)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				dir, ok := q.pop()
+				if !ok {
+					return
+				}
+				if err := walkDir(dir, q, visit, onError); err != nil {
+					q.abort(err)
+				}
+				q.done()
+			}
+		}()
+	}
+	wg.Wait()
+	return q.err
+}
+
+// walkDir visits the non-directory entries of dir and queues its subdirectories
+func walkDir(dir string, q *dirQueue, visit func(path string, info os.FileInfo) error, onError func(path string, err error) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if onError == nil {
+			return err
+		}
+		// ReadDir returns the entries read before the error, which are still visited
+		if err := onError(dir, err); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			q.push(path)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			err = &os.PathError{Op: "lstat", Path: path, Err: err}
+			if onError == nil {
+				return err
+			}
+			if err := onError(path, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := visit(path, info); err != nil {
+			return err
+		}
+		if q.aborted() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// dirQueue is an unbounded work queue of directories that knows when the walk is complete:
+// once every queued directory is done and nothing new was queued
+type dirQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dirs    []string
+	pending int
+	err     error
+}
+
+func (q *dirQueue) push(dir string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dirs = append(q.dirs, dir)
+	q.pending++
+	q.cond.Signal()
+}
+
+// pop returns the next directory, blocking while other workers may still queue more
+func (q *dirQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.dirs) == 0 && q.pending > 0 && q.err == nil {
+		q.cond.Wait()
+	}
+	if len(q.dirs) == 0 || q.err != nil {
+		return "", false
+	}
+	dir := q.dirs[len(q.dirs)-1]
+	q.dirs = q.dirs[:len(q.dirs)-1]
+	return dir, true
+}
+
+func (q *dirQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+func (q *dirQueue) abort(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.cond.Broadcast()
+}
+
+func (q *dirQueue) aborted() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err != nil
+}
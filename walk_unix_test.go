@@ -0,0 +1,98 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWalkDirectorySkipsNamedPipes(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"regular.txt", "content"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	if err := unix.Mkfifo(filepath.Join(testDir, "pipe"), 0644); err != nil {
+		t.Skipf("Cannot create a named pipe: %v", err)
+	}
+
+	// hashing the pipe would block forever on open, so bound the walk
+	done := make(chan *DirectoryInfo, 1)
+	go func() {
+		dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{HashWorkers: 1, WarnSpecialFiles: true})
+		if err != nil {
+			t.Errorf("Error walking directory: %v", err)
+		}
+		done <- dirInfo
+	}()
+	select {
+	case dirInfo := <-done:
+		if dirInfo != nil && (len(dirInfo.Files) != 1 || filepath.Base(dirInfo.Files[0].Path) != "regular.txt") {
+			t.Errorf("Unexpected files: %v", dirInfo.Files)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Walk did not finish, it is probably blocked on the named pipe")
+	}
+}
+
+func TestWalkDirectoryCaptureInodes(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "linked"},
+		{"c.txt", "linked"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+	if err := os.Link(filepath.Join(testDir, "a.txt"), filepath.Join(testDir, "b.txt")); err != nil {
+		t.Skipf("Cannot create a hardlink: %v", err)
+	}
+
+	dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{CaptureInodes: true})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	inodes := make(map[string]uint64)
+	links := make(map[string]uint64)
+	for _, file := range dirInfo.Files {
+		inodes[filepath.Base(file.Path)] = file.Inode
+		links[filepath.Base(file.Path)] = file.Links
+	}
+	if inodes["a.txt"] == 0 || inodes["a.txt"] != inodes["b.txt"] || inodes["a.txt"] == inodes["c.txt"] {
+		t.Errorf("Unexpected inodes: %v", inodes)
+	}
+	if links["a.txt"] != 2 || links["b.txt"] != 2 || links["c.txt"] != 1 {
+		t.Errorf("Unexpected link counts: %v", links)
+	}
+}
+
+func TestWalkDirectoryInodesInMemory(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{{"a.txt", "linked"}})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	var manifest bytes.Buffer
+	dirInfo, err := WalkDirectoryWithOptions(testDir, WalkOptions{CaptureInodes: true, InodesInMemory: true, YamlOutput: &manifest})
+	if err != nil {
+		t.Fatalf("Error walking directory: %v", err)
+	}
+	if len(dirInfo.Files) != 1 || dirInfo.Files[0].Inode == 0 || dirInfo.Files[0].Links != 1 {
+		t.Errorf("Unexpected files: %+v", dirInfo.Files)
+	}
+	for _, field := range []string{"device:", "inode:", "links:"} {
+		if strings.Contains(manifest.String(), field) {
+			t.Errorf("Unexpected %s in the manifest:\n%s", field, manifest.String())
+		}
+	}
+}
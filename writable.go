@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ReadOnlyError is returned by CheckDeletable when files live on a read-only filesystem
+type ReadOnlyError struct {
+	Dir string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("%s is on a read-only filesystem", e.Dir)
+}
+
+// CheckDeletable checks up front that files can be deleted, without removing any of them. Deleting a file takes
+// write access to its directory, so a temporary file is created and removed in every directory holding some of
+// them. It returns a *ReadOnlyError if a directory is on a read-only filesystem (told apart on Unix only), or the
+// first other error.
+func CheckDeletable(files []FileInfo) error {
+	checked := make(map[string]bool)
+	for _, file := range files {
+		dir := filepath.Dir(file.Path)
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+
+		probe, err := os.CreateTemp(longPath(dir), ".deduplicator-write-check-*")
+		if err != nil {
+			return notDeletableError(dir, err)
+		}
+		probe.Close()
+		if err := os.Remove(probe.Name()); err != nil {
+			return fmt.Errorf("cannot delete files in %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// notDeletableError explains why the write check in dir failed with err
+func notDeletableError(dir string, err error) error {
+	if isReadOnlyFS(err) {
+		return &ReadOnlyError{Dir: dir}
+	}
+	return fmt.Errorf("cannot delete files in %s: %w", dir, err)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// isReadOnlyFS cannot tell a read-only filesystem on this platform
+func isReadOnlyFS(err error) bool {
+	return false
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckDeletable(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"a.txt", "a"},
+		{"sub/b.txt", "b"},
+		{"sub/c.txt", "c"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	files := []FileInfo{
+		{Path: filepath.Join(testDir, "a.txt")},
+		{Path: filepath.Join(testDir, "sub", "b.txt")},
+		{Path: filepath.Join(testDir, "sub", "c.txt")},
+	}
+	if err := CheckDeletable(files); err != nil {
+		t.Fatalf("Unexpected error for writable directories: %v", err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(testDir, "*", ".deduplicator-write-check-*"))
+	rootLeftovers, _ := filepath.Glob(filepath.Join(testDir, ".deduplicator-write-check-*"))
+	if len(leftovers)+len(rootLeftovers) > 0 {
+		t.Errorf("Unexpected probe files left behind: %v %v", rootLeftovers, leftovers)
+	}
+
+	missing := append(files, FileInfo{Path: filepath.Join(testDir, "gone", "d.txt")})
+	if err := CheckDeletable(missing); err == nil {
+		t.Error("Expected an error for a directory that does not exist")
+	}
+}
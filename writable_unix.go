@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isReadOnlyFS tells whether err comes from writing to a read-only filesystem
+func isReadOnlyFS(err error) bool {
+	return errors.Is(err, syscall.EROFS)
+}
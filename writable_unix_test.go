@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestNotDeletableError(t *testing.T) {
+	err := notDeletableError("/media/cdrom", &os.PathError{Op: "open", Path: "/media/cdrom/.deduplicator-write-check-1", Err: syscall.EROFS})
+	var readOnly *ReadOnlyError
+	if !errors.As(err, &readOnly) || readOnly.Dir != "/media/cdrom" {
+		t.Errorf("Unexpected error for a read-only filesystem: %v", err)
+	}
+
+	err = notDeletableError("/data", &os.PathError{Op: "open", Path: "/data/.deduplicator-write-check-1", Err: syscall.EACCES})
+	if errors.As(err, &readOnly) || !errors.Is(err, syscall.EACCES) {
+		t.Errorf("Unexpected error for a directory without write permission: %v", err)
+	}
+}
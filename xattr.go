@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// CalculateXattrDigest records a digest of the file's extended attribute names and values in XattrDigest.
+// Files without extended attributes, and platforms without xattr support, get an empty digest.
+func (f *FileInfo) CalculateXattrDigest() error {
+	attrs, err := readXattrs(longPath(f.Path))
+	if err != nil {
+		return err
+	}
+	f.XattrDigest = xattrDigest(attrs)
+	return nil
+}
+
+func xattrDigest(attrs map[string][]byte) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hasher := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hasher, "%d:%s%d:", len(name), name, len(attrs[name]))
+		hasher.Write(attrs[name])
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
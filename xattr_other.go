@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// readXattrs reports no extended attributes where reading them is not supported
+func readXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestXattrDigest(t *testing.T) {
+	if digest := xattrDigest(nil); digest != "" {
+		t.Errorf("Expected an empty digest without xattrs, got %q", digest)
+	}
+	a := xattrDigest(map[string][]byte{"com.apple.metadata:_kMDItemUserTags": []byte("red"), "user.x": []byte("1")})
+	b := xattrDigest(map[string][]byte{"user.x": []byte("1"), "com.apple.metadata:_kMDItemUserTags": []byte("red")})
+	c := xattrDigest(map[string][]byte{"user.x": []byte("1"), "com.apple.metadata:_kMDItemUserTags": []byte("blue")})
+	if a != b {
+		t.Errorf("Digest depends on attribute order: %s != %s", a, b)
+	}
+	if a == c {
+		t.Errorf("Digest ignores attribute values")
+	}
+}
+
+func TestCompareFilesCompareXattrs(t *testing.T) {
+	refDir := &DirectoryInfo{BaseDir: "/ref", Files: []FileInfo{
+		{Path: "/ref/tagged.jpg", Hash: "a", XattrDigest: "red"},
+		{Path: "/ref/plain.jpg", Hash: "b"},
+	}}
+	targetDir := &DirectoryInfo{BaseDir: "/target", Files: []FileInfo{
+		{Path: "/target/tagged.jpg", Hash: "a", XattrDigest: "blue"},
+		{Path: "/target/plain.jpg", Hash: "b"},
+	}}
+
+	if duplicates := CompareFiles(refDir, targetDir, true); len(duplicates) != 2 {
+		t.Errorf("Expected xattrs to be ignored by default, got %v", duplicates)
+	}
+	duplicates := CompareFilesWithOptions(refDir, targetDir, CompareOptions{ExactPathMatch: true, CompareXattrs: true})
+	if len(duplicates) != 1 || duplicates[0].Path != "/target/plain.jpg" {
+		t.Errorf("Unexpected duplicates with CompareXattrs: %v", duplicates)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err == unix.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string][]byte)
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		valueSize, err := unix.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Getxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+		attrs[string(name)] = value[:valueSize]
+	}
+	return attrs, nil
+}
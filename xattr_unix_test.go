@@ -0,0 +1,46 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReadXattrs(t *testing.T) {
+	testDir, err := createTestFiles([]struct{ Path, Content string }{
+		{"tagged.jpg", "photo"},
+		{"plain.jpg", "photo"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test files: %v", err)
+	}
+	defer removeTestFiles(testDir)
+
+	tagged := filepath.Join(testDir, "tagged.jpg")
+	if err := unix.Setxattr(tagged, "user.deduplicator.test", []byte("red"), 0); err != nil {
+		t.Skipf("File system does not support user xattrs: %v", err)
+	}
+
+	attrs, err := readXattrs(tagged)
+	if err != nil {
+		t.Fatalf("Error reading xattrs: %v", err)
+	}
+	if got := string(attrs["user.deduplicator.test"]); got != "red" {
+		t.Errorf("Unexpected xattr value: got %q, want %q", got, "red")
+	}
+
+	taggedInfo := FileInfo{Path: tagged}
+	plainInfo := FileInfo{Path: filepath.Join(testDir, "plain.jpg")}
+	for _, info := range []*FileInfo{&taggedInfo, &plainInfo} {
+		if err := info.CalculateXattrDigest(); err != nil {
+			t.Fatalf("Error calculating xattr digest of %s: %v", info.Path, err)
+		}
+	}
+	// plain.jpg may still carry attributes of the system, such as security.selinux
+	if taggedInfo.XattrDigest == "" || taggedInfo.XattrDigest == plainInfo.XattrDigest {
+		t.Errorf("Unexpected xattr digests: tagged %q, plain %q", taggedInfo.XattrDigest, plainInfo.XattrDigest)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// xxh64 is a streaming XXH64 (seed 0), a fast non-cryptographic hash for large files where
+// collision resistance against an attacker does not matter. Sum appends the digest big-endian.
+type xxh64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	buf            [32]byte
+	n              int // bytes buffered in buf
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func newXXH64() hash.Hash64 {
+	h := &xxh64{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh64) Reset() {
+	// the accumulators wrap around, which constant expressions may not
+	prime1, prime2 := xxPrime1, xxPrime2
+	h.v1 = prime1 + prime2
+	h.v2 = prime2
+	h.v3 = 0
+	h.v4 = -prime1
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxh64) Size() int      { return 8 }
+func (h *xxh64) BlockSize() int { return 32 }
+
+func (h *xxh64) Write(p []byte) (int, error) {
+	written := len(p)
+	h.total += uint64(written)
+
+	if h.n > 0 {
+		copied := copy(h.buf[h.n:], p)
+		h.n += copied
+		p = p[copied:]
+		if h.n < len(h.buf) {
+			return written, nil
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+	for len(p) >= 32 {
+		h.stripe(p[:32])
+		p = p[32:]
+	}
+	h.n = copy(h.buf[:], p)
+	return written, nil
+}
+
+// stripe consumes one 32-byte stripe into the four accumulators
+func (h *xxh64) stripe(b []byte) {
+	h.v1 = xxRound(h.v1, binary.LittleEndian.Uint64(b[0:8]))
+	h.v2 = xxRound(h.v2, binary.LittleEndian.Uint64(b[8:16]))
+	h.v3 = xxRound(h.v3, binary.LittleEndian.Uint64(b[16:24]))
+	h.v4 = xxRound(h.v4, binary.LittleEndian.Uint64(b[24:32]))
+}
+
+func (h *xxh64) Sum64() uint64 {
+	var sum uint64
+	if h.total >= 32 {
+		sum = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) + bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		sum = xxMergeRound(sum, h.v1)
+		sum = xxMergeRound(sum, h.v2)
+		sum = xxMergeRound(sum, h.v3)
+		sum = xxMergeRound(sum, h.v4)
+	} else {
+		sum = xxPrime5
+	}
+	sum += h.total
+
+	p := h.buf[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		sum ^= xxRound(0, binary.LittleEndian.Uint64(p[:8]))
+		sum = bits.RotateLeft64(sum, 27)*xxPrime1 + xxPrime4
+	}
+	if len(p) >= 4 {
+		sum ^= uint64(binary.LittleEndian.Uint32(p[:4])) * xxPrime1
+		sum = bits.RotateLeft64(sum, 23)*xxPrime2 + xxPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		sum ^= uint64(b) * xxPrime5
+		sum = bits.RotateLeft64(sum, 11) * xxPrime1
+	}
+
+	sum ^= sum >> 33
+	sum *= xxPrime2
+	sum ^= sum >> 29
+	sum *= xxPrime3
+	sum ^= sum >> 32
+	return sum
+}
+
+func (h *xxh64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}